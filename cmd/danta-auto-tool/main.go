@@ -3,8 +3,11 @@ package main
 import (
 	"dantaautotool/config"
 	"dantaautotool/internal/listener"
+	"dantaautotool/internal/repository"
 	"dantaautotool/internal/service"
+	"dantaautotool/pkg"
 	"dantaautotool/pkg/utils/http"
+	"flag"
 	"fmt"
 	"os"
 	"time"
@@ -25,6 +28,10 @@ const HELLO = "\n" +
 
 func main() {
 
+	// Parse command line arguments
+	configFile := flag.String("config-file", "", "path to the configuration file (.json or .toml), environment variables override its values")
+	flag.Parse()
+
 	// Record the start time
 	t := time.Now()
 	log.Info().Msgf("[main] Start time: %s", t.Format(time.RFC3339))
@@ -41,7 +48,11 @@ func main() {
 	}
 
 	// Load configuration
-	config.LoadConfig()
+	err = config.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("[main] Failed to load configuration")
+		return
+	}
 
 	// Initialize Lark client
 	err = http.InitLarkClient()
@@ -50,15 +61,77 @@ func main() {
 		return
 	}
 
+	// Open local store
+	db, err := repository.OpenBoltDB(config.Config.StorePath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("[main] Failed to open local store")
+		return
+	}
+	defer db.Close()
+	bannerApplicationRepository, err := repository.NewBannerApplicationRepository(db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("[main] Failed to initialize banner application repository")
+		return
+	}
+	larkUserTokenRepository, err := repository.NewLarkUserTokenRepository(db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("[main] Failed to initialize Lark user token repository")
+		return
+	}
+	notificationOutboxRepository, err := repository.NewNotificationOutboxRepository(db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("[main] Failed to initialize notification outbox repository")
+		return
+	}
+
 	// Initialize services
+	emailTemplateService, err := service.NewEmailTemplateService()
+	if err != nil {
+		log.Fatal().Err(err).Msg("[main] Failed to load email templates")
+		return
+	}
 	larkIMService := service.NewLarkIMService()
-	larkEmailService := service.NewLarkEmailService()
+	var emailService service.LarkEmailServiceIntf
+	switch config.Config.EmailBackend {
+	case pkg.EMAIL_BACKEND_LARK:
+		larkUserTokenManager, err := service.NewLarkUserTokenManager(larkIMService, larkUserTokenRepository)
+		if err != nil {
+			log.Fatal().Err(err).Msg("[main] Failed to initialize Lark user token manager")
+			return
+		}
+		// Start refreshing Lark user access token
+		larkUserTokenManager.Start()
+		emailService = service.NewLarkEmailService(larkUserTokenManager)
+	case pkg.EMAIL_BACKEND_SMTP:
+		emailService, err = service.NewSMTPEmailService()
+		if err != nil {
+			log.Fatal().Err(err).Msg("[main] Failed to initialize SMTP email service")
+			return
+		}
+	default:
+		log.Fatal().Msgf("[main] Invalid email backend: %s", config.Config.EmailBackend)
+		return
+	}
+	notificationOutbox := service.NewNotificationOutbox(emailService, larkIMService, notificationOutboxRepository)
 	larkDocService := service.NewLarkDocService()
-	githubService := service.NewGithubService()
-	dantaService := service.NewDantaService(larkDocService, larkEmailService, githubService)
+	larkContactService := service.NewLarkContactService()
+	githubService, err := service.NewGithubService()
+	if err != nil {
+		log.Fatal().Err(err).Msg("[main] Failed to initialize Github service")
+		return
+	}
+	dantaService := service.NewDantaService(larkDocService, githubService, larkIMService, bannerApplicationRepository, emailTemplateService, notificationOutbox)
+	bannerScheduler := service.NewBannerScheduler(dantaService, bannerApplicationRepository)
+	bannerVoteService := service.NewBannerVoteService(bannerApplicationRepository)
+
+	// Start delivering notification emails
+	notificationOutbox.Start()
+
+	// Start banner scheduler
+	bannerScheduler.Start()
 
 	// Initialize listeners
-	larkListener := listener.NewLarkListener(larkDocService, larkIMService, dantaService)
+	larkListener := listener.NewLarkListener(larkDocService, larkIMService, larkContactService, dantaService, bannerScheduler, bannerVoteService, notificationOutbox, bannerApplicationRepository)
 	if larkListener == nil {
 		log.Fatal().Msg("[main] Failed to create LarkListener")
 		return
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useConfigFile writes the content to a config file with the given name in a temporary directory, and returns its path.
+// The environment variables of the required keys are cleared, so that they are set by the file only.
+func useConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	for _, key := range []string{"LARK_APP_ID", "LARK_APP_SECRET", "LARK_BANNER_APPROVE_CARD_ID", "GITHUB_CONFLICT_MAX_ATTEMPTS", "GITHUB_REVIEW_MODE", "GITHUB_RETRY_MAX_ATTEMPTS", "GITHUB_DANXI_REPO_BRANCH"} {
+		t.Setenv(key, "")
+	}
+	original := Config
+	t.Cleanup(func() {
+		Config = original
+	})
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+// requiredKeys are the required keys except the Lark ones, which the tests set.
+const requiredKeys = `
+lark_banner_bitable_app_token = "app"
+lark_banner_bitable_application_table_id = "application"
+lark_banner_bitable_usage_table_id = "usage"
+lark_banner_approve_group_id = "group"
+danta_dev_email = "dev@example.com"
+github_danxi_repo_owner = "DanXi-Dev"
+github_danxi_repo_name = "DanXi-Static"
+github_danxi_repo_app_config_path = "public/config.toml"
+`
+
+func TestLoadConfigDefaults(t *testing.T) {
+	path := useConfigFile(t, "config.toml", `lark_app_id = "id"
+lark_app_secret = "secret"
+lark_banner_approve_card_id = "card"
+github_conflict_max_attempts = 0
+github_danxi_repo_branch = ""
+`+requiredKeys)
+
+	if err := LoadConfig(path); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	// an explicit zero is kept, and the keys not set or set to an empty string fall back to their defaults
+	if Config.GithubConflictMaxAttempts != 0 {
+		t.Errorf("GithubConflictMaxAttempts = %d, want the explicit 0", Config.GithubConflictMaxAttempts)
+	}
+	if Config.GithubRetryMaxAttempts != 3 || Config.GithubDanxiRepoBranch != "main" {
+		t.Errorf("GithubRetryMaxAttempts = %d, GithubDanxiRepoBranch = %q, want the defaults", Config.GithubRetryMaxAttempts, Config.GithubDanxiRepoBranch)
+	}
+
+	// environment variables override the file, with zero values too
+	t.Setenv("GITHUB_CONFLICT_MAX_ATTEMPTS", "5")
+	t.Setenv("GITHUB_RETRY_MAX_ATTEMPTS", "0")
+	if err := LoadConfig(path); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if Config.GithubConflictMaxAttempts != 5 || Config.GithubRetryMaxAttempts != 0 {
+		t.Errorf("GithubConflictMaxAttempts = %d, GithubRetryMaxAttempts = %d, want 5 and 0 from the environment", Config.GithubConflictMaxAttempts, Config.GithubRetryMaxAttempts)
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	path := useConfigFile(t, "config.json", `{
+    "lark_app_id": "id",
+    "lark_app_secret": "secret",
+    "lark_banner_approve_card_id": "card",
+    "lark_banner_bitable_app_token": "app",
+    "lark_banner_bitable_application_table_id": "application",
+    "lark_banner_bitable_usage_table_id": "usage",
+    "lark_banner_approve_group_id": "group",
+    "danta_dev_email": "dev@example.com",
+    "github_danxi_repo_owner": "DanXi-Dev",
+    "github_danxi_repo_name": "DanXi-Static",
+    "github_danxi_repo_app_config_path": "public/config.toml",
+    "github_conflict_max_attempts": 0
+}`)
+
+	if err := LoadConfig(path); err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if Config.GithubConflictMaxAttempts != 0 || Config.GithubRetryMaxAttempts != 3 {
+		t.Errorf("GithubConflictMaxAttempts = %d, GithubRetryMaxAttempts = %d, want the explicit 0 and the default 3", Config.GithubConflictMaxAttempts, Config.GithubRetryMaxAttempts)
+	}
+}
+
+func TestLoadConfigMissingRequiredKeys(t *testing.T) {
+	path := useConfigFile(t, "config.toml", `lark_app_id = "id"`+"\n"+requiredKeys)
+	Config.LarkAppID = "unchanged"
+
+	err := LoadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "lark_app_secret (LARK_APP_SECRET)") || !strings.Contains(err.Error(), "lark_banner_approve_card_id (LARK_BANNER_APPROVE_CARD_ID)") {
+		t.Errorf("LoadConfig() error = %v, want both missing keys listed", err)
+	}
+	if Config.LarkAppID != "unchanged" {
+		t.Errorf("Config changed by a failed LoadConfig(): %+v", Config)
+	}
+}
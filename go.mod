@@ -6,6 +6,7 @@ require (
 	github.com/cloudwego/hertz v0.9.6
 	github.com/larksuite/oapi-sdk-go/v3 v3.4.11
 	github.com/rs/zerolog v1.33.0
+	go.etcd.io/bbolt v1.3.11
 	go.starlark.net v0.0.0-20250225190231-0d3f41d403af
 )
 
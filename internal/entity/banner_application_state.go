@@ -0,0 +1,52 @@
+package entity
+
+// BannerApplicationState represents the state of a banner application throughout its lifetime,
+// which is persisted in the local store, so that it survives restarts.
+type BannerApplicationState struct {
+	// RecordID is the ID of the application record in the application bitable, which identifies the application.
+	RecordID string `json:"record_id"`
+
+	// BannerUsageLog holds the application, and the names of the approvers once it is approved.
+	BannerUsageLog
+
+	// Status is one of the banner statuses in pkg, e.g. pkg.BANNER_STATUS_PENDING.
+	Status string `json:"status"`
+
+	// Approvers and Disapprovers are the open IDs of the voters, in the order of voting.
+	Approvers    []string `json:"approvers"`
+	Disapprovers []string `json:"disapprovers"`
+
+	// DisapproveReasons are the reasons given by the disapprovers, keyed by their open IDs.
+	DisapproveReasons map[string]string `json:"disapprove_reasons"`
+
+	// Published indicates whether the banner is in the config file, i.e. it has been added and not taken down yet.
+	Published bool `json:"published"`
+
+	// CommitSHA is the SHA of the commit adding the banner to the config file.
+	CommitSHA string `json:"commit_sha"`
+
+	// The following timestamps are Unix timestamps in milliseconds, and zero means the event has not happened yet.
+	CreatedAt   int64 `json:"created_at"`
+	UpdatedAt   int64 `json:"updated_at"`
+	DecidedAt   int64 `json:"decided_at"`
+	PublishedAt int64 `json:"published_at"`
+	WithdrawnAt int64 `json:"withdrawn_at"`
+
+	// VoteCardSentAt and UsageLoggedAt record when the vote card is sent and when the usage log is added,
+	// so that neither happens twice for an application, even if the events are redelivered.
+	VoteCardSentAt int64 `json:"vote_card_sent_at"`
+	UsageLoggedAt  int64 `json:"usage_logged_at"`
+
+	// UsageRecordID is the ID of the record added to the usage table for the application,
+	// which is the only usage record updated when the application is withdrawn.
+	UsageRecordID string `json:"usage_record_id"`
+}
+
+// BannerConfigChange represents a change committed to the banner config file.
+type BannerConfigChange struct {
+	// CommitSHA is the SHA of the commit.
+	CommitSHA string `json:"commit_sha"`
+
+	// Link is the link to the commit, or to the pull request in review mode.
+	Link string `json:"link"`
+}
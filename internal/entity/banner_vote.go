@@ -0,0 +1,18 @@
+package entity
+
+// BannerVoteTally represents the voting result of a banner application.
+type BannerVoteTally struct {
+	// Approvers and Disapprovers are the open IDs of the voters, in the order of voting.
+	Approvers    []string `json:"approvers"`
+	Disapprovers []string `json:"disapprovers"`
+
+	// DisapproveReasons are the reasons given by the disapprovers, in the same order as Disapprovers.
+	DisapproveReasons []string `json:"disapprove_reasons"`
+
+	// ApproveQuorum and DisapproveQuorum are the numbers of votes needed to approve or disapprove the application.
+	ApproveQuorum    int `json:"approve_quorum"`
+	DisapproveQuorum int `json:"disapprove_quorum"`
+
+	// Status is one of pkg.BANNER_STATUS_PENDING, pkg.BANNER_STATUS_APPROVED and pkg.BANNER_STATUS_DISAPPROVED.
+	Status string `json:"status"`
+}
@@ -24,14 +24,17 @@ type Banner struct {
 type BannerApplication struct {
 	Banner
 	ApplicantEmail string `json:"applicant_email" toml:"applicant_email"`
+	// Both StartDate and EndDate are Unix timestamps in milliseconds, the same as Lark bitable date fields.
+	// Zero means the date is not specified, i.e. the banner is published at once, or never taken down automatically.
+	StartDate int64 `json:"start_date" toml:"start_date"`
+	EndDate   int64 `json:"end_date" toml:"end_date"`
 }
 
 // BannerUsageLog represents a single banner usage log entry.
 type BannerUsageLog struct {
 	BannerApplication
-	// Both StartDate and EndDate are Unix timestamps.
-	StartDate int64 `json:"start_date" toml:"start_date"`
-	EndDate   int64 `json:"end_date" toml:"end_date"`
+	// Approver is the name of the person who approved the application.
+	Approver string `json:"approver" toml:"approver"`
 }
 
 // Celebration represents a single celebration entry.
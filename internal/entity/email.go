@@ -0,0 +1,30 @@
+package entity
+
+// RenderedEmail represents an email rendered from a template.
+type RenderedEmail struct {
+	Subject       string `json:"subject"`
+	BodyPlainText string `json:"body_plain_text"`
+	// BodyHtml is empty if the template has no HTML body.
+	BodyHtml string `json:"body_html"`
+}
+
+// BannerEmailData holds the variables available in banner notification email templates.
+type BannerEmailData struct {
+	Title          string `json:"title"`
+	Action         string `json:"action"`
+	Button         string `json:"button"`
+	ApplicantEmail string `json:"applicant_email"`
+
+	// StartDate and EndDate are formatted as "2006-01-02", or "-" if not specified.
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+
+	// Approver is the names of the approvers, empty if the application is not approved.
+	Approver string `json:"approver"`
+
+	// CommitLink is the link to the commit (or pull request) changing the config file, empty if there is none.
+	CommitLink string `json:"commit_link"`
+
+	// Reasons are the reasons of disapprovals, empty if the application is not disapproved.
+	Reasons []string `json:"reasons"`
+}
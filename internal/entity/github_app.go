@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+// InstallationAccessToken represents the response structure for the GitHub API's "Create an installation access token for an app" endpoint.
+// Only the fields we care about are mapped.
+//
+// Reference: https://docs.github.com/en/rest/apps/apps?apiVersion=2022-11-28#create-an-installation-access-token-for-an-app
+type InstallationAccessToken struct {
+	// Token is the installation access token, used as "Bearer <token>" in the Authorization header.
+	Token string `json:"token"`
+
+	// ExpiresAt is the time when the token expires, one hour after it is created.
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,65 @@
+package entity
+
+// GitRef represents a Git reference, e.g. a branch.
+//
+// Reference: https://docs.github.com/en/rest/git/refs?apiVersion=2022-11-28
+type GitRef struct {
+	// Ref is the full name of the reference, e.g. "refs/heads/main".
+	Ref string `json:"ref"`
+
+	// Object is the Git object the reference points to.
+	Object GitRefObject `json:"object"`
+}
+
+// GitRefObject represents the Git object a reference points to.
+type GitRefObject struct {
+	// Type specifies the type of the object (e.g., "commit").
+	Type string `json:"type"`
+
+	// SHA is the SHA of the object.
+	SHA string `json:"sha"`
+}
+
+// CreateRefRequest represents the request body for creating a reference.
+type CreateRefRequest struct {
+	Ref string `json:"ref"`
+	SHA string `json:"sha"`
+}
+
+// CreatePullRequestRequest represents the request body for creating a pull request.
+//
+// Reference: https://docs.github.com/en/rest/pulls/pulls?apiVersion=2022-11-28#create-a-pull-request
+type CreatePullRequestRequest struct {
+	Title string `json:"title"`
+	Head  string `json:"head"`
+	Base  string `json:"base"`
+	Body  string `json:"body,omitempty"`
+}
+
+// PullRequest represents a pull request. Only the fields we care about are mapped.
+type PullRequest struct {
+	// Number is the number of the pull request in the repository.
+	Number int `json:"number"`
+
+	// State specifies the state of the pull request (e.g., "open", "closed").
+	State string `json:"state"`
+
+	// Title is the title of the pull request.
+	Title string `json:"title"`
+
+	// HTMLURL is the URL to view the pull request on GitHub's web interface.
+	HTMLURL string `json:"html_url"`
+
+	// Head and Base are the branches the pull request merges from and into.
+	Head PullRequestBranch `json:"head"`
+	Base PullRequestBranch `json:"base"`
+}
+
+// PullRequestBranch represents a branch of a pull request.
+type PullRequestBranch struct {
+	// Ref is the name of the branch, e.g. "main".
+	Ref string `json:"ref"`
+
+	// Label is the name of the branch prefixed with its owner, e.g. "octocat:main".
+	Label string `json:"label"`
+}
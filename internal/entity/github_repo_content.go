@@ -76,3 +76,24 @@ type Committer struct {
 	Name  string `json:"name"`
 	Email string `json:"email"`
 }
+
+// CreateOrUpdateFileContentResponse represents the response structure for the GitHub API's "Create or update file contents" endpoint.
+// Only the fields we care about are mapped.
+//
+// Reference: https://docs.github.com/en/rest/repos/contents?apiVersion=2022-11-28#create-or-update-file-contents
+type CreateOrUpdateFileContentResponse struct {
+	// Commit is the commit created by the request.
+	Commit GitCommit `json:"commit"`
+}
+
+// GitCommit represents a Git commit.
+type GitCommit struct {
+	// SHA is the SHA of the commit.
+	SHA string `json:"sha"`
+
+	// HTMLURL is the URL to view the commit on GitHub's web interface.
+	HTMLURL string `json:"html_url"`
+
+	// Message is the commit message.
+	Message string `json:"message"`
+}
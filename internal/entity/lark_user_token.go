@@ -0,0 +1,21 @@
+package entity
+
+// LarkUserToken represents a user access token of Lark and the refresh token to renew it,
+// which is persisted in the local store, so that the refreshed pair survives restarts.
+type LarkUserToken struct {
+	// AccessToken is the user access token, used to call APIs on behalf of the user, e.g. sending emails.
+	AccessToken string `json:"access_token"`
+
+	// RefreshToken is used to get a new pair of tokens before the access token expires.
+	// A refresh token can only be used once.
+	RefreshToken string `json:"refresh_token"`
+
+	// SeedRefreshToken is the refresh token given in the configuration, from which this pair descends.
+	// A different refresh token in the configuration means the user has authorized again, and replaces the stored pair.
+	SeedRefreshToken string `json:"seed_refresh_token"`
+
+	// The following timestamps are Unix timestamps in milliseconds, and zero means unknown.
+	ExpiresAt        int64 `json:"expires_at"`
+	RefreshExpiresAt int64 `json:"refresh_expires_at"`
+	RefreshedAt      int64 `json:"refreshed_at"`
+}
@@ -0,0 +1,30 @@
+package entity
+
+// Notification represents an email to one recipient in the outbox,
+// which is persisted in the local store and delivered by a background worker until it succeeds or runs out of attempts.
+// A notification is deleted once it is sent, so the store only holds pending notifications and dead letters.
+type Notification struct {
+	// ID identifies the notification, and orders notifications by the time they are enqueued.
+	ID string `json:"id"`
+
+	// TemplateName is the name of the email template the email is rendered from.
+	TemplateName string `json:"template_name"`
+
+	// Recipient is the email address of the recipient.
+	Recipient string `json:"recipient"`
+
+	// Email is the rendered email.
+	Email RenderedEmail `json:"email"`
+
+	// Status is one of pkg.NOTIFICATION_STATUS_PENDING and pkg.NOTIFICATION_STATUS_DEAD.
+	Status string `json:"status"`
+
+	// Attempts is the number of failed delivery attempts, and LastError is the error of the last one.
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+
+	// The following timestamps are Unix timestamps in milliseconds, and zero means the event has not happened yet.
+	CreatedAt     int64 `json:"created_at"`
+	UpdatedAt     int64 `json:"updated_at"`
+	NextAttemptAt int64 `json:"next_attempt_at"`
+}
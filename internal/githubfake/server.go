@@ -0,0 +1,488 @@
+// Package githubfake provides an in-process fake of the Github REST API used by GithubService,
+// so that the services working with the banner config file can be exercised end-to-end without Github.
+//
+// Point GITHUB_API_BASE_URL at Server.URL, seed the files by SetFile, and inspect the result by File:
+//
+//	server := githubfake.NewServer("main")
+//	defer server.Close()
+//	server.SetFile("owner", "repo", "main", "public/config.toml", "")
+//	config.Config.GithubAPIBaseURL = server.URL
+//	githubService, err := service.NewGithubService()
+package githubfake
+
+import (
+	"crypto/sha1"
+	"dantaautotool/internal/entity"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/rs/zerolog/log"
+)
+
+// Server is a fake Github API server supporting the endpoints called by GithubService:
+//   - GET and PUT /repos/{owner}/{repo}/contents/{path}
+//   - GET /repos/{owner}/{repo}/git/ref/{ref} and POST /repos/{owner}/{repo}/git/refs
+//   - DELETE /repos/{owner}/{repo}/git/refs/{ref}
+//   - GET and POST /repos/{owner}/{repo}/pulls
+//   - POST /app/installations/{installation_id}/access_tokens
+//
+// Like Github, it keeps a commit for every change, so that files can be read at a branch or at a commit SHA,
+// and it rejects an update with 409 Conflict if the given blob SHA is not the one of the file at the head of the branch.
+// Like Github, it rejects a pull request with 422 Unprocessable Entity if an open one merges the same branches,
+// and closes the open pull requests of a branch when the branch is deleted.
+// Authentication is not checked, except that installation access tokens are only issued for requests with a JWT.
+type Server struct {
+	*httptest.Server
+
+	// InstallationTokenTTL is how long an issued installation access token is valid, an hour by default as Github does
+	InstallationTokenTTL time.Duration
+
+	// defaultBranch is the branch used when a request does not specify one
+	defaultBranch string
+
+	// mu protects repos, commitCount and installationTokens
+	mu sync.Mutex
+
+	// installationTokens are the installation access tokens issued, in order
+	installationTokens []string
+
+	// repos are the repositories, keyed by "owner/repo"
+	repos map[string]*fakeRepo
+
+	// commitCount is the number of commits created, used to make commit SHAs unique
+	commitCount int
+}
+
+// fakeRepo is a repository in the fake server.
+type fakeRepo struct {
+	// branches maps branch names to the SHAs of their head commits
+	branches map[string]string
+
+	// commits maps commit SHAs to the files at the commits, keyed by path
+	commits map[string]map[string]string
+
+	// pullRequests are the pull requests opened, in order
+	pullRequests []*entity.PullRequest
+}
+
+// NewServer starts a fake Github API server, whose repositories have the given default branch.
+// The caller should call Close when finished, to shut it down.
+func NewServer(defaultBranch string) *Server {
+	s := &Server{
+		InstallationTokenTTL: time.Hour,
+		defaultBranch:        defaultBranch,
+		repos:                make(map[string]*fakeRepo),
+	}
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc("GET /repos/{owner}/{repo}/contents/{path...}", s.handleGetContent)
+	mux.HandleFunc("PUT /repos/{owner}/{repo}/contents/{path...}", s.handlePutContent)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/git/ref/{ref...}", s.handleGetRef)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/git/refs", s.handleCreateRef)
+	mux.HandleFunc("DELETE /repos/{owner}/{repo}/git/refs/{ref...}", s.handleDeleteRef)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/pulls", s.handleListPullRequests)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/pulls", s.handleCreatePullRequest)
+	mux.HandleFunc("POST /app/installations/{installation_id}/access_tokens", s.handleCreateInstallationToken)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// SetFile commits the content of a file to the branch, creating the repository and the branch if they do not exist.
+// It returns the SHA of the commit.
+func (s *Server) SetFile(owner, repo, branch, path, content string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.repo(owner, repo)
+	return s.commit(r, branch, path, content)
+}
+
+// File returns the content of a file and its blob SHA at the given ref, a branch or a commit SHA.
+// It returns false if the file does not exist.
+func (s *Server) File(owner, repo, ref, path string) (string, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := s.files(owner, repo, ref)
+	content, ok := files[path]
+	if !ok {
+		return "", "", false
+	}
+	return content, blobSHA(content), true
+}
+
+// Branches returns the head commit SHAs of the branches in the repository, keyed by branch name.
+func (s *Server) Branches(owner, repo string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	branches := make(map[string]string)
+	if r, ok := s.repos[owner+"/"+repo]; ok {
+		for name, sha := range r.branches {
+			branches[name] = sha
+		}
+	}
+	return branches
+}
+
+// PullRequests returns the pull requests opened in the repository, in order.
+func (s *Server) PullRequests(owner, repo string) []entity.PullRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pullRequests []entity.PullRequest
+	if r, ok := s.repos[owner+"/"+repo]; ok {
+		for _, pullRequest := range r.pullRequests {
+			pullRequests = append(pullRequests, *pullRequest)
+		}
+	}
+	return pullRequests
+}
+
+// InstallationTokens returns the installation access tokens issued, in order.
+func (s *Server) InstallationTokens() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.installationTokens...)
+}
+
+// handleGetContent handles GET /repos/{owner}/{repo}/contents/{path}, with an optional ref query param.
+func (s *Server) handleGetContent(w nethttp.ResponseWriter, r *nethttp.Request) {
+	owner, repo, path := r.PathValue("owner"), r.PathValue("repo"), r.PathValue("path")
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		ref = s.defaultBranch
+	}
+
+	content, sha, ok := s.File(owner, repo, ref, path)
+	if !ok {
+		writeError(w, nethttp.StatusNotFound, "Not Found")
+		return
+	}
+	name := path[strings.LastIndex(path, "/")+1:]
+	writeJSON(w, nethttp.StatusOK, entity.GetRepoContentResponse{
+		Type:     "file",
+		Encoding: "base64",
+		Size:     len(content),
+		Name:     name,
+		Path:     path,
+		Content:  base64.StdEncoding.EncodeToString([]byte(content)),
+		SHA:      sha,
+		URL:      fmt.Sprintf("%s/repos/%s/%s/contents/%s?ref=%s", s.URL, owner, repo, path, ref),
+		HTMLURL:  fmt.Sprintf("%s/%s/%s/blob/%s/%s", s.URL, owner, repo, ref, path),
+	})
+}
+
+// handlePutContent handles PUT /repos/{owner}/{repo}/contents/{path}.
+// Updating an existing file requires the blob SHA of the file at the head of the branch.
+func (s *Server) handlePutContent(w nethttp.ResponseWriter, r *nethttp.Request) {
+	owner, repo, path := r.PathValue("owner"), r.PathValue("repo"), r.PathValue("path")
+	var req entity.CreateOrUpdateFileContentRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, nethttp.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	content, err := base64.StdEncoding.DecodeString(req.Content)
+	if err != nil {
+		writeError(w, nethttp.StatusUnprocessableEntity, "content is not valid Base64")
+		return
+	}
+	branch := req.Branch
+	if branch == "" {
+		branch = s.defaultBranch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fr, ok := s.repos[owner+"/"+repo]
+	if !ok {
+		writeError(w, nethttp.StatusNotFound, "Not Found")
+		return
+	}
+	head, ok := fr.branches[branch]
+	if !ok {
+		writeError(w, nethttp.StatusNotFound, "Branch "+branch+" not found")
+		return
+	}
+	oldContent, exists := fr.commits[head][path]
+	switch {
+	case exists && req.SHA == "":
+		writeError(w, nethttp.StatusUnprocessableEntity, "Invalid request.\n\n\"sha\" wasn't supplied.")
+		return
+	case exists && req.SHA != blobSHA(oldContent):
+		writeError(w, nethttp.StatusConflict, fmt.Sprintf("%s does not match %s", path, req.SHA))
+		return
+	}
+
+	commitSHA := s.commit(fr, branch, path, string(content))
+	log.Debug().Msgf("[Server.handlePutContent] File committed, repo: %s/%s, branch: %s, path: %s, commit: %s", owner, repo, branch, path, commitSHA)
+	statusCode := nethttp.StatusCreated
+	if exists {
+		statusCode = nethttp.StatusOK
+	}
+	writeJSON(w, statusCode, entity.CreateOrUpdateFileContentResponse{
+		Commit: entity.GitCommit{
+			SHA:     commitSHA,
+			HTMLURL: fmt.Sprintf("%s/%s/%s/commit/%s", s.URL, owner, repo, commitSHA),
+			Message: req.Message,
+		},
+	})
+}
+
+// handleGetRef handles GET /repos/{owner}/{repo}/git/ref/{ref}, where ref is like "heads/main".
+func (s *Server) handleGetRef(w nethttp.ResponseWriter, r *nethttp.Request) {
+	owner, repo, ref := r.PathValue("owner"), r.PathValue("repo"), r.PathValue("ref")
+	branch, ok := strings.CutPrefix(ref, "heads/")
+	if !ok {
+		writeError(w, nethttp.StatusNotFound, "Not Found")
+		return
+	}
+	sha, ok := s.Branches(owner, repo)[branch]
+	if !ok {
+		writeError(w, nethttp.StatusNotFound, "Not Found")
+		return
+	}
+	writeJSON(w, nethttp.StatusOK, entity.GitRef{
+		Ref:    "refs/" + ref,
+		Object: entity.GitRefObject{Type: "commit", SHA: sha},
+	})
+}
+
+// handleCreateRef handles POST /repos/{owner}/{repo}/git/refs, creating a branch at an existing commit.
+func (s *Server) handleCreateRef(w nethttp.ResponseWriter, r *nethttp.Request) {
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	var req entity.CreateRefRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, nethttp.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	branch, ok := strings.CutPrefix(req.Ref, "refs/heads/")
+	if !ok || branch == "" {
+		writeError(w, nethttp.StatusUnprocessableEntity, "Reference name is invalid")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fr, ok := s.repos[owner+"/"+repo]
+	if !ok {
+		writeError(w, nethttp.StatusNotFound, "Not Found")
+		return
+	}
+	if _, ok := fr.commits[req.SHA]; !ok {
+		writeError(w, nethttp.StatusUnprocessableEntity, "Object does not exist")
+		return
+	}
+	if _, ok := fr.branches[branch]; ok {
+		writeError(w, nethttp.StatusUnprocessableEntity, "Reference already exists")
+		return
+	}
+	fr.branches[branch] = req.SHA
+	writeJSON(w, nethttp.StatusCreated, entity.GitRef{
+		Ref:    req.Ref,
+		Object: entity.GitRefObject{Type: "commit", SHA: req.SHA},
+	})
+}
+
+// handleDeleteRef handles DELETE /repos/{owner}/{repo}/git/refs/{ref}, where ref is like "heads/feature".
+func (s *Server) handleDeleteRef(w nethttp.ResponseWriter, r *nethttp.Request) {
+	owner, repo, ref := r.PathValue("owner"), r.PathValue("repo"), r.PathValue("ref")
+	branch, ok := strings.CutPrefix(ref, "heads/")
+	if !ok {
+		writeError(w, nethttp.StatusUnprocessableEntity, "Reference does not exist")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fr, ok := s.repos[owner+"/"+repo]
+	if !ok {
+		writeError(w, nethttp.StatusNotFound, "Not Found")
+		return
+	}
+	if _, ok := fr.branches[branch]; !ok {
+		writeError(w, nethttp.StatusUnprocessableEntity, "Reference does not exist")
+		return
+	}
+	delete(fr.branches, branch)
+	for _, pullRequest := range fr.pullRequests {
+		if pullRequest.Head.Ref == branch {
+			pullRequest.State = "closed"
+		}
+	}
+	w.WriteHeader(nethttp.StatusNoContent)
+}
+
+// handleCreatePullRequest handles POST /repos/{owner}/{repo}/pulls.
+func (s *Server) handleCreatePullRequest(w nethttp.ResponseWriter, r *nethttp.Request) {
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	var req entity.CreatePullRequestRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, nethttp.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fr, ok := s.repos[owner+"/"+repo]
+	if !ok {
+		writeError(w, nethttp.StatusNotFound, "Not Found")
+		return
+	}
+	_, headExists := fr.branches[req.Head]
+	_, baseExists := fr.branches[req.Base]
+	if !headExists || !baseExists {
+		writeError(w, nethttp.StatusUnprocessableEntity, "Validation Failed")
+		return
+	}
+	for _, pullRequest := range fr.pullRequests {
+		if pullRequest.State == "open" && pullRequest.Head.Ref == req.Head && pullRequest.Base.Ref == req.Base {
+			writeError(w, nethttp.StatusUnprocessableEntity, fmt.Sprintf("A pull request already exists for %s:%s.", owner, req.Head))
+			return
+		}
+	}
+	number := len(fr.pullRequests) + 1
+	pullRequest := &entity.PullRequest{
+		Number:  number,
+		State:   "open",
+		Title:   req.Title,
+		HTMLURL: fmt.Sprintf("%s/%s/%s/pull/%d", s.URL, owner, repo, number),
+		Head:    entity.PullRequestBranch{Ref: req.Head, Label: owner + ":" + req.Head},
+		Base:    entity.PullRequestBranch{Ref: req.Base, Label: owner + ":" + req.Base},
+	}
+	fr.pullRequests = append(fr.pullRequests, pullRequest)
+	writeJSON(w, nethttp.StatusCreated, pullRequest)
+}
+
+// handleListPullRequests handles GET /repos/{owner}/{repo}/pulls, with optional state, head and base query params.
+// head is the branch prefixed with its owner, e.g. "octocat:feature", as Github requires.
+func (s *Server) handleListPullRequests(w nethttp.ResponseWriter, r *nethttp.Request) {
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	query := r.URL.Query()
+	state := query.Get("state")
+	if state == "" {
+		state = "open"
+	}
+	head, base := query.Get("head"), query.Get("base")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fr, ok := s.repos[owner+"/"+repo]
+	if !ok {
+		writeError(w, nethttp.StatusNotFound, "Not Found")
+		return
+	}
+	pullRequests := make([]*entity.PullRequest, 0)
+	for _, pullRequest := range fr.pullRequests {
+		if state != "all" && pullRequest.State != state {
+			continue
+		}
+		if head != "" && pullRequest.Head.Label != head {
+			continue
+		}
+		if base != "" && pullRequest.Base.Ref != base {
+			continue
+		}
+		pullRequests = append(pullRequests, pullRequest)
+	}
+	writeJSON(w, nethttp.StatusOK, pullRequests)
+}
+
+// handleCreateInstallationToken handles POST /app/installations/{installation_id}/access_tokens.
+// The request must be authorized by a JWT, whose signature is not verified.
+func (s *Server) handleCreateInstallationToken(w nethttp.ResponseWriter, r *nethttp.Request) {
+	jwt, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.Count(jwt, ".") != 2 {
+		writeError(w, nethttp.StatusUnauthorized, "A JSON web token could not be decoded")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := fmt.Sprintf("ghs_fake%d_%s", len(s.installationTokens)+1, r.PathValue("installation_id"))
+	s.installationTokens = append(s.installationTokens, token)
+	writeJSON(w, nethttp.StatusCreated, entity.InstallationAccessToken{
+		Token:     token,
+		ExpiresAt: time.Now().Add(s.InstallationTokenTTL).UTC().Truncate(time.Second),
+	})
+}
+
+// repo returns the repository, creating it if it does not exist.
+// The caller must hold s.mu.
+func (s *Server) repo(owner, repo string) *fakeRepo {
+	key := owner + "/" + repo
+	r, ok := s.repos[key]
+	if !ok {
+		r = &fakeRepo{
+			branches: make(map[string]string),
+			commits:  make(map[string]map[string]string),
+		}
+		s.repos[key] = r
+	}
+	return r
+}
+
+// files returns the files at the given ref, a branch or a commit SHA, or nil if the ref does not exist.
+// The caller must hold s.mu.
+func (s *Server) files(owner, repo, ref string) map[string]string {
+	r, ok := s.repos[owner+"/"+repo]
+	if !ok {
+		return nil
+	}
+	if sha, ok := r.branches[ref]; ok {
+		ref = sha
+	}
+	return r.commits[ref]
+}
+
+// commit creates a commit on top of the branch with the content of a file changed, and moves the branch to it.
+// It returns the SHA of the commit.
+// The caller must hold s.mu.
+func (s *Server) commit(r *fakeRepo, branch, path, content string) string {
+	files := make(map[string]string)
+	for p, c := range r.commits[r.branches[branch]] {
+		files[p] = c
+	}
+	files[path] = content
+
+	s.commitCount++
+	sum := sha1.Sum(fmt.Appendf(nil, "commit %d %s %s", s.commitCount, branch, path))
+	sha := hex.EncodeToString(sum[:])
+	r.commits[sha] = files
+	r.branches[branch] = sha
+	return sha
+}
+
+// blobSHA returns the Git blob SHA of the content, as Github does.
+func blobSHA(content string) string {
+	sum := sha1.Sum(fmt.Appendf(nil, "blob %d\x00%s", len(content), content))
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeJSON decodes the JSON body of the request into v.
+func decodeJSON(r *nethttp.Request, v any) error {
+	return sonic.ConfigDefault.NewDecoder(r.Body).Decode(v)
+}
+
+// writeJSON writes v as a JSON response with the status code.
+func writeJSON(w nethttp.ResponseWriter, statusCode int, v any) {
+	body, err := sonic.Marshal(v)
+	if err != nil {
+		log.Err(err).Msg("[writeJSON] Failed to marshal response")
+		w.WriteHeader(nethttp.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
+
+// writeError writes an error response in the format of Github.
+func writeError(w nethttp.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{
+		"message":           message,
+		"documentation_url": "https://docs.github.com/rest",
+	})
+}
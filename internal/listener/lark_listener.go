@@ -4,12 +4,20 @@ import (
 	"context"
 	"dantaautotool/config"
 	"dantaautotool/internal/entity"
+	"dantaautotool/internal/repository"
 	"dantaautotool/internal/service"
 	"dantaautotool/pkg"
+	"dantaautotool/pkg/utils/cache"
 	"dantaautotool/pkg/utils/http"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/bytedance/sonic"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
 	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
 	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher/callback"
 	larkdrive "github.com/larksuite/oapi-sdk-go/v3/service/drive/v1"
@@ -30,41 +38,82 @@ type LarkListener struct {
 	// larkIMService is used to interact with Lark IM
 	larkIMService service.LarkIMServiceIntf
 
+	// larkContactService is used to interact with Lark contacts
+	larkContactService service.LarkContactServiceIntf
+
 	// dantaService is used to handle business logic related to Danta
 	dantaService service.DantaServiceIntf
+
+	// bannerScheduler is used to publish and take down banners on schedule
+	bannerScheduler service.BannerSchedulerIntf
+
+	// bannerVoteService is used to count the votes on banner applications
+	bannerVoteService service.BannerVoteServiceIntf
+
+	// notificationOutbox is used to manage dead letters by commands in the admin group
+	notificationOutbox service.NotificationOutboxIntf
+
+	// bannerApplicationRepository is used to persist the states of banner applications
+	bannerApplicationRepository repository.BannerApplicationRepositoryIntf
+
+	// handledEvents holds the IDs of recently handled events, to drop redelivered ones
+	handledEvents *cache.TTLCache[string, struct{}]
+
+	// cardCallbackTimeout is the deadline of handling a card callback, which must be answered within 3 seconds
+	cardCallbackTimeout time.Duration
+
+	// eventHandleTimeout is the deadline of handling an event
+	eventHandleTimeout time.Duration
 }
 
 // NewLarkListener creates a new LarkListener
 func NewLarkListener(
 	larkDocService service.LarkDocServiceIntf,
 	larkIMService service.LarkIMServiceIntf,
+	larkContactService service.LarkContactServiceIntf,
 	dantaService service.DantaServiceIntf,
+	bannerScheduler service.BannerSchedulerIntf,
+	bannerVoteService service.BannerVoteServiceIntf,
+	notificationOutbox service.NotificationOutboxIntf,
+	bannerApplicationRepository repository.BannerApplicationRepositoryIntf,
 ) *LarkListener {
+	dedupTTLSeconds := config.Config.LarkEventDedupTTLSeconds
+	if dedupTTLSeconds <= 0 {
+		log.Warn().Msgf("[NewLarkListener] Invalid event dedup TTL: %d, fallback to 3600 seconds", dedupTTLSeconds)
+		dedupTTLSeconds = 3600
+	}
+	cardCallbackTimeoutMilliseconds := config.Config.LarkCardCallbackTimeoutMilliseconds
+	if cardCallbackTimeoutMilliseconds <= 0 || cardCallbackTimeoutMilliseconds >= 3000 {
+		log.Warn().Msgf("[NewLarkListener] Invalid card callback timeout: %d, fallback to 2500 milliseconds", cardCallbackTimeoutMilliseconds)
+		cardCallbackTimeoutMilliseconds = 2500
+	}
+	eventHandleTimeoutSeconds := config.Config.LarkEventHandleTimeoutSeconds
+	if eventHandleTimeoutSeconds <= 0 {
+		log.Warn().Msgf("[NewLarkListener] Invalid event handle timeout: %d, fallback to 60 seconds", eventHandleTimeoutSeconds)
+		eventHandleTimeoutSeconds = 60
+	}
 	return &LarkListener{
-		client:         nil,
-		larkDocService: larkDocService,
-		larkIMService:  larkIMService,
-		dantaService:   dantaService,
+		client:                      nil,
+		larkDocService:              larkDocService,
+		larkIMService:               larkIMService,
+		larkContactService:          larkContactService,
+		dantaService:                dantaService,
+		bannerScheduler:             bannerScheduler,
+		bannerVoteService:           bannerVoteService,
+		notificationOutbox:          notificationOutbox,
+		bannerApplicationRepository: bannerApplicationRepository,
+		handledEvents:               cache.NewTTLCache[string, struct{}](time.Duration(dedupTTLSeconds) * time.Second),
+		cardCallbackTimeout:         time.Duration(cardCallbackTimeoutMilliseconds) * time.Millisecond,
+		eventHandleTimeout:          time.Duration(eventHandleTimeoutSeconds) * time.Second,
 	}
 }
 
 func (l *LarkListener) Start() error {
 	eventHandler := dispatcher.
 		NewEventDispatcher("", ""). // the 2 parameters must be empty strings
-		OnP2FileBitableRecordChangedV1(func(ctx context.Context, event *larkdrive.P2FileBitableRecordChangedV1) error {
-			log.Debug().Msgf("[LarkListener] Received bitable record chanded event: %s", larkcore.Prettify(event))
-			return l.handleBitableRecordChangeEvent(ctx, event)
-		}).
-		OnP2CardActionTrigger(func(ctx context.Context, event *callback.CardActionTriggerEvent) (*callback.CardActionTriggerResponse, error) {
-			log.Debug().Msgf("[LarkListener] Received card action trigger event: %s", larkcore.Prettify(event))
-			return l.handleCardActionTriggerEvent(ctx, event)
-		}).
-		OnP2MessageReceiveV1(func(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
-			log.Debug().Msgf("[LarkListener] Received message receive event: %s", larkcore.Prettify(event))
-			// handleMessageReceiveEvent just repeats the message received, which is for testing purpose
-			// return l.handleMessageReceiveEvent(ctx, event)
-			return nil
-		})
+		OnP2FileBitableRecordChangedV1(l.onBitableRecordChanged).
+		OnP2CardActionTrigger(l.onCardActionTrigger).
+		OnP2MessageReceiveV1(l.onMessageReceive)
 
 	// Create a client
 	appID := config.Config.LarkAppID
@@ -90,8 +139,56 @@ func (l *LarkListener) Start() error {
 	return nil
 }
 
+// onBitableRecordChanged is the entry of bitable record changed events, which drops redelivered ones.
+func (l *LarkListener) onBitableRecordChanged(ctx context.Context, event *larkdrive.P2FileBitableRecordChangedV1) error {
+	log.Debug().Msgf("[LarkListener] Received bitable record chanded event: %s", larkcore.Prettify(event))
+	if !l.claimEvent(event.EventV2Base) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, l.eventHandleTimeout)
+	defer cancel()
+	err := l.handleBitableRecordChangeEvent(ctx, event)
+	if err != nil {
+		l.releaseEvent(event.EventV2Base)
+	}
+	return err
+}
+
+// onCardActionTrigger is the entry of card callbacks, which drops redelivered ones.
+func (l *LarkListener) onCardActionTrigger(ctx context.Context, event *callback.CardActionTriggerEvent) (*callback.CardActionTriggerResponse, error) {
+	log.Debug().Msgf("[LarkListener] Received card action trigger event: %s", larkcore.Prettify(event))
+	if !l.claimEvent(event.EventV2Base) {
+		return nil, nil
+	}
+	// Lark drops the callback if it is not answered within 3 seconds, so give up before that
+	ctx, cancel := context.WithTimeout(ctx, l.cardCallbackTimeout)
+	defer cancel()
+	resp, err := l.handleCardActionTriggerEvent(ctx, event)
+	if err != nil {
+		l.releaseEvent(event.EventV2Base)
+	}
+	return resp, err
+}
+
+// onMessageReceive is the entry of message receive events, which drops redelivered ones.
+func (l *LarkListener) onMessageReceive(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
+	log.Debug().Msgf("[LarkListener] Received message receive event: %s", larkcore.Prettify(event))
+	// handleMessageReceiveEvent just repeats the message received, which is for testing purpose
+	// return l.handleMessageReceiveEvent(ctx, event)
+	if !l.claimEvent(event.EventV2Base) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, l.eventHandleTimeout)
+	defer cancel()
+	err := l.handleAdminCommandEvent(ctx, event)
+	if err != nil {
+		l.releaseEvent(event.EventV2Base)
+	}
+	return err
+}
+
 // handleBitableRecordChangeEvent handles bitable record changed events
-func (l *LarkListener) handleBitableRecordChangeEvent(_ context.Context, event *larkdrive.P2FileBitableRecordChangedV1) error {
+func (l *LarkListener) handleBitableRecordChangeEvent(ctx context.Context, event *larkdrive.P2FileBitableRecordChangedV1) error {
 	fileToken := event.Event.FileToken
 	if fileToken == nil || *fileToken == "" {
 		log.Error().Msg("[LarkListener.handleBitableRecordChangeEvent] fileToken is empty")
@@ -111,57 +208,129 @@ func (l *LarkListener) handleBitableRecordChangeEvent(_ context.Context, event *
 		log.Error().Msg("[LarkListener.handleBitableRecordChangeEvent] LARK_BANNER_APPROVE_CARD_ID is empty")
 		return fmt.Errorf("LARK_BANNER_APPROVE_CARD_ID is empty")
 	}
-	if *fileToken == bannerAnalysisDocToken {
-		addedRecordIds := make([]string, 0)
-		for _, action := range event.Event.ActionList {
-			// Only handle add action
-			if *action.Action != pkg.LARK_BITABLE_RECORD_ACTION_ADD {
-				continue
+	// Match by file token and table ID, for the usage table is in the same bitable
+	if *fileToken != bannerAnalysisDocToken || event.Event.TableId == nil || *event.Event.TableId != bannerAnalysisTableID {
+		return nil
+	}
+	addedRecordIds := make([]string, 0)
+	editedRecordIds := make([]string, 0)
+	for _, action := range event.Event.ActionList {
+		switch *action.Action {
+		case pkg.LARK_BITABLE_RECORD_ACTION_ADD:
+			if !slices.Contains(addedRecordIds, *action.RecordId) {
+				addedRecordIds = append(addedRecordIds, *action.RecordId)
+			}
+		case pkg.LARK_BITABLE_RECORD_ACTION_EDITED:
+			if !slices.Contains(editedRecordIds, *action.RecordId) {
+				editedRecordIds = append(editedRecordIds, *action.RecordId)
 			}
-			addedRecordIds = append(addedRecordIds, *action.RecordId)
 		}
-		if len(addedRecordIds) == 0 {
-			log.Info().Msg("[LarkListener.handleBitableRecordChangeEvent] No added record found")
+	}
+	if len(addedRecordIds) == 0 && len(editedRecordIds) == 0 {
+		log.Info().Msg("[LarkListener.handleBitableRecordChangeEvent] No added or edited record found")
+		return nil
+	}
+
+	// Batch query added bitable records
+	addedRecords, err := l.larkDocService.BatchQueryBitableRecords(ctx, bannerAnalysisDocToken, bannerAnalysisTableID, addedRecordIds)
+	if err != nil {
+		log.Error().Err(err).Msg("[LarkListener.handleBitableRecordChangeEvent] Failed to batch query bitable records")
+		return err
+	}
+	// For each added record, send a banner vote card
+	for _, addedRecord := range addedRecords {
+		bannerApplication := l.dantaService.ConvertBitableRecord2BannerApplication(addedRecord)
+		if bannerApplication == nil {
+			log.Error().Msg("[LarkListener.handleBitableRecordChangeEvent] Failed to convert bitable record to banner application")
+			return fmt.Errorf("failed to convert bitable record to banner application")
+		}
+		bannerApproveGroupID := config.Config.LarkBannerApproveGroupID
+		if bannerApproveGroupID == "" {
+			log.Error().Msg("[LarkListener.handleBitableRecordChangeEvent] LARK_BANNER_APPROVE_GROUP_ID is empty")
+			return fmt.Errorf("LARK_BANNER_APPROVE_GROUP_ID is empty")
+		}
+		// Save the application, so that it is remembered across restarts
+		recordID := *addedRecord.RecordId
+		state, err := l.bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+			state.BannerApplication = *bannerApplication
 			return nil
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("[LarkListener.handleBitableRecordChangeEvent] Failed to save banner application")
+			return err
+		}
+		// Each record gets exactly one vote card, even if the event is redelivered with another event ID
+		if state.VoteCardSentAt != 0 {
+			log.Info().Msgf("[LarkListener.handleBitableRecordChangeEvent] Banner vote card already sent, record ID: %s", recordID)
+			continue
 		}
-		// Batch query bitable records
-		addedRecords, err := l.larkDocService.BatchQueryBitableRecords(bannerAnalysisDocToken, bannerAnalysisTableID, addedRecordIds)
+		tally, err := l.bannerVoteService.Tally(recordID)
 		if err != nil {
-			log.Error().Err(err).Msg("[LarkListener.handleBitableRecordChangeEvent] Failed to batch query bitable records")
+			log.Error().Err(err).Msg("[LarkListener.handleBitableRecordChangeEvent] Failed to get vote tally")
 			return err
 		}
-		// For each added record, send a banner vote card
-		for _, addedRecord := range addedRecords {
-			bannerApplication := l.dantaService.ConvertBitableRecord2BannerApplication(addedRecord)
-			if bannerApplication == nil {
-				log.Error().Msg("[LarkListener.handleBitableRecordChangeEvent] Failed to convert bitable record to banner application")
-				return fmt.Errorf("failed to convert bitable record to banner application")
-			}
-			bannerApproveGroupID := config.Config.LarkBannerApproveGroupID
-			if bannerApproveGroupID == "" {
-				log.Error().Msg("[LarkListener.handleBitableRecordChangeEvent] LARK_BANNER_APPROVE_GROUP_ID is empty")
-				return fmt.Errorf("LARK_BANNER_APPROVE_GROUP_ID is empty")
-			}
-			// Send banner vote card to the specified group
-			err = l.larkIMService.SendCardMessageByTemplate(larkim.ReceiveIdTypeChatId, bannerApproveGroupID, bannerVoteCardID, map[string]interface{}{
-				"banner_title":    bannerApplication.Title,
-				"banner_action":   bannerApplication.Action,
-				"banner_button":   bannerApplication.Button,
-				"applicant_email": bannerApplication.ApplicantEmail,
-			})
-			if err != nil {
-				log.Error().Err(err).Msg("[LarkListener] Failed to send banner vote card")
-				return err
-			}
-			log.Info().Msg("[LarkListener] Banner vote card sent")
+		// Send banner vote card to the specified group
+		err = l.larkIMService.SendCardMessageByTemplate(ctx, larkim.ReceiveIdTypeChatId, bannerApproveGroupID, bannerVoteCardID,
+			bannerVoteCardVariables(recordID, *bannerApplication, tally))
+		if err != nil {
+			log.Error().Err(err).Msg("[LarkListener] Failed to send banner vote card")
+			return err
+		}
+		log.Info().Msg("[LarkListener] Banner vote card sent")
+		_, err = l.bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+			state.VoteCardSentAt = time.Now().UnixMilli()
+			return nil
+		})
+		if err != nil {
+			// the card has been sent, failing here would send it again on redelivery
+			log.Error().Err(err).Msg("[LarkListener.handleBitableRecordChangeEvent] Failed to mark banner vote card as sent")
+		}
+	}
+
+	// Batch query edited bitable records
+	editedRecords, err := l.larkDocService.BatchQueryBitableRecords(ctx, bannerAnalysisDocToken, bannerAnalysisTableID, editedRecordIds)
+	if err != nil {
+		log.Error().Err(err).Msg("[LarkListener.handleBitableRecordChangeEvent] Failed to batch query bitable records")
+		return err
+	}
+	// For each edited record whose status is set to withdrawn, withdraw the banner.
+	// Other edits of a withdrawn record trigger the event again, which WithdrawBanner skips.
+	for _, editedRecord := range editedRecords {
+		if !l.dantaService.IsBitableRecordWithdrawn(editedRecord) {
+			continue
+		}
+		recordID := *editedRecord.RecordId
+		bannerApplication := l.dantaService.ConvertBitableRecord2BannerApplication(editedRecord)
+		if bannerApplication == nil {
+			log.Error().Msg("[LarkListener.handleBitableRecordChangeEvent] Failed to convert bitable record to banner application")
+			return fmt.Errorf("failed to convert bitable record to banner application")
 		}
+		err = l.withdrawBanner(ctx, recordID, *bannerApplication)
+		if errors.Is(err, service.ErrBannerDisapproved) {
+			log.Warn().Msgf("[LarkListener.handleBitableRecordChangeEvent] Application has been disapproved, skip withdrawing it, record ID: %s", recordID)
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("[LarkListener.handleBitableRecordChangeEvent] Failed to withdraw banner")
+			return err
+		}
+	}
+	return nil
+}
+
+// withdrawBanner withdraws a banner application, and takes down its banner.
+func (l *LarkListener) withdrawBanner(ctx context.Context, recordID string, bannerApplication entity.BannerApplication) error {
+	err := l.dantaService.WithdrawBanner(ctx, recordID, bannerApplication)
+	if err != nil {
+		return err
 	}
+	log.Info().Msgf("[LarkListener.withdrawBanner] Banner withdrawn, title: %s", bannerApplication.Title)
 	return nil
 }
 
 // handleCardActionTriggerEvent handles card action trigger events
 // Note: The event value must have a field named "action" to distinguish different buttons
-func (l *LarkListener) handleCardActionTriggerEvent(_ context.Context, event *callback.CardActionTriggerEvent) (*callback.CardActionTriggerResponse, error) {
+func (l *LarkListener) handleCardActionTriggerEvent(ctx context.Context, event *callback.CardActionTriggerEvent) (*callback.CardActionTriggerResponse, error) {
 	// handle card button click callback
 	// https://open.feishu.cn/document/uAjLw4CM/ukzMukzMukzM/feishu-cards/card-callback-communication
 	log.Info().Msgf("[LarkListener.handleCardActionTriggerEvent], data: %s\n", larkcore.Prettify(event))
@@ -182,121 +351,225 @@ func (l *LarkListener) handleCardActionTriggerEvent(_ context.Context, event *ca
 	// An action is a map with following fields:
 	//  {
 	//    "action": "...",
-	//    "banner_title": "...",
-	//    "banner_action": "...",
-	//    "banner_button": "...",
-	//    "applicant_email": "...",
+	//    "record_id": "...", (record ID of the application in the bitable)
 	//  }
+	// The application itself is loaded from the repository (or the application table), never from the card,
+	// so that an outdated or forged card cannot change it. Other fields in the action are ignored.
+	// The disapprove button must also submit the card form, with an input box named "reason_input" for the reason.
 	var ok bool
 	actionType, ok := actionDetail["action"].(string)
 	if !ok {
 		log.Error().Msgf("[LarkListener.handleCardActionTriggerEvent] Failed to parse action type, actionDetail: %v", actionDetail)
 		return nil, fmt.Errorf("failed to parse action")
 	}
-	bannerTitle, ok := actionDetail["banner_title"].(string)
-	if !ok {
-		log.Error().Msgf("[LarkListener.handleCardActionTriggerEvent] Failed to parse banner content, actionDetail: %v", actionDetail)
+	recordID, ok := actionDetail["record_id"].(string)
+	if !ok || recordID == "" {
+		log.Error().Msgf("[LarkListener.handleCardActionTriggerEvent] Failed to parse record ID, actionDetail: %v", actionDetail)
 		return nil, fmt.Errorf("failed to parse action")
 	}
-	bannerAction, ok := actionDetail["banner_action"].(string)
-	if !ok {
-		log.Error().Msgf("[LarkListener.handleCardActionTriggerEvent] Failed to parse action, actionDetail: %v", actionDetail)
-		return nil, fmt.Errorf("failed to parse action")
-	}
-	bannerButton, ok := actionDetail["banner_button"].(string)
-	if !ok {
-		log.Error().Msgf("[LarkListener.handleCardActionTriggerEvent] Failed to parse action, actionDetail: %v", actionDetail)
-		return nil, fmt.Errorf("failed to parse action")
+	bannerApplication, err := l.loadBannerApplication(ctx, recordID)
+	if err != nil {
+		log.Error().Err(err).Msgf("[LarkListener.handleCardActionTriggerEvent] Failed to load banner application, record ID: %s", recordID)
+		return nil, err
 	}
-	applicantEmail, ok := actionDetail["applicant_email"].(string)
-	if !ok {
-		log.Error().Msgf("[LarkListener.handleCardActionTriggerEvent] Failed to parse action email, actionDetail: %v", actionDetail)
-		return nil, fmt.Errorf("failed to parse action")
-	}
-	// startDate, ok := actionDetail["start_date"].(time.Time)
-	// if !ok {
-	// 	log.Error().Msgf("[LarkListener.handleCardActionTriggerEvent] Failed to parse action start date, actionDetail: %v", actionDetail)
-	// 	return nil, fmt.Errorf("failed to parse action")
-	// }
-	// endDate, ok := actionDetail["end_date"].(time.Time)
-	// if !ok {
-	// 	log.Error().Msgf("[LarkListener.handleCardActionTriggerEvent] Failed to parse action end date, actionDetail: %v", actionDetail)
-	// 	return nil, fmt.Errorf("failed to parse action")
-	// }
 
 	if actionType == pkg.LARK_IM_CARD_ACTION_APPROVE {
+		return l.handleBannerVote(ctx, event, recordID, bannerApplication, true, "")
+	} else if actionType == pkg.LARK_IM_CARD_ACTION_DISAPPROVE {
+		// A disapproval must come with a reason, filled in the reason_input box of the card form
+		reason, _ := event.Event.Action.FormValue["reason_input"].(string)
+		reason = strings.TrimSpace(reason)
+		if reason == "" {
+			return &callback.CardActionTriggerResponse{
+				Toast: &callback.Toast{
+					Type:    "warning",
+					Content: "Please fill in the reason!",
+					I18nContent: map[string]string{
+						"zh_cn": "请填写驳回理由",
+						"en_us": "Please fill in the reason!",
+					},
+				},
+			}, nil
+		}
+		return l.handleBannerVote(ctx, event, recordID, bannerApplication, false, reason)
+	} else if actionType == pkg.LARK_IM_CARD_ACTION_WITHDRAW {
+		// The withdraw button is on the decided card, which is shown for disapproved applications too
+		state, err := l.bannerApplicationRepository.Get(recordID)
+		if err != nil {
+			log.Error().Err(err).Msg("[LarkListener.handleCardActionTriggerEvent] Failed to get banner application")
+			return nil, err
+		}
+		if state != nil && state.Status == pkg.BANNER_STATUS_DISAPPROVED {
+			return &callback.CardActionTriggerResponse{
+				Toast: &callback.Toast{
+					Type:    "warning",
+					Content: "The application has been disapproved!",
+					I18nContent: map[string]string{
+						"zh_cn": "该申请已被驳回，无需撤回",
+						"en_us": "The application has been disapproved!",
+					},
+				},
+			}, nil
+		}
+		// Taking down the banner calls Github, which may not finish before the callback deadline
+		go l.withdrawBannerInBackground(recordID, bannerApplication, cardMessageID(event))
 		card := callback.CardActionTriggerResponse{
 			Toast: &callback.Toast{
-				Type:    "success",
-				Content: "Approved!",
+				Type:    "info",
+				Content: "Withdrawing!",
 				I18nContent: map[string]string{
-					"zh_cn": "已通过",
-					"en_us": "Approved!",
+					"zh_cn": "正在撤回",
+					"en_us": "Withdrawing!",
 				},
 			},
-			// Card: &callback.Card{
-			// 	Type: "template",
-			// 	Data: &callback.TemplateCard{
-			// 		TemplateID: APPROVED_CARD_ID,
-			// 		TemplateVariable: map[string]interface{}{
-			// 			"user_ids": []string{event.Event.Operator.OpenID},
-			// 			"notes":    event.Event.Action.FormValue["notes_input"],
-			// 		},
-			// 	},
-			// },
 		}
-		newBanner := entity.Banner{
-			Title:  bannerTitle,
-			Action: bannerAction,
-			Button: bannerButton,
+		return &card, nil
+	}
+
+	log.Warn().Msg("[LarkListener.handleCardActionTriggerEvent] Unknown action received")
+	return nil, fmt.Errorf("unknown action type: %s", actionType)
+}
+
+// loadBannerApplication returns the application with the given record ID saved in the repository.
+// An application not saved yet, e.g. whose vote card was sent before the repository was introduced,
+// is read from the application table and saved.
+func (l *LarkListener) loadBannerApplication(ctx context.Context, recordID string) (entity.BannerApplication, error) {
+	state, err := l.bannerApplicationRepository.Get(recordID)
+	if err != nil {
+		return entity.BannerApplication{}, err
+	}
+	if state != nil && state.Title != "" {
+		return state.BannerApplication, nil
+	}
+
+	bannerAnalysisDocToken := config.Config.LarkBannerBitableAppToken
+	bannerAnalysisTableID := config.Config.LarkBannerBitableApplicationTableID
+	if bannerAnalysisDocToken == "" || bannerAnalysisTableID == "" {
+		log.Error().Msg("[LarkListener.loadBannerApplication] LARK_BANNER_BITABLE_APP_TOKEN or LARK_BANNER_BITABLE_APPLICATION_TABLE_ID is empty")
+		return entity.BannerApplication{}, fmt.Errorf("LARK_BANNER_BITABLE_APP_TOKEN or LARK_BANNER_BITABLE_APPLICATION_TABLE_ID is empty")
+	}
+	records, err := l.larkDocService.BatchQueryBitableRecords(ctx, bannerAnalysisDocToken, bannerAnalysisTableID, []string{recordID})
+	if err != nil {
+		return entity.BannerApplication{}, err
+	}
+	if len(records) == 0 {
+		return entity.BannerApplication{}, fmt.Errorf("banner application not found: %s", recordID)
+	}
+	bannerApplication := l.dantaService.ConvertBitableRecord2BannerApplication(records[0])
+	if bannerApplication == nil {
+		return entity.BannerApplication{}, fmt.Errorf("failed to convert bitable record to banner application")
+	}
+	state, err = l.bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+		if state.Title == "" {
+			state.BannerApplication = *bannerApplication
 		}
-		newBannerUsageLog := entity.BannerUsageLog{
-			BannerApplication: entity.BannerApplication{
-				Banner:         newBanner,
-				ApplicantEmail: applicantEmail,
+		return nil
+	})
+	if err != nil {
+		return entity.BannerApplication{}, err
+	}
+	log.Info().Msgf("[LarkListener.loadBannerApplication] Banner application loaded from the application table, record ID: %s", recordID)
+	return state.BannerApplication, nil
+}
+
+// claimEvent marks an event as being handled, and returns false if it has been handled recently.
+// Events without an ID are always handled.
+func (l *LarkListener) claimEvent(base *larkevent.EventV2Base) bool {
+	if base == nil || base.Header == nil || base.Header.EventID == "" {
+		return true
+	}
+	if !l.handledEvents.SetIfAbsent(base.Header.EventID, struct{}{}) {
+		log.Info().Msgf("[LarkListener.claimEvent] Duplicated event dropped, event ID: %s, event type: %s", base.Header.EventID, base.Header.EventType)
+		return false
+	}
+	return true
+}
+
+// releaseEvent unmarks an event whose handling failed, so that it can be handled again when redelivered.
+func (l *LarkListener) releaseEvent(base *larkevent.EventV2Base) {
+	if base == nil || base.Header == nil || base.Header.EventID == "" {
+		return
+	}
+	l.handledEvents.Delete(base.Header.EventID)
+}
+
+// handleBannerVote records the vote of the card operator on a banner application,
+// and approves (or disapproves) the application once the quorum is reached.
+// The card is updated to show the current tally.
+func (l *LarkListener) handleBannerVote(ctx context.Context, event *callback.CardActionTriggerEvent, recordID string, bannerApplication entity.BannerApplication, approve bool, reason string) (*callback.CardActionTriggerResponse, error) {
+	if event.Event.Operator == nil || event.Event.Operator.OpenID == "" {
+		log.Error().Msg("[LarkListener.handleBannerVote] Operator is empty")
+		return nil, fmt.Errorf("operator is empty")
+	}
+	voterID := event.Event.Operator.OpenID
+
+	tally, err := l.bannerVoteService.Vote(recordID, voterID, approve, reason)
+	if errors.Is(err, service.ErrDuplicateVote) {
+		return &callback.CardActionTriggerResponse{
+			Toast: &callback.Toast{
+				Type:    "warning",
+				Content: "You have voted!",
+				I18nContent: map[string]string{
+					"zh_cn": "你已经投过票了",
+					"en_us": "You have voted!",
+				},
 			},
-			// start date and end date are not provided in the card action trigger event
-			// maybe we need to set them by manual input =.=...
-			// StartDate: startDate,
-			// EndDate:   endDate,
-		}
+		}, nil
+	}
+	if errors.Is(err, service.ErrVotingClosed) {
+		return &callback.CardActionTriggerResponse{
+			Toast: &callback.Toast{
+				Type:    "info",
+				Content: "The application has been decided!",
+				I18nContent: map[string]string{
+					"zh_cn": "该申请已处理",
+					"en_us": "The application has been decided!",
+				},
+			},
+		}, nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("[LarkListener.handleBannerVote] Failed to vote")
+		return nil, err
+	}
 
-		// update config file in Github
-		err := l.dantaService.UpdateBanner(newBanner)
-		if err != nil {
-			log.Error().Err(err).Msg("[LarkListener.handleCardActionTriggerEvent] Failed to update banner")
-			return nil, err
-		}
-		log.Info().Msg("[LarkListener.handleCardActionTriggerEvent] Banner updated")
-
-		// log to lark doc
-		bannerAnalysisDocToken := config.Config.LarkBannerBitableAppToken
-		bannerUsageLogTableID := config.Config.LarkBannerBitableUsageTableID
-		if bannerAnalysisDocToken == "" || bannerUsageLogTableID == "" {
-			log.Error().Msg("[LarkListener.handleCardActionTriggerEvent] LARK_BANNER_BITABLE_APP_TOKEN or LARK_BANNER_BITABLE_USAGE_TABLE_ID is empty")
-			return nil, fmt.Errorf("LARK_BANNER_BITABLE_APP_TOKEN or LARK_BANNER_BITABLE_USAGE_TABLE_ID is empty")
+	switch tally.Status {
+	case pkg.BANNER_STATUS_APPROVED:
+		// Publishing makes several calls to Github, which may not finish before the callback deadline,
+		// so the callback is answered at once, and the card is updated when publishing finishes in background
+		approval := &bannerApproval{
+			recordID:          recordID,
+			bannerApplication: bannerApplication,
+			tally:             tally,
+			voterID:           voterID,
+			approverNames:     l.getUserNames(ctx, tally.Approvers),
+			notes:             cardNotes(event),
+			messageID:         cardMessageID(event),
 		}
-		err = l.larkDocService.AddBitableRecord(
-			bannerAnalysisDocToken,
-			bannerUsageLogTableID,
-			map[string]interface{}{
-				"Banner": newBannerUsageLog.Title,
-				"开始日期":   newBannerUsageLog.StartDate,
-				"截止日期":   newBannerUsageLog.EndDate,
-				"联系邮箱":   newBannerUsageLog.ApplicantEmail,
-				"action": newBannerUsageLog.Action,
-				"button": newBannerUsageLog.Button,
+		go l.approveBanner(approval)
+		return &callback.CardActionTriggerResponse{
+			Toast: &callback.Toast{
+				Type:    "success",
+				Content: "Approved, publishing!",
+				I18nContent: map[string]string{
+					"zh_cn": "已通过，正在发布",
+					"en_us": "Approved, publishing!",
+				},
 			},
-		)
+			Card: bannerDecidedCard(recordID, bannerApplication, tally, tally.Approvers, approval.approverNames, approval.notes, nil, true),
+		}, nil
+	case pkg.BANNER_STATUS_DISAPPROVED:
+		err = l.dantaService.DisapproveBanner(ctx, recordID, bannerApplication, tally.DisapproveReasons)
 		if err != nil {
-			log.Error().Err(err).Msg("[LarkListener.handleCardActionTriggerEvent] Failed to add bitable record")
+			log.Error().Err(err).Msg("[LarkListener.handleBannerVote] Failed to disapprove banner")
+			// Roll back the vote, so that the operator can retry by voting again
+			if retractErr := l.bannerVoteService.Retract(recordID, voterID); retractErr != nil {
+				log.Error().Err(retractErr).Msg("[LarkListener.handleBannerVote] Failed to retract vote")
+			}
 			return nil, err
 		}
-		log.Info().Msg("[LarkListener.handleCardActionTriggerEvent] Bitable record added")
-
-		return &card, nil
-	} else if actionType == pkg.LARK_IM_CARD_ACTION_DISAPPROVE {
-		card := callback.CardActionTriggerResponse{
+		log.Info().Msgf("[LarkListener.handleBannerVote] Banner disapproved, title: %s", bannerApplication.Title)
+		return &callback.CardActionTriggerResponse{
 			Toast: &callback.Toast{
 				Type:    "info",
 				Content: "Disapproved!",
@@ -305,17 +578,326 @@ func (l *LarkListener) handleCardActionTriggerEvent(_ context.Context, event *ca
 					"en_us": "Disapproved!",
 				},
 			},
+			Card: bannerDecidedCard(recordID, bannerApplication, tally, tally.Disapprovers, l.getUserNames(ctx, tally.Disapprovers), cardNotes(event), nil, false),
+		}, nil
+	default:
+		return &callback.CardActionTriggerResponse{
+			Toast: &callback.Toast{
+				Type:    "info",
+				Content: "Vote recorded!",
+				I18nContent: map[string]string{
+					"zh_cn": "已投票",
+					"en_us": "Vote recorded!",
+				},
+			},
+			Card: bannerVoteCard(recordID, bannerApplication, tally),
+		}, nil
+	}
+}
+
+// bannerApproval holds what is needed to publish an approved banner in background.
+type bannerApproval struct {
+	recordID          string
+	bannerApplication entity.BannerApplication
+	tally             *entity.BannerVoteTally
+
+	// voterID is the open ID of the voter whose vote approved the application, which is retracted if publishing fails
+	voterID string
+
+	approverNames string
+	notes         string
+
+	// messageID is the ID of the vote card message, which is updated when publishing finishes, empty if unknown
+	messageID string
+}
+
+// approveBanner schedules an approved banner in background, after the card callback has been answered.
+// The scheduler publishes the banner (if its start date has arrived) and logs it to the usage table.
+// When it finishes, the card is updated to show the result, and the applicant is notified.
+// If publishing fails, the vote is retracted and the vote card is restored, so that the operator can vote again,
+// unless the banner has been published in the meantime.
+func (l *LarkListener) approveBanner(approval *bannerApproval) {
+	ctx, cancel := context.WithTimeout(context.Background(), service.BannerSchedulerOperationTimeout)
+	defer cancel()
+	recordID, title := approval.recordID, approval.bannerApplication.Title
+
+	// update config file in Github, on its start date
+	change, err := l.bannerScheduler.Schedule(ctx, recordID, approval.approverNames)
+	if err != nil {
+		log.Error().Err(err).Msgf("[LarkListener.approveBanner] Failed to schedule banner, title: %s", title)
+		retractErr := l.bannerVoteService.Retract(recordID, approval.voterID)
+		switch {
+		case retractErr == nil:
+			l.replyCard(ctx, approval.messageID, fmt.Sprintf("Banner「%s」发布失败，最后一票已撤回，请稍后重新投票。\n错误：%s", title, err))
+			tally, tallyErr := l.bannerVoteService.Tally(recordID)
+			if tallyErr != nil {
+				log.Error().Err(tallyErr).Msg("[LarkListener.approveBanner] Failed to get vote tally")
+				return
+			}
+			l.updateCard(ctx, approval.messageID, bannerVoteCard(recordID, approval.bannerApplication, tally))
+			return
+		case errors.Is(retractErr, service.ErrBannerPublished):
+			log.Warn().Msgf("[LarkListener.approveBanner] Banner has been published in the meantime, keep the approval, title: %s", title)
+		default:
+			// the application stays approved, so the periodic check publishes it later
+			log.Error().Err(retractErr).Msg("[LarkListener.approveBanner] Failed to retract vote")
+			l.replyCard(ctx, approval.messageID, fmt.Sprintf("Banner「%s」发布失败，将在下次定时检查时重试。\n错误：%s", title, err))
+			return
 		}
-		return &card, nil
 	}
+	log.Info().Msgf("[LarkListener.approveBanner] Banner scheduled, title: %s", title)
 
-	log.Warn().Msg("[LarkListener.handleCardActionTriggerEvent] Unknown action received")
-	return nil, fmt.Errorf("unknown action type: %s", actionType)
+	// In review mode, post the pull request link back to the approval card
+	if config.Config.GithubReviewMode && change != nil {
+		l.replyCard(ctx, approval.messageID, fmt.Sprintf("Pull request created, please review and merge it: %s", change.Link))
+	}
+	l.updateCard(ctx, approval.messageID, bannerDecidedCard(recordID, approval.bannerApplication, approval.tally, approval.tally.Approvers, approval.approverNames, approval.notes, change, false))
+
+	// notify applicant, the approval is not failed if the notification fails
+	newBannerUsageLog := entity.BannerUsageLog{
+		BannerApplication: approval.bannerApplication,
+		Approver:          approval.approverNames,
+	}
+	err = l.dantaService.NotifyBannerUpdate(ctx, newBannerUsageLog, change, []string{newBannerUsageLog.ApplicantEmail})
+	if err != nil {
+		log.Error().Err(err).Msg("[LarkListener.approveBanner] Failed to notify applicant")
+	}
+}
+
+// withdrawBannerInBackground withdraws a banner application after the card callback has been answered,
+// and replies the result to the card, since taking down the banner may not finish before the callback deadline.
+func (l *LarkListener) withdrawBannerInBackground(recordID string, bannerApplication entity.BannerApplication, messageID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), service.BannerSchedulerOperationTimeout)
+	defer cancel()
+	err := l.withdrawBanner(ctx, recordID, bannerApplication)
+	if err != nil {
+		log.Error().Err(err).Msg("[LarkListener.withdrawBannerInBackground] Failed to withdraw banner")
+		l.replyCard(ctx, messageID, fmt.Sprintf("Banner「%s」撤回失败，请稍后重试。\n错误：%s", bannerApplication.Title, err))
+		return
+	}
+	l.replyCard(ctx, messageID, fmt.Sprintf("Banner「%s」已撤回", bannerApplication.Title))
+}
+
+// updateCard replaces the card message with the given ID, if the ID is known.
+// A failure is only logged, since the card only shows the result.
+func (l *LarkListener) updateCard(ctx context.Context, messageID string, card *callback.Card) {
+	templateCard, ok := card.Data.(*callback.TemplateCard)
+	if messageID == "" || !ok {
+		return
+	}
+	err := l.larkIMService.UpdateCardMessageByTemplate(ctx, messageID, templateCard.TemplateID, templateCard.TemplateVariable)
+	if err != nil {
+		log.Error().Err(err).Msgf("[LarkListener.updateCard] Failed to update card, message ID: %s", messageID)
+	}
+}
+
+// replyCard replies a text message to the card message with the given ID, if the ID is known.
+// A failure is only logged, since the reply only shows the result.
+func (l *LarkListener) replyCard(ctx context.Context, messageID, text string) {
+	if messageID == "" {
+		return
+	}
+	if err := l.larkIMService.ReplyTextMessage(ctx, messageID, text); err != nil {
+		log.Error().Err(err).Msgf("[LarkListener.replyCard] Failed to reply card, message ID: %s", messageID)
+	}
+}
+
+// getUserNames returns the names of the users with the given open IDs, joined by commas.
+// It falls back to the open ID of a user if the name cannot be retrieved.
+func (l *LarkListener) getUserNames(ctx context.Context, openIDs []string) string {
+	names := make([]string, 0, len(openIDs))
+	for _, openID := range openIDs {
+		name, err := l.larkContactService.GetUserName(ctx, openID)
+		if err != nil {
+			log.Warn().Err(err).Msgf("[LarkListener.getUserNames] Failed to get user name, fallback to open ID: %s", openID)
+			name = openID
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// bannerDecidedCard builds the card replacing the vote card once an application is decided.
+// The decided card template has no vote buttons, so that nobody can vote again.
+// It carries the withdraw button instead, which is the only card to withdraw an approved application from,
+// and the button is rejected for a disapproved application.
+// If the decided card is not configured, the vote card is kept with the variables updated, as before the decided card is introduced,
+// so the vote card template should carry the withdraw button then, and votes on it are rejected by the vote service.
+// deciders are the open IDs of the voters who decided the application, and change is the committed change if any.
+// publishing is true while the banner of an approved application is being published in background.
+func bannerDecidedCard(
+	recordID string,
+	bannerApplication entity.BannerApplication,
+	tally *entity.BannerVoteTally,
+	deciders []string,
+	deciderNames string,
+	notes string,
+	change *entity.BannerConfigChange,
+	publishing bool,
+) *callback.Card {
+	variables := bannerVoteCardVariables(recordID, bannerApplication, tally)
+	variables["status"] = tally.Status
+	variables["status_text"] = bannerStatusText(tally.Status)
+	if publishing {
+		variables["status_text"] = "已通过，发布中"
+	}
+	variables["publishing"] = publishing
+	variables["user_ids"] = deciders
+	variables["decider_names"] = deciderNames
+	variables["decided_at"] = time.Now().Format(time.DateTime)
+	variables["notes"] = notes
+	// reasons of disapprovals, one per line
+	variables["reasons"] = strings.Join(tally.DisapproveReasons, "\n")
+	commitLink := ""
+	if change != nil {
+		commitLink = change.Link
+	}
+	variables["commit_link"] = commitLink
+
+	templateID := config.Config.LarkBannerDecidedCardID
+	if templateID == "" {
+		templateID = config.Config.LarkBannerApproveCardID
+	}
+	return &callback.Card{
+		Type: "template",
+		Data: &callback.TemplateCard{
+			TemplateID:       templateID,
+			TemplateVariable: variables,
+		},
+	}
+}
+
+// bannerVoteCard builds the vote card showing the current tally.
+func bannerVoteCard(recordID string, bannerApplication entity.BannerApplication, tally *entity.BannerVoteTally) *callback.Card {
+	return &callback.Card{
+		Type: "template",
+		Data: &callback.TemplateCard{
+			TemplateID:       config.Config.LarkBannerApproveCardID,
+			TemplateVariable: bannerVoteCardVariables(recordID, bannerApplication, tally),
+		},
+	}
+}
+
+// cardNotes returns the notes filled in the optional input box notes_input of the vote card form, or "-" if empty.
+func cardNotes(event *callback.CardActionTriggerEvent) string {
+	if note, ok := event.Event.Action.FormValue["notes_input"].(string); ok && note != "" {
+		return note
+	}
+	return "-"
+}
+
+// cardMessageID returns the ID of the card message whose action is triggered, or an empty string if unknown.
+func cardMessageID(event *callback.CardActionTriggerEvent) string {
+	if event.Event.Context == nil {
+		return ""
+	}
+	return event.Event.Context.OpenMessageID
+}
+
+// bannerStatusText returns the text of a banner status for display in cards.
+func bannerStatusText(status string) string {
+	switch status {
+	case pkg.BANNER_STATUS_APPROVED:
+		return "已通过"
+	case pkg.BANNER_STATUS_DISAPPROVED:
+		return "已驳回"
+	case pkg.BANNER_STATUS_WITHDRAWN:
+		return "已撤回"
+	case pkg.BANNER_STATUS_EXPIRED:
+		return "已下线"
+	default:
+		return "待审批"
+	}
+}
+
+// bannerVoteCardVariables builds the template variables of the banner vote card.
+func bannerVoteCardVariables(recordID string, bannerApplication entity.BannerApplication, tally *entity.BannerVoteTally) map[string]interface{} {
+	return map[string]interface{}{
+		"record_id":         recordID,
+		"banner_title":      bannerApplication.Title,
+		"banner_action":     bannerApplication.Action,
+		"banner_button":     bannerApplication.Button,
+		"applicant_email":   bannerApplication.ApplicantEmail,
+		"start_date":        bannerApplication.StartDate,
+		"end_date":          bannerApplication.EndDate,
+		"start_date_text":   service.FormatBannerDate(bannerApplication.StartDate),
+		"end_date_text":     service.FormatBannerDate(bannerApplication.EndDate),
+		"approve_count":     len(tally.Approvers),
+		"approve_quorum":    tally.ApproveQuorum,
+		"disapprove_count":  len(tally.Disapprovers),
+		"disapprove_quorum": tally.DisapproveQuorum,
+	}
+}
+
+// handleAdminCommandEvent handles the commands sent to the bot in the admin group, which manage the dead letters in the outbox.
+// Messages in other chats, and messages which are not commands, are ignored.
+func (l *LarkListener) handleAdminCommandEvent(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
+	if event.Event == nil || event.Event.Message == nil {
+		return nil
+	}
+	message := event.Event.Message
+	if larkcore.StringValue(message.ChatId) != service.AdminGroupID() || larkcore.StringValue(message.MessageType) != larkim.MsgTypeText {
+		return nil
+	}
+	var content map[string]string
+	if err := sonic.Unmarshal([]byte(larkcore.StringValue(message.Content)), &content); err != nil {
+		log.Err(err).Msgf("[LarkListener.handleAdminCommandEvent] Failed to parse message content: %s", larkcore.StringValue(message.Content))
+		return nil
+	}
+	// mentions of the bot are replaced by placeholders like @_user_1
+	args := slices.DeleteFunc(strings.Fields(content["text"]), func(field string) bool {
+		return strings.HasPrefix(field, "@")
+	})
+	if len(args) == 0 || !strings.HasPrefix(args[0], "/") {
+		return nil
+	}
+	log.Info().Msgf("[LarkListener.handleAdminCommandEvent] Admin command received: %s", strings.Join(args, " "))
+
+	reply := l.runAdminCommand(args)
+	if err := l.larkIMService.ReplyTextMessage(ctx, larkcore.StringValue(message.MessageId), reply); err != nil {
+		log.Err(err).Msg("[LarkListener.handleAdminCommandEvent] Failed to reply admin command")
+		return err
+	}
+	return nil
+}
+
+// runAdminCommand runs an admin command given its arguments, and returns the reply.
+func (l *LarkListener) runAdminCommand(args []string) string {
+	usage := fmt.Sprintf("可用命令：\n%s：查看所有死信\n%s <ID>：重新发送死信\n%s <ID>：丢弃死信（请先手动通知收件人）",
+		pkg.LARK_IM_ADMIN_COMMAND_DEAD_LETTERS, pkg.LARK_IM_ADMIN_COMMAND_RETRY, pkg.LARK_IM_ADMIN_COMMAND_DISCARD)
+	switch {
+	case args[0] == pkg.LARK_IM_ADMIN_COMMAND_DEAD_LETTERS && len(args) == 1:
+		deadLetters, err := l.notificationOutbox.ListDeadLetters()
+		if err != nil {
+			return fmt.Sprintf("查询死信失败：%s", err)
+		}
+		if len(deadLetters) == 0 {
+			return "没有死信"
+		}
+		lines := []string{fmt.Sprintf("共 %d 条死信：", len(deadLetters))}
+		for _, deadLetter := range deadLetters {
+			lines = append(lines, fmt.Sprintf("ID：%s\n收件人：%s\n标题：%s\n尝试次数：%d\n错误：%s",
+				deadLetter.ID, deadLetter.Recipient, deadLetter.Email.Subject, deadLetter.Attempts, deadLetter.LastError))
+		}
+		return strings.Join(lines, "\n\n")
+	case args[0] == pkg.LARK_IM_ADMIN_COMMAND_RETRY && len(args) == 2:
+		if err := l.notificationOutbox.RetryDeadLetter(args[1]); err != nil {
+			return fmt.Sprintf("重新发送死信 %s 失败：%s", args[1], err)
+		}
+		return fmt.Sprintf("死信 %s 已重新加入发送队列", args[1])
+	case args[0] == pkg.LARK_IM_ADMIN_COMMAND_DISCARD && len(args) == 2:
+		if err := l.notificationOutbox.DiscardDeadLetter(args[1]); err != nil {
+			return fmt.Sprintf("丢弃死信 %s 失败：%s", args[1], err)
+		}
+		return fmt.Sprintf("死信 %s 已丢弃", args[1])
+	default:
+		return usage
+	}
 }
 
 // handleMessageReceiveEvent handles message receive events
 // It is for testing purpose, and not used in production
-func (l *LarkListener) handleMessageReceiveEvent(_ context.Context, event *larkim.P2MessageReceiveV1) error {
+func (l *LarkListener) handleMessageReceiveEvent(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
 	fmt.Printf("[OnP2MessageReceiveV1 access], data: %s\n", larkcore.Prettify(event))
 	/**
 	* 解析用户发送的消息。
@@ -347,7 +929,7 @@ func (l *LarkListener) handleMessageReceiveEvent(_ context.Context, event *larki
 		* 使用SDK调用发送消息接口。 Use SDK to call send message interface.
 		* https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/im-v1/message/create
 		 */
-		resp, err := http.LarkClient.Im.Message.Create(context.Background(), larkim.NewCreateMessageReqBuilder().
+		resp, err := http.LarkClient.Im.Message.Create(ctx, larkim.NewCreateMessageReqBuilder().
 			ReceiveIdType(larkim.ReceiveIdTypeChatId). // 消息接收者的 ID 类型，设置为会话ID。 ID type of the message receiver, set to chat ID.
 			Body(larkim.NewCreateMessageReqBodyBuilder().
 				MsgType(larkim.MsgTypeText).            // 设置消息类型为文本消息。 Set message type to text message.
@@ -367,7 +949,7 @@ func (l *LarkListener) handleMessageReceiveEvent(_ context.Context, event *larki
 		* 使用SDK调用回复消息接口。 Use SDK to call send message interface.
 		* https://open.feishu.cn/document/server-docs/im-v1/message/reply
 		 */
-		resp, err := http.LarkClient.Im.Message.Reply(context.Background(), larkim.NewReplyMessageReqBuilder().
+		resp, err := http.LarkClient.Im.Message.Reply(ctx, larkim.NewReplyMessageReqBuilder().
 			MessageId(*event.Event.Message.MessageId).
 			Body(larkim.NewReplyMessageReqBodyBuilder().
 				MsgType(larkim.MsgTypeText). // 设置消息类型为文本消息。 Set message type to text message.
@@ -0,0 +1,474 @@
+package listener
+
+import (
+	"context"
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/internal/repository"
+	"dantaautotool/internal/service"
+	"dantaautotool/pkg"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher/callback"
+	larkbitable "github.com/larksuite/oapi-sdk-go/v3/service/bitable/v1"
+	larkdrive "github.com/larksuite/oapi-sdk-go/v3/service/drive/v1"
+)
+
+// fakeLarkDocService returns the records in records, by record ID.
+type fakeLarkDocService struct {
+	records map[string]*larkbitable.AppTableRecord
+}
+
+func (s *fakeLarkDocService) GetDocumentTitle(ctx context.Context, documentID string) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (s *fakeLarkDocService) BatchQueryBitableRecords(ctx context.Context, appToken, tableID string, recordIDs []string) ([]*larkbitable.AppTableRecord, error) {
+	records := make([]*larkbitable.AppTableRecord, 0, len(recordIDs))
+	for _, recordID := range recordIDs {
+		if record, ok := s.records[recordID]; ok {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (s *fakeLarkDocService) AddBitableRecord(ctx context.Context, appToken, tableID string, fields map[string]interface{}) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (s *fakeLarkDocService) SearchBitableRecords(ctx context.Context, appToken, tableID, fieldName, value string) ([]*larkbitable.AppTableRecord, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeLarkDocService) UpdateBitableRecord(ctx context.Context, appToken, tableID, recordID string, fields map[string]interface{}) error {
+	return errors.New("not implemented")
+}
+
+// templateMessage is a card message sent or updated by template.
+type templateMessage struct {
+	messageID  string
+	templateID string
+	variables  map[string]interface{}
+}
+
+// fakeLarkIMService records the cards sent, and sends the cards updated and the replies to the channels,
+// which are done in background by the listener.
+type fakeLarkIMService struct {
+	mu   sync.Mutex
+	sent []templateMessage
+
+	updated chan templateMessage
+	replies chan string
+}
+
+func newFakeLarkIMService() *fakeLarkIMService {
+	return &fakeLarkIMService{
+		updated: make(chan templateMessage, 10),
+		replies: make(chan string, 10),
+	}
+}
+
+func (s *fakeLarkIMService) SendCardMessageByTemplate(ctx context.Context, receiveIdType, receiveID string, templateCardID string, templateVariables map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, templateMessage{templateID: templateCardID, variables: templateVariables})
+	return nil
+}
+
+func (s *fakeLarkIMService) UpdateCardMessageByTemplate(ctx context.Context, messageID string, templateCardID string, templateVariables map[string]interface{}) error {
+	s.updated <- templateMessage{messageID: messageID, templateID: templateCardID, variables: templateVariables}
+	return nil
+}
+
+func (s *fakeLarkIMService) SendMessage(ctx context.Context, receiveIdType, receiveID, content string) error {
+	return nil
+}
+
+func (s *fakeLarkIMService) SendTextMessage(ctx context.Context, receiveIdType, receiveID, text string) error {
+	return nil
+}
+
+func (s *fakeLarkIMService) ReplyTextMessage(ctx context.Context, messageID, text string) error {
+	s.replies <- text
+	return nil
+}
+
+// sentCards returns the cards sent, in order.
+func (s *fakeLarkIMService) sentCards() []templateMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]templateMessage(nil), s.sent...)
+}
+
+// fakeLarkContactService names every user after the open ID.
+type fakeLarkContactService struct{}
+
+func (s *fakeLarkContactService) GetUserName(ctx context.Context, openID string) (string, error) {
+	return "name of " + openID, nil
+}
+
+// fakeDantaService converts a record to an application titled by its 标题 field, and records the applications decided.
+type fakeDantaService struct {
+	mu        sync.Mutex
+	reasons   map[string][]string
+	withdrawn []string
+}
+
+func (s *fakeDantaService) UpdateBannerAndNotify(ctx context.Context, recordID string, usageLog entity.BannerUsageLog, toEmailList []string) error {
+	return errors.New("not implemented")
+}
+
+func (s *fakeDantaService) UpdateBanner(ctx context.Context, recordID string, newBanner entity.Banner, approver string) (*entity.BannerConfigChange, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeDantaService) RemoveBanner(ctx context.Context, recordID string, bannerTitle string) (*entity.BannerConfigChange, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeDantaService) DisapproveBanner(ctx context.Context, recordID string, application entity.BannerApplication, reasons []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reasons == nil {
+		s.reasons = make(map[string][]string)
+	}
+	s.reasons[recordID] = reasons
+	return nil
+}
+
+func (s *fakeDantaService) WithdrawBanner(ctx context.Context, recordID string, application entity.BannerApplication) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.withdrawn = append(s.withdrawn, recordID)
+	return nil
+}
+
+func (s *fakeDantaService) LogBannerUsage(ctx context.Context, recordID string) error {
+	return nil
+}
+
+func (s *fakeDantaService) NotifyBannerUpdate(ctx context.Context, usageLog entity.BannerUsageLog, change *entity.BannerConfigChange, toEmailList []string) error {
+	return nil
+}
+
+func (s *fakeDantaService) ConvertBitableRecord2BannerApplication(record *larkbitable.AppTableRecord) *entity.BannerApplication {
+	title, _ := record.Fields["标题"].(string)
+	return &entity.BannerApplication{Banner: entity.Banner{Title: title}, ApplicantEmail: "applicant@example.com"}
+}
+
+func (s *fakeDantaService) IsBitableRecordWithdrawn(record *larkbitable.AppTableRecord) bool {
+	return record.Fields[pkg.LARK_BITABLE_FIELD_BANNER_STATUS] == pkg.LARK_BITABLE_FIELD_BANNER_STATUS_WITHDRAWN
+}
+
+// fakeBannerScheduler publishes every banner at once, and records the applications scheduled.
+type fakeBannerScheduler struct {
+	mu        sync.Mutex
+	scheduled []string
+}
+
+func (s *fakeBannerScheduler) Schedule(ctx context.Context, recordID string, approver string) (*entity.BannerConfigChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scheduled = append(s.scheduled, recordID)
+	return &entity.BannerConfigChange{CommitSHA: "sha", Link: "https://github.com/DanXi-Dev/DanXi-Static/commit/sha"}, nil
+}
+
+func (s *fakeBannerScheduler) Start() {}
+
+// testListener is a LarkListener on fake services, with a bbolt repository in a temporary directory.
+type testListener struct {
+	*LarkListener
+	docService   *fakeLarkDocService
+	imService    *fakeLarkIMService
+	dantaService *fakeDantaService
+	scheduler    *fakeBannerScheduler
+	repository   *repository.BannerApplicationRepository
+}
+
+// newTestListener creates a testListener, with the vote card, the decided card and the quorums configured.
+func newTestListener(t *testing.T, approveQuorum, disapproveQuorum int) *testListener {
+	t.Helper()
+	original := config.Config
+	t.Cleanup(func() {
+		config.Config = original
+	})
+	config.Config.LarkBannerBitableAppToken = "app_token"
+	config.Config.LarkBannerBitableApplicationTableID = "application_table"
+	config.Config.LarkBannerApproveGroupID = "approve_group"
+	config.Config.LarkBannerApproveCardID = "vote_card"
+	config.Config.LarkBannerDecidedCardID = "decided_card"
+	config.Config.LarkBannerApproveQuorum = approveQuorum
+	config.Config.LarkBannerDisapproveQuorum = disapproveQuorum
+	config.Config.LarkEventDedupTTLSeconds = 3600
+	config.Config.LarkCardCallbackTimeoutMilliseconds = 2500
+	config.Config.LarkEventHandleTimeoutSeconds = 60
+	config.Config.GithubReviewMode = false
+
+	db, err := repository.OpenBoltDB(filepath.Join(t.TempDir(), "danta.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltDB() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	bannerApplicationRepository, err := repository.NewBannerApplicationRepository(db)
+	if err != nil {
+		t.Fatalf("NewBannerApplicationRepository() error = %v", err)
+	}
+
+	l := &testListener{
+		docService:   &fakeLarkDocService{records: make(map[string]*larkbitable.AppTableRecord)},
+		imService:    newFakeLarkIMService(),
+		dantaService: &fakeDantaService{},
+		scheduler:    &fakeBannerScheduler{},
+		repository:   bannerApplicationRepository,
+	}
+	l.LarkListener = NewLarkListener(l.docService, l.imService, &fakeLarkContactService{}, l.dantaService, l.scheduler,
+		service.NewBannerVoteService(bannerApplicationRepository), nil, bannerApplicationRepository)
+	return l
+}
+
+// addRecord adds a record with the given title to the application table.
+func (l *testListener) addRecord(recordID, title string) {
+	l.docService.records[recordID] = &larkbitable.AppTableRecord{
+		RecordId: &recordID,
+		Fields:   map[string]interface{}{"标题": title},
+	}
+}
+
+// eventBase returns the base of an event with the given ID.
+func eventBase(eventID string) *larkevent.EventV2Base {
+	return &larkevent.EventV2Base{Header: &larkevent.EventHeader{EventID: eventID}}
+}
+
+// bitableEvent returns a bitable record changed event of the application table, with the action on each record.
+func bitableEvent(eventID, action string, recordIDs ...string) *larkdrive.P2FileBitableRecordChangedV1 {
+	fileToken, tableID := config.Config.LarkBannerBitableAppToken, config.Config.LarkBannerBitableApplicationTableID
+	actions := make([]*larkdrive.BitableTableRecordAction, 0, len(recordIDs))
+	for _, recordID := range recordIDs {
+		actions = append(actions, &larkdrive.BitableTableRecordAction{RecordId: &recordID, Action: &action})
+	}
+	return &larkdrive.P2FileBitableRecordChangedV1{
+		EventV2Base: eventBase(eventID),
+		Event:       &larkdrive.P2FileBitableRecordChangedV1Data{FileToken: &fileToken, TableId: &tableID, ActionList: actions},
+	}
+}
+
+// cardEvent returns a callback of a button of the card of recordID clicked by operator, with the form values if any.
+func cardEvent(eventID, operator, action, recordID string, formValue map[string]interface{}) *callback.CardActionTriggerEvent {
+	return &callback.CardActionTriggerEvent{
+		EventV2Base: eventBase(eventID),
+		Event: &callback.CardActionTriggerRequest{
+			Operator: &callback.Operator{OpenID: operator},
+			Action: &callback.CallBackAction{
+				Tag:       "button",
+				Value:     map[string]interface{}{"action": action, "record_id": recordID},
+				FormValue: formValue,
+			},
+			Context: &callback.Context{OpenMessageID: "om_" + recordID},
+		},
+	}
+}
+
+// waitFor returns the next value sent to the channel, and fails the test if none is sent in time.
+func waitFor[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+func TestLarkListenerSendsOneVoteCardPerRecord(t *testing.T) {
+	l := newTestListener(t, 1, 1)
+	l.addRecord("rec1", "Welcome")
+	ctx := context.Background()
+
+	if err := l.onBitableRecordChanged(ctx, bitableEvent("ev1", pkg.LARK_BITABLE_RECORD_ACTION_ADD, "rec1", "rec1")); err != nil {
+		t.Fatalf("onBitableRecordChanged() error = %v", err)
+	}
+	// the same event redelivered is dropped, and another event for the same record sends no other card
+	if err := l.onBitableRecordChanged(ctx, bitableEvent("ev1", pkg.LARK_BITABLE_RECORD_ACTION_ADD, "rec1")); err != nil {
+		t.Fatalf("onBitableRecordChanged() of the redelivered event error = %v", err)
+	}
+	if err := l.onBitableRecordChanged(ctx, bitableEvent("ev2", pkg.LARK_BITABLE_RECORD_ACTION_ADD, "rec1")); err != nil {
+		t.Fatalf("onBitableRecordChanged() of the second event error = %v", err)
+	}
+
+	sent := l.imService.sentCards()
+	if len(sent) != 1 || sent[0].templateID != "vote_card" || sent[0].variables["record_id"] != "rec1" || sent[0].variables["banner_title"] != "Welcome" {
+		t.Fatalf("sent cards = %+v, want one vote card of rec1", sent)
+	}
+	state, err := l.repository.Get("rec1")
+	if err != nil || state == nil || state.VoteCardSentAt == 0 || state.Title != "Welcome" {
+		t.Errorf("Get() = %+v, %v, want the application with the vote card sent", state, err)
+	}
+
+	// a card is still sent for another record
+	l.addRecord("rec2", "Hello")
+	if err := l.onBitableRecordChanged(ctx, bitableEvent("ev3", pkg.LARK_BITABLE_RECORD_ACTION_ADD, "rec2")); err != nil {
+		t.Fatalf("onBitableRecordChanged() error = %v", err)
+	}
+	if sent := l.imService.sentCards(); len(sent) != 2 || sent[1].variables["record_id"] != "rec2" {
+		t.Errorf("sent cards = %+v, want a vote card of rec2 too", sent)
+	}
+}
+
+func TestLarkListenerDropsRedeliveredCardCallback(t *testing.T) {
+	l := newTestListener(t, 2, 1)
+	l.addRecord("rec1", "Welcome")
+	ctx := context.Background()
+
+	resp, err := l.onCardActionTrigger(ctx, cardEvent("ev1", "ou_1", pkg.LARK_IM_CARD_ACTION_APPROVE, "rec1", nil))
+	if err != nil || resp == nil {
+		t.Fatalf("onCardActionTrigger() = %+v, %v", resp, err)
+	}
+	// the redelivered callback is not answered, and the vote is counted once
+	resp, err = l.onCardActionTrigger(ctx, cardEvent("ev1", "ou_1", pkg.LARK_IM_CARD_ACTION_APPROVE, "rec1", nil))
+	if err != nil || resp != nil {
+		t.Errorf("onCardActionTrigger() of the redelivered callback = %+v, %v, want nothing", resp, err)
+	}
+	state, err := l.repository.Get("rec1")
+	if err != nil || state == nil || len(state.Approvers) != 1 || state.Status != pkg.BANNER_STATUS_PENDING {
+		t.Fatalf("Get() = %+v, %v, want one approval", state, err)
+	}
+
+	// another click of the same voter is a new event, which is rejected as a duplicated vote
+	resp, err = l.onCardActionTrigger(ctx, cardEvent("ev2", "ou_1", pkg.LARK_IM_CARD_ACTION_APPROVE, "rec1", nil))
+	if err != nil || resp == nil || resp.Toast == nil || resp.Toast.Content != "You have voted!" {
+		t.Errorf("onCardActionTrigger() of a second click = %+v, %v, want the duplicated vote toast", resp, err)
+	}
+	if state, _ := l.repository.Get("rec1"); len(state.Approvers) != 1 {
+		t.Errorf("approvers = %v, want one approval", state.Approvers)
+	}
+}
+
+// cardTemplate returns the template card of the response, and fails the test if there is none.
+func cardTemplate(t *testing.T, resp *callback.CardActionTriggerResponse) *callback.TemplateCard {
+	t.Helper()
+	if resp == nil || resp.Card == nil {
+		t.Fatalf("response = %+v, want a card", resp)
+	}
+	templateCard, ok := resp.Card.Data.(*callback.TemplateCard)
+	if !ok {
+		t.Fatalf("card = %+v, want a template card", resp.Card)
+	}
+	return templateCard
+}
+
+func TestLarkListenerReplacesVoteCardOnceApproved(t *testing.T) {
+	l := newTestListener(t, 2, 1)
+	l.addRecord("rec1", "Welcome")
+	ctx := context.Background()
+
+	// the vote card shows the tally until the quorum is reached
+	resp, err := l.onCardActionTrigger(ctx, cardEvent("ev1", "ou_1", pkg.LARK_IM_CARD_ACTION_APPROVE, "rec1", nil))
+	if err != nil {
+		t.Fatalf("onCardActionTrigger() error = %v", err)
+	}
+	card := cardTemplate(t, resp)
+	if card.TemplateID != "vote_card" || card.TemplateVariable["approve_count"] != 1 || card.TemplateVariable["approve_quorum"] != 2 {
+		t.Errorf("card after the first approval = %+v, want the vote card with 1 of 2 approvals", card)
+	}
+	if len(l.scheduler.scheduled) != 0 {
+		t.Errorf("scheduled = %v, want nothing before the quorum is reached", l.scheduler.scheduled)
+	}
+
+	// the approval reaching the quorum replaces the vote card with the decided card
+	resp, err = l.onCardActionTrigger(ctx, cardEvent("ev2", "ou_2", pkg.LARK_IM_CARD_ACTION_APPROVE, "rec1", map[string]interface{}{"notes_input": "looks good"}))
+	if err != nil {
+		t.Fatalf("onCardActionTrigger() error = %v", err)
+	}
+	card = cardTemplate(t, resp)
+	if card.TemplateID != "decided_card" || card.TemplateVariable["status"] != pkg.BANNER_STATUS_APPROVED || card.TemplateVariable["publishing"] != true ||
+		card.TemplateVariable["decider_names"] != "name of ou_1, name of ou_2" || card.TemplateVariable["notes"] != "looks good" {
+		t.Errorf("card after the quorum is reached = %+v, want the decided card while publishing", card)
+	}
+
+	// the decided card is updated with the commit once the banner is published in background
+	updated := waitFor(t, l.imService.updated, "the card updated")
+	if updated.messageID != "om_rec1" || updated.templateID != "decided_card" || updated.variables["publishing"] != false ||
+		updated.variables["commit_link"] != "https://github.com/DanXi-Dev/DanXi-Static/commit/sha" || updated.variables["status_text"] != "已通过" {
+		t.Errorf("card updated = %+v, want the decided card with the commit link", updated)
+	}
+	l.scheduler.mu.Lock()
+	scheduled := append([]string(nil), l.scheduler.scheduled...)
+	l.scheduler.mu.Unlock()
+	if len(scheduled) != 1 || scheduled[0] != "rec1" {
+		t.Errorf("scheduled = %v, want rec1", scheduled)
+	}
+
+	// a click on the card after the decision is rejected
+	resp, err = l.onCardActionTrigger(ctx, cardEvent("ev3", "ou_3", pkg.LARK_IM_CARD_ACTION_APPROVE, "rec1", nil))
+	if err != nil || resp == nil || resp.Toast == nil || resp.Toast.Content != "The application has been decided!" || resp.Card != nil {
+		t.Errorf("onCardActionTrigger() after the decision = %+v, %v, want the decided toast only", resp, err)
+	}
+}
+
+func TestLarkListenerKeepsVoteCardWithoutDecidedCard(t *testing.T) {
+	l := newTestListener(t, 1, 1)
+	config.Config.LarkBannerDecidedCardID = ""
+	l.addRecord("rec1", "Welcome")
+
+	resp, err := l.onCardActionTrigger(context.Background(), cardEvent("ev1", "ou_1", pkg.LARK_IM_CARD_ACTION_APPROVE, "rec1", nil))
+	if err != nil {
+		t.Fatalf("onCardActionTrigger() error = %v", err)
+	}
+	// the vote card template is kept, with the decision in its variables
+	card := cardTemplate(t, resp)
+	if card.TemplateID != "vote_card" || card.TemplateVariable["status"] != pkg.BANNER_STATUS_APPROVED || card.TemplateVariable["decider_names"] != "name of ou_1" {
+		t.Errorf("card = %+v, want the vote card with the decision", card)
+	}
+	updated := waitFor(t, l.imService.updated, "the card updated")
+	if updated.templateID != "vote_card" || updated.variables["commit_link"] == "" {
+		t.Errorf("card updated = %+v, want the vote card with the commit link", updated)
+	}
+}
+
+func TestLarkListenerRequiresDisapproveReason(t *testing.T) {
+	l := newTestListener(t, 1, 1)
+	l.addRecord("rec1", "Welcome")
+	ctx := context.Background()
+
+	// a disapproval without a reason is rejected, and not counted
+	for i, formValue := range []map[string]interface{}{nil, {"reason_input": "  "}} {
+		resp, err := l.onCardActionTrigger(ctx, cardEvent(fmt.Sprintf("ev%d", i), "ou_1", pkg.LARK_IM_CARD_ACTION_DISAPPROVE, "rec1", formValue))
+		if err != nil || resp == nil || resp.Toast == nil || resp.Toast.Content != "Please fill in the reason!" || resp.Card != nil {
+			t.Errorf("onCardActionTrigger() with form %v = %+v, %v, want the reason toast only", formValue, resp, err)
+		}
+	}
+	if state, err := l.repository.Get("rec1"); err != nil || len(state.Disapprovers) != 0 || state.Status != pkg.BANNER_STATUS_PENDING {
+		t.Fatalf("Get() = %+v, %v, want no disapproval", state, err)
+	}
+
+	// a disapproval with a reason decides the application, and the reason is recorded
+	resp, err := l.onCardActionTrigger(ctx, cardEvent("ev3", "ou_1", pkg.LARK_IM_CARD_ACTION_DISAPPROVE, "rec1", map[string]interface{}{"reason_input": " Too long "}))
+	if err != nil {
+		t.Fatalf("onCardActionTrigger() error = %v", err)
+	}
+	card := cardTemplate(t, resp)
+	if card.TemplateID != "decided_card" || card.TemplateVariable["status"] != pkg.BANNER_STATUS_DISAPPROVED || card.TemplateVariable["reasons"] != "Too long" {
+		t.Errorf("card = %+v, want the decided card with the reason", card)
+	}
+	if reasons := l.dantaService.reasons["rec1"]; len(reasons) != 1 || reasons[0] != "Too long" {
+		t.Errorf("reasons of the disapproval = %q, want the trimmed reason", reasons)
+	}
+
+	// the disapproved application cannot be withdrawn
+	resp, err = l.onCardActionTrigger(ctx, cardEvent("ev4", "ou_1", pkg.LARK_IM_CARD_ACTION_WITHDRAW, "rec1", nil))
+	if err != nil || resp == nil || resp.Toast == nil || resp.Toast.Content != "The application has been disapproved!" {
+		t.Errorf("onCardActionTrigger() of withdrawing = %+v, %v, want the disapproved toast", resp, err)
+	}
+	if len(l.dantaService.withdrawn) != 0 {
+		t.Errorf("withdrawn = %v, want nothing", l.dantaService.withdrawn)
+	}
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"dantaautotool/internal/entity"
+	"dantaautotool/pkg"
+	"fmt"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// bannerApplicationBucket is the bucket holding banner application states, keyed by record ID
+var bannerApplicationBucket = []byte("banner_applications")
+
+// BannerApplicationRepositoryIntf defines the interface for BannerApplicationRepository.
+type BannerApplicationRepositoryIntf interface {
+	// Get returns the state of the application with the given record ID, or nil if it does not exist.
+	Get(recordID string) (*entity.BannerApplicationState, error)
+
+	// Update reads the state of the application with the given record ID, applies fn to it, and saves the result atomically.
+	// If the application does not exist, fn is given a new pending state.
+	// If fn returns an error, nothing is saved, and the error is returned together with the state fn has seen.
+	Update(recordID string, fn func(state *entity.BannerApplicationState) error) (*entity.BannerApplicationState, error)
+
+	// ListByStatus returns the states of all applications with the given status.
+	ListByStatus(status string) ([]*entity.BannerApplicationState, error)
+}
+
+// BannerApplicationRepository stores banner application states in a bbolt database.
+type BannerApplicationRepository struct {
+	db *bolt.DB
+}
+
+// NewBannerApplicationRepository creates a new instance of BannerApplicationRepository.
+// It creates the bucket if it does not exist.
+func NewBannerApplicationRepository(db *bolt.DB) (*BannerApplicationRepository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bannerApplicationBucket)
+		return err
+	})
+	if err != nil {
+		log.Err(err).Msg("[NewBannerApplicationRepository] Failed to create bucket")
+		return nil, err
+	}
+	return &BannerApplicationRepository{
+		db: db,
+	}, nil
+}
+
+// Get returns the state of the application with the given record ID, or nil if it does not exist.
+func (r *BannerApplicationRepository) Get(recordID string) (*entity.BannerApplicationState, error) {
+	var state *entity.BannerApplicationState
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		state, err = getState(tx, recordID)
+		return err
+	})
+	if err != nil {
+		log.Err(err).Msgf("[BannerApplicationRepository.Get] Failed to get application, record ID: %s", recordID)
+		return nil, err
+	}
+	return state, nil
+}
+
+// Update reads the state of the application with the given record ID, applies fn to it, and saves the result atomically.
+// If the application does not exist, fn is given a new pending state.
+// If fn returns an error, nothing is saved, and the error is returned together with the state fn has seen.
+func (r *BannerApplicationRepository) Update(recordID string, fn func(state *entity.BannerApplicationState) error) (*entity.BannerApplicationState, error) {
+	var state *entity.BannerApplicationState
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		state, err = getState(tx, recordID)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UnixMilli()
+		if state == nil {
+			state = &entity.BannerApplicationState{
+				RecordID:     recordID,
+				Status:       pkg.BANNER_STATUS_PENDING,
+				Approvers:    make([]string, 0),
+				Disapprovers: make([]string, 0),
+				CreatedAt:    now,
+			}
+		}
+		if err = fn(state); err != nil {
+			return err
+		}
+		state.UpdatedAt = now
+
+		value, err := sonic.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("failed to encode application state: %w", err)
+		}
+		return tx.Bucket(bannerApplicationBucket).Put([]byte(recordID), value)
+	})
+	return state, err
+}
+
+// ListByStatus returns the states of all applications with the given status.
+func (r *BannerApplicationRepository) ListByStatus(status string) ([]*entity.BannerApplicationState, error) {
+	states := make([]*entity.BannerApplicationState, 0)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bannerApplicationBucket).ForEach(func(key, value []byte) error {
+			state := &entity.BannerApplicationState{}
+			if err := sonic.Unmarshal(value, state); err != nil {
+				return fmt.Errorf("failed to decode application state %s: %w", key, err)
+			}
+			if state.Status == status {
+				states = append(states, state)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Err(err).Msgf("[BannerApplicationRepository.ListByStatus] Failed to list applications, status: %s", status)
+		return nil, err
+	}
+	return states, nil
+}
+
+// getState reads the state of an application in a transaction, and returns nil if it does not exist.
+func getState(tx *bolt.Tx, recordID string) (*entity.BannerApplicationState, error) {
+	value := tx.Bucket(bannerApplicationBucket).Get([]byte(recordID))
+	if value == nil {
+		return nil, nil
+	}
+	state := &entity.BannerApplicationState{}
+	if err := sonic.Unmarshal(value, state); err != nil {
+		return nil, fmt.Errorf("failed to decode application state %s: %w", recordID, err)
+	}
+	return state, nil
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"dantaautotool/internal/entity"
+	"dantaautotool/pkg"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// openTestRepository opens a bbolt database at path, and creates a BannerApplicationRepository on it.
+func openTestRepository(t *testing.T, path string) (*bolt.DB, *BannerApplicationRepository) {
+	t.Helper()
+	db, err := OpenBoltDB(path)
+	if err != nil {
+		t.Fatalf("OpenBoltDB() error = %v", err)
+	}
+	repository, err := NewBannerApplicationRepository(db)
+	if err != nil {
+		db.Close()
+		t.Fatalf("NewBannerApplicationRepository() error = %v", err)
+	}
+	return db, repository
+}
+
+func TestBannerApplicationRepositoryUpdateCreatesMissing(t *testing.T) {
+	db, repository := openTestRepository(t, filepath.Join(t.TempDir(), "danta.db"))
+	defer db.Close()
+
+	state, err := repository.Get("rec1")
+	if err != nil || state != nil {
+		t.Fatalf("Get() of a missing application = %+v, %v, want nil", state, err)
+	}
+
+	var seen entity.BannerApplicationState
+	state, err = repository.Update("rec1", func(state *entity.BannerApplicationState) error {
+		seen = *state
+		state.Title = "Welcome"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if seen.RecordID != "rec1" || seen.Status != pkg.BANNER_STATUS_PENDING || seen.CreatedAt == 0 || seen.Approvers == nil || seen.Disapprovers == nil {
+		t.Errorf("Update() of a missing application gives %+v, want a new pending state", seen)
+	}
+	if state.Title != "Welcome" || state.UpdatedAt == 0 {
+		t.Errorf("Update() = %+v, want the updated state", state)
+	}
+
+	got, err := repository.Get("rec1")
+	if err != nil || got == nil || got.Title != "Welcome" || got.CreatedAt != seen.CreatedAt {
+		t.Errorf("Get() after Update() = %+v, %v, want the saved state", got, err)
+	}
+}
+
+func TestBannerApplicationRepositoryUpdateRollsBackOnError(t *testing.T) {
+	db, repository := openTestRepository(t, filepath.Join(t.TempDir(), "danta.db"))
+	defer db.Close()
+
+	_, err := repository.Update("rec1", func(state *entity.BannerApplicationState) error {
+		state.Title = "Welcome"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	errRejected := errors.New("rejected")
+	state, err := repository.Update("rec1", func(state *entity.BannerApplicationState) error {
+		state.Title = "Changed"
+		state.Approvers = append(state.Approvers, "ou_1")
+		return errRejected
+	})
+	if !errors.Is(err, errRejected) {
+		t.Fatalf("Update() error = %v, want %v", err, errRejected)
+	}
+	if state == nil || state.Title != "Changed" {
+		t.Errorf("Update() = %+v, want the state fn has seen", state)
+	}
+	got, err := repository.Get("rec1")
+	if err != nil || got.Title != "Welcome" || len(got.Approvers) != 0 {
+		t.Errorf("Get() after a failed Update() = %+v, %v, want the state before it", got, err)
+	}
+
+	// a missing application is not created if fn fails
+	_, err = repository.Update("rec2", func(state *entity.BannerApplicationState) error {
+		return errRejected
+	})
+	if !errors.Is(err, errRejected) {
+		t.Fatalf("Update() error = %v, want %v", err, errRejected)
+	}
+	if got, err := repository.Get("rec2"); err != nil || got != nil {
+		t.Errorf("Get() after a failed Update() of a missing application = %+v, %v, want nil", got, err)
+	}
+}
+
+func TestBannerApplicationRepositoryListByStatus(t *testing.T) {
+	db, repository := openTestRepository(t, filepath.Join(t.TempDir(), "danta.db"))
+	defer db.Close()
+
+	statuses := map[string]string{
+		"rec1": pkg.BANNER_STATUS_APPROVED,
+		"rec2": pkg.BANNER_STATUS_PENDING,
+		"rec3": pkg.BANNER_STATUS_APPROVED,
+		"rec4": pkg.BANNER_STATUS_WITHDRAWN,
+	}
+	for recordID, status := range statuses {
+		_, err := repository.Update(recordID, func(state *entity.BannerApplicationState) error {
+			state.Status = status
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+	// a status change moves the application to another list
+	_, err := repository.Update("rec3", func(state *entity.BannerApplicationState) error {
+		state.Status = pkg.BANNER_STATUS_EXPIRED
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	tests := []struct {
+		status string
+		want   []string
+	}{
+		{pkg.BANNER_STATUS_APPROVED, []string{"rec1"}},
+		{pkg.BANNER_STATUS_PENDING, []string{"rec2"}},
+		{pkg.BANNER_STATUS_EXPIRED, []string{"rec3"}},
+		{pkg.BANNER_STATUS_WITHDRAWN, []string{"rec4"}},
+		{pkg.BANNER_STATUS_DISAPPROVED, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			states, err := repository.ListByStatus(tt.status)
+			if err != nil {
+				t.Fatalf("ListByStatus() error = %v", err)
+			}
+			got := make([]string, 0, len(states))
+			for _, state := range states {
+				got = append(got, state.RecordID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ListByStatus(%s) = %v, want %v", tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBannerApplicationRepositoryReopen(t *testing.T) {
+	// the directory of the database is created if needed
+	path := filepath.Join(t.TempDir(), "data", "danta.db")
+	db, repository := openTestRepository(t, path)
+	_, err := repository.Update("rec1", func(state *entity.BannerApplicationState) error {
+		state.Title = "Welcome"
+		state.Status = pkg.BANNER_STATUS_APPROVED
+		state.Approvers = append(state.Approvers, "ou_1")
+		state.Published = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	db, repository = openTestRepository(t, path)
+	defer db.Close()
+	state, err := repository.Get("rec1")
+	if err != nil || state == nil {
+		t.Fatalf("Get() after reopening = %+v, %v", state, err)
+	}
+	if state.Title != "Welcome" || state.Status != pkg.BANNER_STATUS_APPROVED || !slices.Equal(state.Approvers, []string{"ou_1"}) || !state.Published {
+		t.Errorf("Get() after reopening = %+v, want the state saved before", state)
+	}
+	states, err := repository.ListByStatus(pkg.BANNER_STATUS_APPROVED)
+	if err != nil || len(states) != 1 {
+		t.Errorf("ListByStatus() after reopening = %+v, %v, want the approved application", states, err)
+	}
+}
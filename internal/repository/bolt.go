@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// OpenBoltDB opens (or creates) the bbolt database file at the given path, creating its directory if needed.
+// The caller is responsible for closing the database.
+func OpenBoltDB(path string) (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Err(err).Msgf("[OpenBoltDB] Failed to create directory for database: %s", path)
+		return nil, err
+	}
+	// Fail fast instead of blocking forever if another process holds the file lock
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Err(err).Msgf("[OpenBoltDB] Failed to open database: %s", path)
+		return nil, err
+	}
+	log.Info().Msgf("[OpenBoltDB] Database opened: %s", path)
+	return db, nil
+}
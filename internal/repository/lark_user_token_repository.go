@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"dantaautotool/internal/entity"
+	"fmt"
+
+	"github.com/bytedance/sonic"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// larkUserTokenBucket is the bucket holding Lark user tokens
+	larkUserTokenBucket = []byte("lark_user_tokens")
+
+	// larkMailUserTokenKey is the key of the token used to send emails
+	larkMailUserTokenKey = []byte("mail")
+)
+
+// LarkUserTokenRepositoryIntf defines the interface for LarkUserTokenRepository.
+type LarkUserTokenRepositoryIntf interface {
+	// Get returns the stored user token used to send emails, or nil if it does not exist.
+	Get() (*entity.LarkUserToken, error)
+
+	// Save replaces the stored user token used to send emails.
+	Save(token *entity.LarkUserToken) error
+}
+
+// LarkUserTokenRepository stores Lark user tokens in a bbolt database.
+type LarkUserTokenRepository struct {
+	db *bolt.DB
+}
+
+// NewLarkUserTokenRepository creates a new instance of LarkUserTokenRepository.
+// It creates the bucket if it does not exist.
+func NewLarkUserTokenRepository(db *bolt.DB) (*LarkUserTokenRepository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(larkUserTokenBucket)
+		return err
+	})
+	if err != nil {
+		log.Err(err).Msg("[NewLarkUserTokenRepository] Failed to create bucket")
+		return nil, err
+	}
+	return &LarkUserTokenRepository{
+		db: db,
+	}, nil
+}
+
+// Get returns the stored user token used to send emails, or nil if it does not exist.
+func (r *LarkUserTokenRepository) Get() (*entity.LarkUserToken, error) {
+	var token *entity.LarkUserToken
+	err := r.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(larkUserTokenBucket).Get(larkMailUserTokenKey)
+		if value == nil {
+			return nil
+		}
+		token = &entity.LarkUserToken{}
+		if err := sonic.Unmarshal(value, token); err != nil {
+			return fmt.Errorf("failed to decode user token: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msg("[LarkUserTokenRepository.Get] Failed to get user token")
+		return nil, err
+	}
+	return token, nil
+}
+
+// Save replaces the stored user token used to send emails.
+func (r *LarkUserTokenRepository) Save(token *entity.LarkUserToken) error {
+	value, err := sonic.Marshal(token)
+	if err != nil {
+		log.Err(err).Msg("[LarkUserTokenRepository.Save] Failed to encode user token")
+		return err
+	}
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(larkUserTokenBucket).Put(larkMailUserTokenKey, value)
+	})
+	if err != nil {
+		log.Err(err).Msg("[LarkUserTokenRepository.Save] Failed to save user token")
+		return err
+	}
+	return nil
+}
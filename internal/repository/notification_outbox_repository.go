@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"dantaautotool/internal/entity"
+	"dantaautotool/pkg"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// notificationOutboxBucket is the bucket holding notifications, keyed by ID
+var notificationOutboxBucket = []byte("notification_outbox")
+
+// ErrNotificationNotFound is returned when the notification with the given ID does not exist
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// NotificationOutboxRepositoryIntf defines the interface for NotificationOutboxRepository.
+type NotificationOutboxRepositoryIntf interface {
+	// Add adds the notifications atomically as pending ones, and assigns IDs to them.
+	Add(notifications []*entity.Notification) error
+
+	// Update reads the notification with the given ID, applies fn to it, and saves the result atomically.
+	// It returns an error if the notification does not exist.
+	Update(id string, fn func(notification *entity.Notification) error) (*entity.Notification, error)
+
+	// Delete deletes the notification with the given ID atomically, if it has the given status.
+	// It returns an error if the notification does not exist, or has another status.
+	Delete(id string, status string) error
+
+	// ListByStatus returns all notifications with the given status, in the order they are added.
+	ListByStatus(status string) ([]*entity.Notification, error)
+}
+
+// NotificationOutboxRepository stores notifications in a bbolt database.
+type NotificationOutboxRepository struct {
+	db *bolt.DB
+}
+
+// NewNotificationOutboxRepository creates a new instance of NotificationOutboxRepository.
+// It creates the bucket if it does not exist.
+func NewNotificationOutboxRepository(db *bolt.DB) (*NotificationOutboxRepository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(notificationOutboxBucket)
+		return err
+	})
+	if err != nil {
+		log.Err(err).Msg("[NewNotificationOutboxRepository] Failed to create bucket")
+		return nil, err
+	}
+	return &NotificationOutboxRepository{
+		db: db,
+	}, nil
+}
+
+// Add adds the notifications atomically as pending ones, and assigns IDs to them.
+func (r *NotificationOutboxRepository) Add(notifications []*entity.Notification) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(notificationOutboxBucket)
+		now := time.Now().UnixMilli()
+		for _, notification := range notifications {
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			// zero-padded, so that keys are sorted by the order of adding
+			notification.ID = fmt.Sprintf("%016d", seq)
+			notification.Status = pkg.NOTIFICATION_STATUS_PENDING
+			notification.CreatedAt = now
+			notification.UpdatedAt = now
+			notification.NextAttemptAt = now
+
+			value, err := sonic.Marshal(notification)
+			if err != nil {
+				return fmt.Errorf("failed to encode notification: %w", err)
+			}
+			if err := bucket.Put([]byte(notification.ID), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msg("[NotificationOutboxRepository.Add] Failed to add notifications")
+		return err
+	}
+	return nil
+}
+
+// Update reads the notification with the given ID, applies fn to it, and saves the result atomically.
+// It returns an error if the notification does not exist.
+func (r *NotificationOutboxRepository) Update(id string, fn func(notification *entity.Notification) error) (*entity.Notification, error) {
+	var notification *entity.Notification
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(notificationOutboxBucket)
+		value := bucket.Get([]byte(id))
+		if value == nil {
+			return fmt.Errorf("%w: %s", ErrNotificationNotFound, id)
+		}
+		notification = &entity.Notification{}
+		if err := sonic.Unmarshal(value, notification); err != nil {
+			return fmt.Errorf("failed to decode notification %s: %w", id, err)
+		}
+		if err := fn(notification); err != nil {
+			return err
+		}
+		notification.UpdatedAt = time.Now().UnixMilli()
+
+		value, err := sonic.Marshal(notification)
+		if err != nil {
+			return fmt.Errorf("failed to encode notification: %w", err)
+		}
+		return bucket.Put([]byte(id), value)
+	})
+	if err != nil {
+		log.Err(err).Msgf("[NotificationOutboxRepository.Update] Failed to update notification, ID: %s", id)
+		return nil, err
+	}
+	return notification, nil
+}
+
+// Delete deletes the notification with the given ID atomically, if it has the given status.
+// It returns an error if the notification does not exist, or has another status.
+func (r *NotificationOutboxRepository) Delete(id string, status string) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(notificationOutboxBucket)
+		value := bucket.Get([]byte(id))
+		if value == nil {
+			return fmt.Errorf("%w: %s", ErrNotificationNotFound, id)
+		}
+		notification := &entity.Notification{}
+		if err := sonic.Unmarshal(value, notification); err != nil {
+			return fmt.Errorf("failed to decode notification %s: %w", id, err)
+		}
+		if notification.Status != status {
+			return fmt.Errorf("notification %s is %s, not %s", id, notification.Status, status)
+		}
+		return bucket.Delete([]byte(id))
+	})
+	if err != nil {
+		log.Err(err).Msgf("[NotificationOutboxRepository.Delete] Failed to delete notification, ID: %s", id)
+		return err
+	}
+	return nil
+}
+
+// ListByStatus returns all notifications with the given status, in the order they are added.
+func (r *NotificationOutboxRepository) ListByStatus(status string) ([]*entity.Notification, error) {
+	notifications := make([]*entity.Notification, 0)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(notificationOutboxBucket).ForEach(func(key, value []byte) error {
+			notification := &entity.Notification{}
+			if err := sonic.Unmarshal(value, notification); err != nil {
+				return fmt.Errorf("failed to decode notification %s: %w", key, err)
+			}
+			if notification.Status == status {
+				notifications = append(notifications, notification)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Err(err).Msgf("[NotificationOutboxRepository.ListByStatus] Failed to list notifications, status: %s", status)
+		return nil, err
+	}
+	return notifications, nil
+}
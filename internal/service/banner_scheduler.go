@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/internal/repository"
+	"dantaautotool/pkg"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// BannerSchedulerOperationTimeout is the timeout of publishing or taking down a banner in a periodic check,
+// and in the background after a card callback is answered
+const BannerSchedulerOperationTimeout = time.Minute
+
+// ErrBannerApplicationNotFound is returned when scheduling an application which is not saved in the repository.
+var ErrBannerApplicationNotFound = errors.New("banner application not found")
+
+// BannerSchedulerIntf defines the interface for BannerScheduler.
+type BannerSchedulerIntf interface {
+	// Schedule schedules the banner of an approved application, which must have been saved in the repository.
+	// The banner is published on its start date (at once if the start date is not specified or has arrived),
+	// and removed after its end date passes.
+	// approver is the names of the approvers, which are recorded in the application.
+	// The application is also logged to the usage table, and a failed usage log is retried by the periodic check.
+	// It returns the committed change if the banner is published at once (nil otherwise, if it has been published,
+	// or if it is being published by the periodic check), and an error if the banner should be published at once but the publishing fails.
+	Schedule(ctx context.Context, recordID string, approver string) (*entity.BannerConfigChange, error)
+
+	// Start starts checking scheduled banners periodically in background.
+	Start()
+}
+
+// BannerScheduler publishes and takes down banners according to their start and end dates.
+// Scheduled banners are the approved applications in the repository, so that they survive restarts.
+type BannerScheduler struct {
+	// dantaService is used to update banner config file
+	dantaService DantaServiceIntf
+
+	// bannerApplicationRepository is used to persist the states of banner applications
+	bannerApplicationRepository repository.BannerApplicationRepositoryIntf
+
+	// interval is the interval between two checks
+	interval time.Duration
+
+	// mu protects inFlight
+	mu sync.Mutex
+
+	// inFlight holds the record IDs of the applications being published or taken down, so that a banner is never
+	// published twice, while the slow calls to Github are made without holding mu
+	inFlight map[string]struct{}
+}
+
+// NewBannerScheduler creates a new instance of BannerScheduler.
+func NewBannerScheduler(dantaService DantaServiceIntf, bannerApplicationRepository repository.BannerApplicationRepositoryIntf) *BannerScheduler {
+	intervalSeconds := config.Config.BannerSchedulerIntervalSeconds
+	if intervalSeconds <= 0 {
+		log.Warn().Msgf("[NewBannerScheduler] Invalid interval: %d, fallback to 60 seconds", intervalSeconds)
+		intervalSeconds = 60
+	}
+	return &BannerScheduler{
+		dantaService:                dantaService,
+		bannerApplicationRepository: bannerApplicationRepository,
+		interval:                    time.Duration(intervalSeconds) * time.Second,
+		inFlight:                    make(map[string]struct{}),
+	}
+}
+
+// Schedule schedules the banner of an approved application, which must have been saved in the repository.
+// The banner is published on its start date (at once if the start date is not specified or has arrived),
+// and removed after its end date passes.
+// approver is the names of the approvers, which are recorded in the application.
+// The saved application is the source of truth, so only its status and approvers are changed here.
+// The application is also logged to the usage table, and a failed usage log is retried by the periodic check,
+// so that it never fails an approval whose banner has been published.
+// It returns the committed change if the banner is published at once (nil otherwise, if it has been published,
+// or if it is being published by the periodic check), and an error if the banner should be published at once but the publishing fails.
+func (s *BannerScheduler) Schedule(ctx context.Context, recordID string, approver string) (*entity.BannerConfigChange, error) {
+	state, err := s.bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+		if state.Title == "" {
+			return fmt.Errorf("%w: %s", ErrBannerApplicationNotFound, recordID)
+		}
+		state.Approver = approver
+		state.Status = pkg.BANNER_STATUS_APPROVED
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("[BannerScheduler.Schedule] Failed to save application, record ID: %s", recordID)
+		return nil, err
+	}
+	if state.Published {
+		log.Warn().Msgf("[BannerScheduler.Schedule] Banner already published, title: %s", state.Title)
+		return nil, nil
+	}
+	log.Info().Msgf("[BannerScheduler.Schedule] Banner scheduled, title: %s, start date: %d, end date: %d", state.Title, state.StartDate, state.EndDate)
+
+	if !s.claim(recordID) {
+		log.Info().Msgf("[BannerScheduler.Schedule] Banner is being handled by the periodic check, title: %s", state.Title)
+		return nil, nil
+	}
+	defer s.release(recordID)
+	var change *entity.BannerConfigChange
+	if !time.Now().Before(bannerStartTime(state.BannerUsageLog)) {
+		change, err = s.publish(ctx, recordID)
+		if err != nil {
+			log.Err(err).Msgf("[BannerScheduler.Schedule] Failed to publish banner, title: %s", state.Title)
+			return nil, err
+		}
+	}
+	s.logUsage(ctx, recordID)
+	return change, nil
+}
+
+// Start starts checking scheduled banners periodically in background.
+// Banners scheduled before a restart are picked up in the first check.
+func (s *BannerScheduler) Start() {
+	go func() {
+		s.check()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.check()
+		}
+	}()
+	log.Info().Msgf("[BannerScheduler.Start] Banner scheduler started, interval: %s", s.interval)
+}
+
+// check publishes banners whose start date has arrived, takes down banners whose end date has passed,
+// and logs the applications whose usage log failed to the usage table.
+// It also takes down the banners of withdrawn applications which were published while being withdrawn.
+// Failed operations are retried in the next check.
+func (s *BannerScheduler) check() {
+	states, err := s.bannerApplicationRepository.ListByStatus(pkg.BANNER_STATUS_APPROVED)
+	if err != nil {
+		log.Err(err).Msg("[BannerScheduler.check] Failed to list approved applications")
+		return
+	}
+
+	now := time.Now()
+	for _, state := range states {
+		s.checkApplication(state, now)
+	}
+
+	states, err = s.bannerApplicationRepository.ListByStatus(pkg.BANNER_STATUS_WITHDRAWN)
+	if err != nil {
+		log.Err(err).Msg("[BannerScheduler.check] Failed to list withdrawn applications")
+		return
+	}
+	for _, state := range states {
+		if state.Published {
+			s.takeDownWithdrawn(state)
+		}
+	}
+}
+
+// takeDownWithdrawn takes down the banner of a withdrawn application.
+// An application being handled by Schedule is skipped, and left to the next check.
+func (s *BannerScheduler) takeDownWithdrawn(state *entity.BannerApplicationState) {
+	if !s.claim(state.RecordID) {
+		return
+	}
+	defer s.release(state.RecordID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), BannerSchedulerOperationTimeout)
+	change, err := s.dantaService.RemoveBanner(ctx, state.RecordID, state.Title)
+	cancel()
+	if err != nil {
+		log.Err(err).Msgf("[BannerScheduler.takeDownWithdrawn] Failed to take down banner, title: %s", state.Title)
+		return
+	}
+	_, err = s.bannerApplicationRepository.Update(state.RecordID, func(state *entity.BannerApplicationState) error {
+		state.Published = false
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("[BannerScheduler.takeDownWithdrawn] Failed to mark banner as taken down, record ID: %s", state.RecordID)
+		return
+	}
+	if change != nil {
+		log.Info().Msgf("[BannerScheduler.takeDownWithdrawn] Banner of withdrawn application taken down, title: %s, link: %s", state.Title, change.Link)
+	}
+}
+
+// checkApplication publishes or takes down the banner of an approved application if it is due,
+// and logs the application to the usage table if it has not been logged.
+// An application being handled by Schedule is skipped, and left to the next check.
+func (s *BannerScheduler) checkApplication(state *entity.BannerApplicationState, now time.Time) {
+	title := state.Title
+	expired := state.EndDate != 0 && !now.Before(bannerEndTime(state.BannerUsageLog))
+	due := expired || (!state.Published && !now.Before(bannerStartTime(state.BannerUsageLog)))
+	if !due && state.UsageLoggedAt != 0 {
+		return
+	}
+	if !s.claim(state.RecordID) {
+		return
+	}
+	defer s.release(state.RecordID)
+
+	if state.UsageLoggedAt == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), BannerSchedulerOperationTimeout)
+		s.logUsage(ctx, state.RecordID)
+		cancel()
+	}
+	if !due {
+		return
+	}
+
+	if !state.Published {
+		if expired {
+			log.Warn().Msgf("[BannerScheduler.checkApplication] Banner expired before being published, title: %s", title)
+			s.expire(state.RecordID)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), BannerSchedulerOperationTimeout)
+		change, err := s.publish(ctx, state.RecordID)
+		cancel()
+		if err != nil {
+			log.Err(err).Msgf("[BannerScheduler.checkApplication] Failed to publish banner, title: %s", title)
+			return
+		}
+		if change != nil {
+			log.Info().Msgf("[BannerScheduler.checkApplication] Banner published, title: %s, link: %s", title, change.Link)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), BannerSchedulerOperationTimeout)
+	change, err := s.dantaService.RemoveBanner(ctx, state.RecordID, title)
+	cancel()
+	if err != nil {
+		log.Err(err).Msgf("[BannerScheduler.checkApplication] Failed to take down banner, title: %s", title)
+		return
+	}
+	s.expire(state.RecordID)
+	if change != nil {
+		log.Info().Msgf("[BannerScheduler.checkApplication] Banner taken down, title: %s, link: %s", title, change.Link)
+	}
+}
+
+// publish adds the banner of an application to the config file, and marks the application as published.
+// The caller must have claimed the application.
+func (s *BannerScheduler) publish(ctx context.Context, recordID string) (*entity.BannerConfigChange, error) {
+	// The application may have been withdrawn or published since it was read
+	state, err := s.bannerApplicationRepository.Get(recordID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil || state.Status != pkg.BANNER_STATUS_APPROVED {
+		log.Info().Msgf("[BannerScheduler.publish] Application is no longer approved, skip publishing, record ID: %s", recordID)
+		return nil, nil
+	}
+	if state.Published {
+		log.Info().Msgf("[BannerScheduler.publish] Banner already published, title: %s", state.Title)
+		return nil, nil
+	}
+
+	change, err := s.dantaService.UpdateBanner(ctx, recordID, state.Banner, state.Approver)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+		// the vote may have been retracted while publishing, but the banner is live now,
+		// and it must stay approved to be taken down on its end date.
+		// An application withdrawn meanwhile stays withdrawn, and its banner is taken down by the next check.
+		if state.Status == pkg.BANNER_STATUS_PENDING {
+			log.Warn().Msgf("[BannerScheduler.publish] Application is %s after publishing, keep it approved, record ID: %s", state.Status, recordID)
+			state.Status = pkg.BANNER_STATUS_APPROVED
+		}
+		state.Published = true
+		state.PublishedAt = time.Now().UnixMilli()
+		if change != nil {
+			state.CommitSHA = change.CommitSHA
+		}
+		return nil
+	})
+	if err != nil {
+		// the banner has been published, but it may be published again after restart
+		return change, fmt.Errorf("failed to mark application as published: %w", err)
+	}
+	return change, nil
+}
+
+// logUsage logs an application to the usage table.
+// A failure is only logged, since the usage log is retried by the next check.
+func (s *BannerScheduler) logUsage(ctx context.Context, recordID string) {
+	if err := s.dantaService.LogBannerUsage(ctx, recordID); err != nil {
+		log.Warn().Err(err).Msgf("[BannerScheduler.logUsage] Failed to log usage, retry in the next check, record ID: %s", recordID)
+	}
+}
+
+// claim marks an application as being published or taken down, and returns false if it already is.
+// The caller must release the application when done.
+func (s *BannerScheduler) claim(recordID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.inFlight[recordID]; ok {
+		return false
+	}
+	s.inFlight[recordID] = struct{}{}
+	return true
+}
+
+// release unmarks an application claimed by claim.
+func (s *BannerScheduler) release(recordID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, recordID)
+}
+
+// expire marks an application as expired, so that it is no longer scheduled.
+// Its banner has been taken down or was never published.
+// The caller must have claimed the application.
+func (s *BannerScheduler) expire(recordID string) {
+	_, err := s.bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+		state.Status = pkg.BANNER_STATUS_EXPIRED
+		state.Published = false
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("[BannerScheduler.expire] Failed to mark application as expired, record ID: %s", recordID)
+	}
+}
+
+// bannerStartTime returns the time when the banner should be published.
+func bannerStartTime(usageLog entity.BannerUsageLog) time.Time {
+	return time.UnixMilli(usageLog.StartDate)
+}
+
+// bannerEndTime returns the time when the banner should be taken down.
+// The end date is inclusive, so the banner is taken down when the whole end date passes.
+func bannerEndTime(usageLog entity.BannerUsageLog) time.Time {
+	return time.UnixMilli(usageLog.EndDate).Add(24 * time.Hour)
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/internal/repository"
+	"dantaautotool/pkg"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingDantaService counts the banners added, and blocks adding the banners in block until they are closed.
+// It also counts the usage logs, which fail while usageLogErr is set, and the banners removed.
+type blockingDantaService struct {
+	DantaServiceIntf
+
+	mu          sync.Mutex
+	updates     map[string]int
+	started     chan string
+	block       map[string]chan struct{}
+	usageLogs   map[string]int
+	usageLogErr error
+	removals    map[string]int
+}
+
+func (s *blockingDantaService) UpdateBanner(ctx context.Context, recordID string, newBanner entity.Banner, approver string) (*entity.BannerConfigChange, error) {
+	s.mu.Lock()
+	s.updates[recordID]++
+	s.mu.Unlock()
+	s.started <- recordID
+	if block, ok := s.block[recordID]; ok {
+		<-block
+	}
+	return &entity.BannerConfigChange{CommitSHA: recordID}, nil
+}
+
+func (s *blockingDantaService) RemoveBanner(ctx context.Context, recordID string, bannerTitle string) (*entity.BannerConfigChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.removals == nil {
+		s.removals = make(map[string]int)
+	}
+	s.removals[recordID]++
+	return &entity.BannerConfigChange{CommitSHA: recordID}, nil
+}
+
+func (s *blockingDantaService) removalCount(recordID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removals[recordID]
+}
+
+func (s *blockingDantaService) LogBannerUsage(ctx context.Context, recordID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usageLogErr != nil {
+		return s.usageLogErr
+	}
+	if s.usageLogs == nil {
+		s.usageLogs = make(map[string]int)
+	}
+	s.usageLogs[recordID]++
+	return nil
+}
+
+func (s *blockingDantaService) usageLogCount(recordID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usageLogs[recordID]
+}
+
+func (s *blockingDantaService) updateCount(recordID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updates[recordID]
+}
+
+func TestBannerSchedulerPublishesWithoutBlockingOthers(t *testing.T) {
+	db, err := repository.OpenBoltDB(filepath.Join(t.TempDir(), "danta.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltDB() error = %v", err)
+	}
+	defer db.Close()
+	bannerApplicationRepository, err := repository.NewBannerApplicationRepository(db)
+	if err != nil {
+		t.Fatalf("NewBannerApplicationRepository() error = %v", err)
+	}
+	original := config.Config
+	t.Cleanup(func() {
+		config.Config = original
+	})
+	config.Config.BannerSchedulerIntervalSeconds = 60
+	slow := make(chan struct{})
+	dantaService := &blockingDantaService{
+		updates: make(map[string]int),
+		started: make(chan string, 4),
+		block:   map[string]chan struct{}{"slow": slow},
+	}
+	scheduler := NewBannerScheduler(dantaService, bannerApplicationRepository)
+	for recordID, title := range map[string]string{"slow": "Slow", "fast": "Fast"} {
+		_, err := bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+			state.BannerApplication = entity.BannerApplication{Banner: entity.Banner{Title: title}}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+
+	// publishing a banner is stuck in the call to Github
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := scheduler.Schedule(context.Background(), "slow", "Alice")
+		slowDone <- err
+	}()
+	if recordID := <-dantaService.started; recordID != "slow" {
+		t.Fatalf("started publishing %s, want slow", recordID)
+	}
+
+	// other banners are published in the meantime
+	fastDone := make(chan error, 1)
+	go func() {
+		change, err := scheduler.Schedule(context.Background(), "fast", "Alice")
+		if err == nil && change == nil {
+			err = context.Canceled
+		}
+		fastDone <- err
+	}()
+	select {
+	case err := <-fastDone:
+		if err != nil {
+			t.Fatalf("Schedule() of another banner error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Schedule() of another banner is blocked by a slow one")
+	}
+	<-dantaService.started
+
+	// the periodic check skips the banner being published
+	scheduler.check()
+	close(slow)
+	if err := <-slowDone; err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	scheduler.check()
+	for _, recordID := range []string{"slow", "fast"} {
+		if count := dantaService.updateCount(recordID); count != 1 {
+			t.Errorf("banner %s added %d times, want once", recordID, count)
+		}
+		state, err := bannerApplicationRepository.Get(recordID)
+		if err != nil || !state.Published || state.Status != pkg.BANNER_STATUS_APPROVED || state.Approver != "Alice" {
+			t.Errorf("state of %s = %+v, %v, want published", recordID, state, err)
+		}
+	}
+}
+
+func TestBannerSchedulerScheduleUnknownApplication(t *testing.T) {
+	db, err := repository.OpenBoltDB(filepath.Join(t.TempDir(), "danta.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltDB() error = %v", err)
+	}
+	defer db.Close()
+	bannerApplicationRepository, err := repository.NewBannerApplicationRepository(db)
+	if err != nil {
+		t.Fatalf("NewBannerApplicationRepository() error = %v", err)
+	}
+	dantaService := &blockingDantaService{updates: make(map[string]int), started: make(chan string, 1)}
+	scheduler := NewBannerScheduler(dantaService, bannerApplicationRepository)
+
+	// an application never saved, e.g. from a forged card, is not approved
+	_, err = scheduler.Schedule(context.Background(), "forged", "Alice")
+	if !errors.Is(err, ErrBannerApplicationNotFound) {
+		t.Errorf("Schedule() of an unknown application error = %v, want %v", err, ErrBannerApplicationNotFound)
+	}
+	if state, err := bannerApplicationRepository.Get("forged"); err != nil || state != nil {
+		t.Errorf("state of an unknown application = %+v, %v, want nothing saved", state, err)
+	}
+	if count := dantaService.updateCount("forged"); count != 0 {
+		t.Errorf("unknown banner added %d times, want never", count)
+	}
+}
+
+func TestBannerSchedulerRetriesFailedUsageLog(t *testing.T) {
+	db, err := repository.OpenBoltDB(filepath.Join(t.TempDir(), "danta.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltDB() error = %v", err)
+	}
+	defer db.Close()
+	bannerApplicationRepository, err := repository.NewBannerApplicationRepository(db)
+	if err != nil {
+		t.Fatalf("NewBannerApplicationRepository() error = %v", err)
+	}
+	_, err = bannerApplicationRepository.Update("rec1", func(state *entity.BannerApplicationState) error {
+		state.BannerApplication = entity.BannerApplication{Banner: entity.Banner{Title: "Welcome"}}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	dantaService := &blockingDantaService{
+		updates:     make(map[string]int),
+		started:     make(chan string, 4),
+		usageLogErr: errors.New("bitable unavailable"),
+	}
+	scheduler := NewBannerScheduler(dantaService, bannerApplicationRepository)
+
+	// a failed usage log does not fail the approval of a published banner
+	change, err := scheduler.Schedule(context.Background(), "rec1", "Alice")
+	if err != nil || change == nil {
+		t.Fatalf("Schedule() = %+v, %v, want the banner published", change, err)
+	}
+	if count := dantaService.usageLogCount("rec1"); count != 0 {
+		t.Fatalf("usage logged %d times, want the usage log failed", count)
+	}
+
+	// the usage log is retried by the periodic check, and the banner is not published again
+	dantaService.mu.Lock()
+	dantaService.usageLogErr = nil
+	dantaService.mu.Unlock()
+	scheduler.check()
+	if count := dantaService.usageLogCount("rec1"); count != 1 {
+		t.Errorf("usage logged %d times after the check, want once", count)
+	}
+	if count := dantaService.updateCount("rec1"); count != 1 {
+		t.Errorf("banner added %d times, want once", count)
+	}
+}
+
+func TestBannerSchedulerTakesDownBannerPublishedWhileWithdrawn(t *testing.T) {
+	db, err := repository.OpenBoltDB(filepath.Join(t.TempDir(), "danta.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltDB() error = %v", err)
+	}
+	defer db.Close()
+	bannerApplicationRepository, err := repository.NewBannerApplicationRepository(db)
+	if err != nil {
+		t.Fatalf("NewBannerApplicationRepository() error = %v", err)
+	}
+	_, err = bannerApplicationRepository.Update("rec1", func(state *entity.BannerApplicationState) error {
+		state.BannerApplication = entity.BannerApplication{Banner: entity.Banner{Title: "Welcome"}}
+		state.UsageLoggedAt = 1
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	block := make(chan struct{})
+	dantaService := &blockingDantaService{
+		updates: make(map[string]int),
+		started: make(chan string, 1),
+		block:   map[string]chan struct{}{"rec1": block},
+	}
+	scheduler := NewBannerScheduler(dantaService, bannerApplicationRepository)
+
+	// the application is withdrawn while its banner is being published
+	done := make(chan error)
+	go func() {
+		_, err := scheduler.Schedule(context.Background(), "rec1", "Alice")
+		done <- err
+	}()
+	<-dantaService.started
+	_, err = bannerApplicationRepository.Update("rec1", func(state *entity.BannerApplicationState) error {
+		state.Status = pkg.BANNER_STATUS_WITHDRAWN
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	state, err := bannerApplicationRepository.Get("rec1")
+	if err != nil || state.Status != pkg.BANNER_STATUS_WITHDRAWN || !state.Published {
+		t.Fatalf("state after publishing = %+v, %v, want withdrawn and published", state, err)
+	}
+
+	// the next check takes the banner down, and only once
+	scheduler.check()
+	scheduler.check()
+	if count := dantaService.removalCount("rec1"); count != 1 {
+		t.Errorf("banner removed %d times, want once", count)
+	}
+	if state, err := bannerApplicationRepository.Get("rec1"); err != nil || state.Status != pkg.BANNER_STATUS_WITHDRAWN || state.Published {
+		t.Errorf("state after the check = %+v, %v, want withdrawn and taken down", state, err)
+	}
+}
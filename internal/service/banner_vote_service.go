@@ -0,0 +1,177 @@
+package service
+
+import (
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/internal/repository"
+	"dantaautotool/pkg"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrDuplicateVote is returned when an operator votes on an application more than once.
+	ErrDuplicateVote = errors.New("duplicate vote")
+
+	// ErrVotingClosed is returned when an operator votes on an application which has been approved or disapproved.
+	ErrVotingClosed = errors.New("voting closed")
+
+	// ErrBannerPublished is returned when retracting a vote on an application whose banner has been published.
+	ErrBannerPublished = errors.New("banner published")
+)
+
+// BannerVoteServiceIntf defines the interface for BannerVoteService.
+type BannerVoteServiceIntf interface {
+	// Tally returns the current voting result of an application.
+	Tally(applicationID string) (*entity.BannerVoteTally, error)
+
+	// Vote records the vote of an operator on an application, and returns the voting result after it.
+	// reason is the reason of a disapproval, and ignored for an approval.
+	// The application is approved (or disapproved) once the number of approvals (or disapprovals) reaches the quorum.
+	// It returns ErrDuplicateVote if the operator has voted, and ErrVotingClosed if the application has been decided.
+	Vote(applicationID, voterID string, approve bool, reason string) (*entity.BannerVoteTally, error)
+
+	// Retract removes the vote of an operator on an application, and reopens the voting if it is closed.
+	// It is used to roll back a vote when the action it triggers fails, so that the operator can vote again.
+	// It returns ErrBannerPublished if the banner of the application has been published, and the vote is kept.
+	Retract(applicationID, voterID string) error
+}
+
+// BannerVoteService counts the votes on banner applications.
+// Votes are kept in the application states of the repository, keyed by the record ID of the application.
+type BannerVoteService struct {
+	// bannerApplicationRepository is used to persist the votes
+	bannerApplicationRepository repository.BannerApplicationRepositoryIntf
+
+	// approveQuorum and disapproveQuorum are the numbers of votes needed to decide an application
+	approveQuorum    int
+	disapproveQuorum int
+}
+
+// NewBannerVoteService creates a new instance of BannerVoteService.
+func NewBannerVoteService(bannerApplicationRepository repository.BannerApplicationRepositoryIntf) *BannerVoteService {
+	approveQuorum := config.Config.LarkBannerApproveQuorum
+	if approveQuorum <= 0 {
+		log.Warn().Msgf("[NewBannerVoteService] Invalid approve quorum: %d, fallback to 1", approveQuorum)
+		approveQuorum = 1
+	}
+	disapproveQuorum := config.Config.LarkBannerDisapproveQuorum
+	if disapproveQuorum <= 0 {
+		log.Warn().Msgf("[NewBannerVoteService] Invalid disapprove quorum: %d, fallback to 1", disapproveQuorum)
+		disapproveQuorum = 1
+	}
+	return &BannerVoteService{
+		bannerApplicationRepository: bannerApplicationRepository,
+		approveQuorum:               approveQuorum,
+		disapproveQuorum:            disapproveQuorum,
+	}
+}
+
+// Tally returns the current voting result of an application.
+func (s *BannerVoteService) Tally(applicationID string) (*entity.BannerVoteTally, error) {
+	state, err := s.bannerApplicationRepository.Get(applicationID)
+	if err != nil {
+		log.Err(err).Msgf("[BannerVoteService.Tally] Failed to get application, application: %s", applicationID)
+		return nil, err
+	}
+	if state == nil {
+		state = &entity.BannerApplicationState{Status: pkg.BANNER_STATUS_PENDING}
+	}
+	return s.tallyOf(state), nil
+}
+
+// Vote records the vote of an operator on an application, and returns the voting result after it.
+// reason is the reason of a disapproval, and ignored for an approval.
+// The application is approved (or disapproved) once the number of approvals (or disapprovals) reaches the quorum.
+// It returns ErrDuplicateVote if the operator has voted, and ErrVotingClosed if the application has been decided.
+func (s *BannerVoteService) Vote(applicationID, voterID string, approve bool, reason string) (*entity.BannerVoteTally, error) {
+	state, err := s.bannerApplicationRepository.Update(applicationID, func(state *entity.BannerApplicationState) error {
+		if state.Status != pkg.BANNER_STATUS_PENDING {
+			return ErrVotingClosed
+		}
+		if slices.Contains(state.Approvers, voterID) || slices.Contains(state.Disapprovers, voterID) {
+			return ErrDuplicateVote
+		}
+		if approve {
+			state.Approvers = append(state.Approvers, voterID)
+		} else {
+			state.Disapprovers = append(state.Disapprovers, voterID)
+			if state.DisapproveReasons == nil {
+				state.DisapproveReasons = make(map[string]string)
+			}
+			state.DisapproveReasons[voterID] = reason
+		}
+		s.updateStatus(state)
+		return nil
+	})
+	if errors.Is(err, ErrVotingClosed) || errors.Is(err, ErrDuplicateVote) {
+		return s.tallyOf(state), err
+	}
+	if err != nil {
+		log.Err(err).Msgf("[BannerVoteService.Vote] Failed to record vote, application: %s, voter: %s", applicationID, voterID)
+		return nil, err
+	}
+	log.Info().Msgf("[BannerVoteService.Vote] Vote recorded, application: %s, voter: %s, approve: %t, status: %s", applicationID, voterID, approve, state.Status)
+	return s.tallyOf(state), nil
+}
+
+// Retract removes the vote of an operator on an application, and reopens the voting if it is closed.
+// It is used to roll back a vote when the action it triggers fails, so that the operator can vote again.
+// It returns ErrBannerPublished if the banner of the application has been published, and the vote is kept:
+// the published banner must stay approved, or it would never be taken down on its end date.
+func (s *BannerVoteService) Retract(applicationID, voterID string) error {
+	_, err := s.bannerApplicationRepository.Update(applicationID, func(state *entity.BannerApplicationState) error {
+		if state.PublishedAt != 0 {
+			return ErrBannerPublished
+		}
+		state.Approvers = slices.DeleteFunc(state.Approvers, func(id string) bool { return id == voterID })
+		state.Disapprovers = slices.DeleteFunc(state.Disapprovers, func(id string) bool { return id == voterID })
+		delete(state.DisapproveReasons, voterID)
+		s.updateStatus(state)
+		return nil
+	})
+	if errors.Is(err, ErrBannerPublished) {
+		log.Warn().Msgf("[BannerVoteService.Retract] Banner has been published, keep the vote, application: %s, voter: %s", applicationID, voterID)
+		return err
+	}
+	if err != nil {
+		log.Err(err).Msgf("[BannerVoteService.Retract] Failed to retract vote, application: %s, voter: %s", applicationID, voterID)
+		return err
+	}
+	log.Info().Msgf("[BannerVoteService.Retract] Vote retracted, application: %s, voter: %s", applicationID, voterID)
+	return nil
+}
+
+// updateStatus decides the status of an application by its votes.
+func (s *BannerVoteService) updateStatus(state *entity.BannerApplicationState) {
+	switch {
+	case len(state.Approvers) >= s.approveQuorum:
+		state.Status = pkg.BANNER_STATUS_APPROVED
+		state.DecidedAt = time.Now().UnixMilli()
+	case len(state.Disapprovers) >= s.disapproveQuorum:
+		state.Status = pkg.BANNER_STATUS_DISAPPROVED
+		state.DecidedAt = time.Now().UnixMilli()
+	default:
+		state.Status = pkg.BANNER_STATUS_PENDING
+		state.DecidedAt = 0
+	}
+}
+
+// tallyOf builds the voting result of an application from its state.
+func (s *BannerVoteService) tallyOf(state *entity.BannerApplicationState) *entity.BannerVoteTally {
+	disapproveReasons := make([]string, 0, len(state.Disapprovers))
+	for _, disapprover := range state.Disapprovers {
+		disapproveReasons = append(disapproveReasons, state.DisapproveReasons[disapprover])
+	}
+	return &entity.BannerVoteTally{
+		Approvers:         slices.Clone(state.Approvers),
+		Disapprovers:      slices.Clone(state.Disapprovers),
+		DisapproveReasons: disapproveReasons,
+		ApproveQuorum:     s.approveQuorum,
+		DisapproveQuorum:  s.disapproveQuorum,
+		Status:            state.Status,
+	}
+}
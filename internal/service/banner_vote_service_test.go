@@ -0,0 +1,60 @@
+package service
+
+import (
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/internal/repository"
+	"dantaautotool/pkg"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestBannerVoteServiceRetract(t *testing.T) {
+	db, err := repository.OpenBoltDB(filepath.Join(t.TempDir(), "danta.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltDB() error = %v", err)
+	}
+	defer db.Close()
+	bannerApplicationRepository, err := repository.NewBannerApplicationRepository(db)
+	if err != nil {
+		t.Fatalf("NewBannerApplicationRepository() error = %v", err)
+	}
+	original := config.Config
+	t.Cleanup(func() {
+		config.Config = original
+	})
+	config.Config.LarkBannerApproveQuorum = 1
+	config.Config.LarkBannerDisapproveQuorum = 1
+	voteService := NewBannerVoteService(bannerApplicationRepository)
+
+	// a vote whose action failed is retracted, and the voting is reopened
+	tally, err := voteService.Vote("rec1", "ou_1", true, "")
+	if err != nil || tally.Status != pkg.BANNER_STATUS_APPROVED {
+		t.Fatalf("Vote() = %+v, %v, want approved", tally, err)
+	}
+	if err := voteService.Retract("rec1", "ou_1"); err != nil {
+		t.Fatalf("Retract() error = %v", err)
+	}
+	if tally, err := voteService.Tally("rec1"); err != nil || tally.Status != pkg.BANNER_STATUS_PENDING || len(tally.Approvers) != 0 {
+		t.Errorf("Tally() after Retract() = %+v, %v, want pending without votes", tally, err)
+	}
+
+	// a vote whose banner has been published is kept, even after the banner is taken down
+	if _, err := voteService.Vote("rec1", "ou_1", true, ""); err != nil {
+		t.Fatalf("Vote() error = %v", err)
+	}
+	_, err = bannerApplicationRepository.Update("rec1", func(state *entity.BannerApplicationState) error {
+		state.PublishedAt = 1
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := voteService.Retract("rec1", "ou_1"); !errors.Is(err, ErrBannerPublished) {
+		t.Errorf("Retract() of a published banner error = %v, want %v", err, ErrBannerPublished)
+	}
+	if tally, err := voteService.Tally("rec1"); err != nil || tally.Status != pkg.BANNER_STATUS_APPROVED || len(tally.Approvers) != 1 {
+		t.Errorf("Tally() after Retract() of a published banner = %+v, %v, want approved", tally, err)
+	}
+}
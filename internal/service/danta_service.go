@@ -1,29 +1,66 @@
 package service
 
 import (
+	"context"
 	"dantaautotool/config"
 	"dantaautotool/internal/entity"
+	"dantaautotool/internal/repository"
+	"dantaautotool/pkg"
+	"dantaautotool/pkg/utils/tomledit"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
-	"github.com/pelletier/go-toml/v2"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
 	"github.com/rs/zerolog/log"
 
 	larkbitable "github.com/larksuite/oapi-sdk-go/v3/service/bitable/v1"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 )
 
+// bannersTableName is the name of the array of tables holding banners in the config file, i.e. [[banners]]
+const bannersTableName = "banners"
+
+// ErrBannerDisapproved is returned when withdrawing an application which has been disapproved.
+var ErrBannerDisapproved = errors.New("banner application disapproved")
+
 // DantaServiceIntf defines the interface for DantaService.
 type DantaServiceIntf interface {
 	// UpdateBannerAndNotify updates the banner and notifies the applicants.
-	UpdateBannerAndNotify(newBanner entity.Banner, toEmailList []string) error
+	UpdateBannerAndNotify(ctx context.Context, recordID string, usageLog entity.BannerUsageLog, toEmailList []string) error
 
 	// UpdateBanner edits banner config file (in Github repo)
-	UpdateBanner(newBanner entity.Banner) error
+	// recordID is the record ID of the application, which names the branch of the change in review mode.
+	// It returns the committed change, or nil if the banner already exists.
+	UpdateBanner(ctx context.Context, recordID string, newBanner entity.Banner, approver string) (*entity.BannerConfigChange, error)
+
+	// RemoveBanner removes the banner with the given title from banner config file (in Github repo)
+	// recordID is the record ID of the application, which names the branch of the change in review mode.
+	// It returns the committed change, or nil if the banner does not exist.
+	RemoveBanner(ctx context.Context, recordID string, bannerTitle string) (*entity.BannerConfigChange, error)
+
+	// DisapproveBanner records the disapproval of an application with the reasons in the application table, and notifies the applicant.
+	DisapproveBanner(ctx context.Context, recordID string, application entity.BannerApplication, reasons []string) error
+
+	// WithdrawBanner marks an application as withdrawn, takes down its banner if it is published, records it in its usage record,
+	// and notifies the applicant.
+	// Withdrawing an application which has been withdrawn does nothing,
+	// and withdrawing an application which has been disapproved returns ErrBannerDisapproved.
+	WithdrawBanner(ctx context.Context, recordID string, application entity.BannerApplication) error
+
+	// LogBannerUsage adds the record of an approved application to the usage table, once for each application.
+	LogBannerUsage(ctx context.Context, recordID string) error
 
-	// NotifyBannerUpdate send email to applicants when banner is updated
-	NotifyBannerUpdate(newBanner entity.Banner, toEmailList []string) error
+	// NotifyBannerUpdate send email to applicants when banner is approved.
+	// change is the committed change of the banner config file, or nil if the banner is not published yet.
+	NotifyBannerUpdate(ctx context.Context, usageLog entity.BannerUsageLog, change *entity.BannerConfigChange, toEmailList []string) error
 
 	// ConvertBitableRecord2BannerApplication converts a BitableRecord to a Banner.
 	ConvertBitableRecord2BannerApplication(record *larkbitable.AppTableRecord) *entity.BannerApplication
+
+	// IsBitableRecordWithdrawn checks whether the status of a banner application BitableRecord is set to withdrawn.
+	IsBitableRecordWithdrawn(record *larkbitable.AppTableRecord) bool
 }
 
 // DantaService provides methods to handle business logic related to Danta.
@@ -31,38 +68,53 @@ type DantaService struct {
 	// larkDocService is used to interact with Lark documents
 	larkDocService LarkDocServiceIntf
 
-	// larkEmailService is used to interact with Lark emails
-	larkEmailService LarkEmailServiceIntf
-
 	// githubService is used to interact with Github
 	githubService GithubServiceIntf
+
+	// larkIMService is used to interact with Lark IM
+	larkIMService LarkIMServiceIntf
+
+	// bannerApplicationRepository is used to persist the states of banner applications
+	bannerApplicationRepository repository.BannerApplicationRepositoryIntf
+
+	// emailTemplateService is used to render notification emails
+	emailTemplateService EmailTemplateServiceIntf
+
+	// notificationOutbox is used to deliver notification emails in background
+	notificationOutbox NotificationOutboxIntf
 }
 
 // NewDantaService creates a new instance of DantaService.
 // It takes a LarkDocServiceIntf as a parameter and returns a pointer to DantaService.
 func NewDantaService(
 	larkDocService LarkDocServiceIntf,
-	larkEmailService LarkEmailServiceIntf,
 	githubService GithubServiceIntf,
+	larkIMService LarkIMServiceIntf,
+	bannerApplicationRepository repository.BannerApplicationRepositoryIntf,
+	emailTemplateService EmailTemplateServiceIntf,
+	notificationOutbox NotificationOutboxIntf,
 ) *DantaService {
 	return &DantaService{
-		larkDocService:   larkDocService,
-		larkEmailService: larkEmailService,
-		githubService:    githubService,
+		larkDocService:              larkDocService,
+		githubService:               githubService,
+		larkIMService:               larkIMService,
+		bannerApplicationRepository: bannerApplicationRepository,
+		emailTemplateService:        emailTemplateService,
+		notificationOutbox:          notificationOutbox,
 	}
 }
 
 // UpdateBannerAndNotify do the following things:
 //  1. Edit banner config file (in Github repo)
 //  2. Send email to applicants
-func (s *DantaService) UpdateBannerAndNotify(newBanner entity.Banner, toEmailList []string) error {
-	err := s.UpdateBanner(newBanner)
+func (s *DantaService) UpdateBannerAndNotify(ctx context.Context, recordID string, usageLog entity.BannerUsageLog, toEmailList []string) error {
+	change, err := s.UpdateBanner(ctx, recordID, usageLog.Banner, usageLog.Approver)
 	if err != nil {
 		log.Err(err).Msg("[DantaService.UpdateBannerAndNotify] Failed to update banner")
 		return err
 	}
 
-	err = s.NotifyBannerUpdate(newBanner, toEmailList)
+	err = s.NotifyBannerUpdate(ctx, usageLog, change, toEmailList)
 	if err != nil {
 		log.Err(err).Msg("[DantaService.UpdateBannerAndNotify] Failed to notify applicants")
 		return err
@@ -72,33 +124,325 @@ func (s *DantaService) UpdateBannerAndNotify(newBanner entity.Banner, toEmailLis
 }
 
 // UpdateBanner edits banner config file (in Github repo)
-func (s *DantaService) UpdateBanner(newBanner entity.Banner) error {
-	log.Info().Msgf("[DantaService.UpdateBanner] Start updating banner and notifying applicants, newBanner: %+v", newBanner)
+// recordID is the record ID of the application, which names the branch of the change in review mode.
+// It returns the committed change, or nil if the banner already exists.
+func (s *DantaService) UpdateBanner(ctx context.Context, recordID string, newBanner entity.Banner, approver string) (*entity.BannerConfigChange, error) {
+	log.Info().Msgf("[DantaService.UpdateBanner] Start updating banner and notifying applicants, record ID: %s, newBanner: %+v", recordID, newBanner)
 
+	return s.modifyBannerConfig(
+		ctx,
+		fmt.Sprintf("danta-auto-tool/add-banner-%s", recordID),
+		fmt.Sprintf("Add banner \"%s\", approved by %s", newBanner.Title, approver),
+		func(configDocument *tomledit.Document) (bool, error) {
+			banners, err := configDocument.ArrayTables(bannersTableName)
+			if err != nil {
+				return false, err
+			}
+			// check if the new banner already exists
+			// if it does, return without updating
+			for _, banner := range banners {
+				if banner["title"] == newBanner.Title {
+					log.Warn().Msgf("[DantaService.UpdateBanner] The new banner already exists, banner title: %s", newBanner.Title)
+					return false, nil
+				}
+			}
+
+			// else append the new banner
+			err = configDocument.AppendArrayTable(bannersTableName, newBanner)
+			if err != nil {
+				return false, err
+			}
+			return true, nil
+		},
+	)
+}
+
+// RemoveBanner removes the banner with the given title from banner config file (in Github repo)
+// recordID is the record ID of the application, which names the branch of the change in review mode.
+// It returns the committed change, or nil if the banner does not exist.
+func (s *DantaService) RemoveBanner(ctx context.Context, recordID string, bannerTitle string) (*entity.BannerConfigChange, error) {
+	log.Info().Msgf("[DantaService.RemoveBanner] Start removing banner, record ID: %s, title: %s", recordID, bannerTitle)
+
+	return s.modifyBannerConfig(
+		ctx,
+		fmt.Sprintf("danta-auto-tool/remove-banner-%s", recordID),
+		fmt.Sprintf("Remove banner \"%s\"", bannerTitle),
+		func(configDocument *tomledit.Document) (bool, error) {
+			removed, err := configDocument.RemoveArrayTables(bannersTableName, func(banner map[string]any) bool {
+				return banner["title"] == bannerTitle
+			})
+			if err != nil {
+				return false, err
+			}
+			// if the banner does not exist, return without updating
+			if removed == 0 {
+				log.Warn().Msgf("[DantaService.RemoveBanner] The banner does not exist, banner title: %s", bannerTitle)
+				return false, nil
+			}
+			return true, nil
+		},
+	)
+}
+
+// WithdrawBanner does the following things:
+//  1. Mark the application as withdrawn, so that its banner will not be published on schedule
+//  2. Remove the banner from banner config file (in Github repo), if the banner of this application is published
+//  3. Set the end date of its record in the usage table to today, if it has been logged
+//  4. Send email to the applicant
+//
+// The withdrawal is triggered by both the withdraw button and the status field in the application table,
+// and editing a withdrawn record triggers it again, so it returns at once if the application has been withdrawn.
+// An application whose withdrawal failed halfway is marked as withdrawn but not finished, and is withdrawn again.
+// The saved application is used if there is one, and application is only used for an application not saved yet.
+// It returns ErrBannerDisapproved if the application has been disapproved, which has nothing to withdraw.
+func (s *DantaService) WithdrawBanner(ctx context.Context, recordID string, application entity.BannerApplication) error {
+	log.Info().Msgf("[DantaService.WithdrawBanner] Start withdrawing banner, record ID: %s, application: %+v", recordID, application)
+
+	withdrawn := false
+	state, err := s.bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+		if state.Status == pkg.BANNER_STATUS_WITHDRAWN && state.WithdrawnAt != 0 {
+			withdrawn = true
+			return nil
+		}
+		if state.Status == pkg.BANNER_STATUS_DISAPPROVED {
+			return ErrBannerDisapproved
+		}
+		if state.BannerApplication.Title == "" {
+			state.BannerApplication = application
+		}
+		state.Status = pkg.BANNER_STATUS_WITHDRAWN
+		return nil
+	})
+	if errors.Is(err, ErrBannerDisapproved) {
+		log.Info().Msgf("[DantaService.WithdrawBanner] Application has been disapproved, skip it, record ID: %s", recordID)
+		return err
+	}
+	if err != nil {
+		log.Err(err).Msg("[DantaService.WithdrawBanner] Failed to mark application as withdrawn")
+		return err
+	}
+	if withdrawn {
+		log.Info().Msgf("[DantaService.WithdrawBanner] Application has been withdrawn, skip it, record ID: %s", recordID)
+		return nil
+	}
+	application = state.BannerApplication
+
+	// Another application may have a banner with the same title, so only the banner of this application is removed.
+	// A banner being published meanwhile is taken down by the scheduler, since the application is withdrawn.
+	if state.Published {
+		_, err = s.RemoveBanner(ctx, recordID, application.Title)
+		if err != nil {
+			log.Err(err).Msg("[DantaService.WithdrawBanner] Failed to remove banner")
+			return err
+		}
+		_, err = s.bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+			state.Published = false
+			return nil
+		})
+		if err != nil {
+			log.Err(err).Msg("[DantaService.WithdrawBanner] Failed to mark banner as taken down")
+			return err
+		}
+	} else {
+		log.Info().Msgf("[DantaService.WithdrawBanner] Banner is not published, skip removing it, record ID: %s", recordID)
+	}
+
+	// update usage table
+	if state.UsageRecordID != "" {
+		bannerAnalysisDocToken := config.Config.LarkBannerBitableAppToken
+		bannerUsageLogTableID := config.Config.LarkBannerBitableUsageTableID
+		if bannerAnalysisDocToken == "" || bannerUsageLogTableID == "" {
+			log.Error().Msg("[DantaService.WithdrawBanner] LARK_BANNER_BITABLE_APP_TOKEN or LARK_BANNER_BITABLE_USAGE_TABLE_ID is empty")
+			return fmt.Errorf("LARK_BANNER_BITABLE_APP_TOKEN or LARK_BANNER_BITABLE_USAGE_TABLE_ID is empty")
+		}
+		err = s.larkDocService.UpdateBitableRecord(
+			ctx,
+			bannerAnalysisDocToken,
+			bannerUsageLogTableID,
+			state.UsageRecordID,
+			map[string]interface{}{
+				"截止日期": time.Now().UnixMilli(),
+			},
+		)
+		if err != nil {
+			log.Err(err).Msg("[DantaService.WithdrawBanner] Failed to update usage record")
+			return err
+		}
+	} else {
+		log.Warn().Msgf("[DantaService.WithdrawBanner] Application has not been logged to the usage table, skip updating it, record ID: %s", recordID)
+	}
+
+	// notify applicant
+	err = s.sendBannerEmail(
+		pkg.EMAIL_TEMPLATE_BANNER_WITHDRAWN,
+		newBannerEmailData(application, "", nil, nil),
+		[]string{application.ApplicantEmail},
+	)
+	if err != nil {
+		log.Err(err).Msg("[DantaService.WithdrawBanner] Failed to notify applicant")
+		return err
+	}
+
+	_, err = s.bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+		state.WithdrawnAt = time.Now().UnixMilli()
+		return nil
+	})
+	if err != nil {
+		// everything is done, failing here would only withdraw it again on the next trigger
+		log.Err(err).Msg("[DantaService.WithdrawBanner] Failed to mark withdrawal as finished")
+	}
+	return nil
+}
+
+// DisapproveBanner does the following things:
+//  1. Set the status of the application record to disapproved, and write the reasons to it
+//  2. Send email to the applicant explaining the decision
+func (s *DantaService) DisapproveBanner(ctx context.Context, recordID string, application entity.BannerApplication, reasons []string) error {
+	log.Info().Msgf("[DantaService.DisapproveBanner] Start disapproving banner, record ID: %s, application: %+v", recordID, application)
+
+	reasonText := strings.Join(reasons, "\n")
+
+	// update application table
+	bannerAnalysisDocToken := config.Config.LarkBannerBitableAppToken
+	bannerApplicationTableID := config.Config.LarkBannerBitableApplicationTableID
+	if bannerAnalysisDocToken == "" || bannerApplicationTableID == "" {
+		log.Error().Msg("[DantaService.DisapproveBanner] LARK_BANNER_BITABLE_APP_TOKEN or LARK_BANNER_BITABLE_APPLICATION_TABLE_ID is empty")
+		return fmt.Errorf("LARK_BANNER_BITABLE_APP_TOKEN or LARK_BANNER_BITABLE_APPLICATION_TABLE_ID is empty")
+	}
+	err := s.larkDocService.UpdateBitableRecord(
+		ctx,
+		bannerAnalysisDocToken,
+		bannerApplicationTableID,
+		recordID,
+		map[string]interface{}{
+			pkg.LARK_BITABLE_FIELD_BANNER_STATUS:        pkg.LARK_BITABLE_FIELD_BANNER_STATUS_DISAPPROVED,
+			pkg.LARK_BITABLE_FIELD_BANNER_REJECT_REASON: reasonText,
+		},
+	)
+	if err != nil {
+		log.Err(err).Msg("[DantaService.DisapproveBanner] Failed to update application record")
+		return err
+	}
+
+	// notify applicant
+	err = s.sendBannerEmail(
+		pkg.EMAIL_TEMPLATE_BANNER_DISAPPROVED,
+		newBannerEmailData(application, "", nil, reasons),
+		[]string{application.ApplicantEmail},
+	)
+	if err != nil {
+		log.Err(err).Msg("[DantaService.DisapproveBanner] Failed to notify applicant")
+		return err
+	}
+
+	return nil
+}
+
+// modifyBannerConfig reads banner config file (in Github repo), applies mutate to it, and commits the result.
+// mutate edits the file in place, so that the parts it does not touch (e.g. comments) are kept as is.
+// It returns false if nothing needs to be changed, and no commit is made in this case.
+//
+// If the file is changed by others between reading and committing, Github rejects the commit with a conflict.
+// In this case the whole read-modify-write cycle is retried, with mutate applied to the latest file content,
+// and the approve group is notified if it still fails after the configured number of attempts.
+// It returns the committed change, or nil if nothing is changed.
+//
+// In review mode the change is committed to reviewBranch, which is named after the application,
+// so that reviewers can match the pull request to it.
+func (s *DantaService) modifyBannerConfig(ctx context.Context, reviewBranch, commitMessage string, mutate func(configDocument *tomledit.Document) (bool, error)) (*entity.BannerConfigChange, error) {
+	maxAttempts := config.Config.GithubConflictMaxAttempts
+	if maxAttempts <= 0 {
+		log.Warn().Msgf("[DantaService.modifyBannerConfig] Invalid max attempts: %d, fallback to 1", maxAttempts)
+		maxAttempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		var change *entity.BannerConfigChange
+		change, err = s.tryModifyBannerConfig(ctx, reviewBranch, commitMessage, mutate)
+		if err == nil {
+			return change, nil
+		}
+		if !IsGithubConflictError(err) {
+			return nil, err
+		}
+		log.Warn().Err(err).Msgf("[DantaService.modifyBannerConfig] Conflict detected, attempt %d/%d, commit message: %s", attempt, maxAttempts, commitMessage)
+		if attempt < maxAttempts {
+			// wait a little while for the other change to settle
+			select {
+			case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	log.Error().Err(err).Msgf("[DantaService.modifyBannerConfig] Still conflicting after %d attempts, give up, commit message: %s", maxAttempts, commitMessage)
+	notifyErr := s.larkIMService.SendTextMessage(
+		ctx,
+		larkim.ReceiveIdTypeChatId,
+		config.Config.LarkBannerApproveGroupID,
+		fmt.Sprintf("Banner 配置修改失败：配置文件在 %d 次尝试中均被其他修改抢先更新，请稍后重试。\n修改内容：%s", maxAttempts, commitMessage),
+	)
+	if notifyErr != nil {
+		log.Err(notifyErr).Msg("[DantaService.modifyBannerConfig] Failed to notify approve group")
+	}
+	return nil, fmt.Errorf("failed to modify banner config after %d attempts: %w", maxAttempts, err)
+}
+
+// tryModifyBannerConfig does one read-modify-write cycle of modifyBannerConfig.
+//
+// By default the change is committed to the configured branch directly.
+// In review mode, the change is committed to reviewBranch created from the configured branch,
+// and a pull request is opened against the configured branch.
+// If the attempt fails after reviewBranch is created, the branch is deleted, so that a retry starts over from a clean branch.
+// It returns the committed change, or nil if nothing is changed.
+func (s *DantaService) tryModifyBannerConfig(ctx context.Context, reviewBranch, commitMessage string, mutate func(configDocument *tomledit.Document) (bool, error)) (*entity.BannerConfigChange, error) {
 	bannerRepoOwner := config.Config.GithubDanxiRepoOwner
 	if bannerRepoOwner == "" {
-		log.Error().Msg("[DantaService.UpdateBanner] GITHUB_DANXI_REPO_OWNER is empty")
-		return fmt.Errorf("GITHUB_DANXI_REPO_OWNER is empty")
+		log.Error().Msg("[DantaService.tryModifyBannerConfig] GITHUB_DANXI_REPO_OWNER is empty")
+		return nil, fmt.Errorf("GITHUB_DANXI_REPO_OWNER is empty")
 	}
 	bannerRepoName := config.Config.GithubDanxiRepoName
 	if bannerRepoName == "" {
-		log.Error().Msg("[DantaService.UpdateBanner] GITHUB_DANXI_REPO_NAME is empty")
-		return fmt.Errorf("GITHUB_DANXI_REPO_NAME is empty")
+		log.Error().Msg("[DantaService.tryModifyBannerConfig] GITHUB_DANXI_REPO_NAME is empty")
+		return nil, fmt.Errorf("GITHUB_DANXI_REPO_NAME is empty")
 	}
 	bannerRepoAppConfigPath := config.Config.GithubDanxiRepoAppConfigPath
 	if bannerRepoAppConfigPath == "" {
-		log.Error().Msg("[DantaService.UpdateBanner] GITHUB_DANXI_REPO_APP_CONFIG_PATH is empty")
-		return fmt.Errorf("GITHUB_DANXI_REPO_APP_CONFIG_PATH is empty")
+		log.Error().Msg("[DantaService.tryModifyBannerConfig] GITHUB_DANXI_REPO_APP_CONFIG_PATH is empty")
+		return nil, fmt.Errorf("GITHUB_DANXI_REPO_APP_CONFIG_PATH is empty")
+	}
+	bannerRepoBranch := config.Config.GithubDanxiRepoBranch
+	if bannerRepoBranch == "" {
+		log.Error().Msg("[DantaService.tryModifyBannerConfig] GITHUB_DANXI_REPO_BRANCH is empty")
+		return nil, fmt.Errorf("GITHUB_DANXI_REPO_BRANCH is empty")
+	}
+	reviewMode := config.Config.GithubReviewMode
+
+	// In review mode, pin the commit of the base branch, so that the new branch is created from the same commit we read
+	fileRef := bannerRepoBranch
+	baseCommitSHA := ""
+	if reviewMode {
+		baseRef, err := s.githubService.GetRef(ctx, bannerRepoOwner, bannerRepoName, "heads/"+bannerRepoBranch)
+		if err != nil {
+			log.Err(err).Msg("[DantaService.tryModifyBannerConfig] Failed to get base branch")
+			return nil, err
+		}
+		baseCommitSHA = baseRef.Object.SHA
+		fileRef = baseCommitSHA
 	}
 
 	repoContent, err := s.githubService.GetFileContent(
+		ctx,
 		bannerRepoOwner,
 		bannerRepoName,
 		bannerRepoAppConfigPath,
+		fileRef,
 	)
 	if err != nil {
-		log.Err(err).Msg("[DantaService.UpdateBanner] Failed to get banner config file content")
-		return err
+		log.Err(err).Msg("[DantaService.tryModifyBannerConfig] Failed to get banner config file content")
+		return nil, err
 	}
 
 	// for the file structure, see:
@@ -107,76 +451,249 @@ func (s *DantaService) UpdateBanner(newBanner entity.Banner) error {
 	sha := repoContent.SHA
 
 	// parse and update the config content
-	dantaAppContentConfig := entity.DantaAppContentConfig{}
-	err = toml.Unmarshal([]byte(configContent), &dantaAppContentConfig)
+	configDocument, err := tomledit.Parse(configContent)
 	if err != nil {
-		log.Err(err).Msg("[DantaService.UpdateBanner] Failed to unmarshal config content")
-		return err
+		log.Err(err).Msg("[DantaService.tryModifyBannerConfig] Failed to parse config content")
+		return nil, err
 	}
 
-	// check if the new banner already exists
-	// if it does, return without updating
-	for _, banner := range dantaAppContentConfig.Banners {
-		if banner.Title == newBanner.Title {
-			log.Warn().Msgf("[DantaService.UpdateBanner] The new banner already exists, banner title: %s", newBanner.Title)
-			return nil
-		}
+	changed, err := mutate(configDocument)
+	if err != nil {
+		log.Err(err).Msg("[DantaService.tryModifyBannerConfig] Failed to edit config content")
+		return nil, err
+	}
+	if !changed {
+		return nil, nil
 	}
+	updatedConfigContent := configDocument.String()
 
-	// else append the new banner
-	dantaAppContentConfig.Banners = append(dantaAppContentConfig.Banners, newBanner)
-	updatedConfigContentBytes, err := toml.Marshal(dantaAppContentConfig)
-	if err != nil {
-		log.Err(err).Msg("[DantaService.UpdateBanner] Failed to marshal updated config content")
-		return err
+	// In review mode, create a new branch for the change
+	targetBranch := bannerRepoBranch
+	if reviewMode {
+		targetBranch = reviewBranch
+		err = s.createReviewBranch(ctx, bannerRepoOwner, bannerRepoName, targetBranch, baseCommitSHA)
+		if err != nil {
+			log.Err(err).Msgf("[DantaService.tryModifyBannerConfig] Failed to create branch: %s", targetBranch)
+			return nil, err
+		}
 	}
-	updatedConfigContent := string(updatedConfigContentBytes)
 
 	// update file in Github
-	err = s.githubService.CreateOrUpdateFileContent(
+	commitResp, err := s.githubService.CreateOrUpdateFileContent(
+		ctx,
 		bannerRepoOwner,
 		bannerRepoName,
 		bannerRepoAppConfigPath,
-		"commit message", // TODO: use a meaningful commit message @xunzhou24
+		commitMessage,
 		updatedConfigContent,
 		sha,
-		"main", // commit to default branch
-		&entity.Committer{
-			Name:  "Danta Auto Tool",
-			Email: "danta@example.com",
-		}, // TODO: use the real committer @xunzhou24
+		targetBranch,
+		s.githubService.Committer(),
+	)
+	if err != nil {
+		log.Err(err).Msg("[DantaService.tryModifyBannerConfig] Failed to update file content in Github")
+		if reviewMode {
+			s.deleteReviewBranch(ctx, bannerRepoOwner, bannerRepoName, targetBranch)
+		}
+		return nil, err
+	}
+
+	if !reviewMode {
+		return &entity.BannerConfigChange{
+			CommitSHA: commitResp.Commit.SHA,
+			Link:      commitResp.Commit.HTMLURL,
+		}, nil
+	}
+
+	// In review mode, open a pull request for the change
+	pullRequest, err := s.githubService.CreatePullRequest(
+		ctx,
+		bannerRepoOwner,
+		bannerRepoName,
+		commitMessage,
+		targetBranch,
+		bannerRepoBranch,
+		"This pull request is created by Danta Auto Tool.\n\n"+commitMessage,
 	)
+	var apiErr *GithubAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == consts.StatusUnprocessableEntity {
+		// The pull request may have been created by an earlier request whose response was lost,
+		// and deleting the branch would close it
+		existing := s.findReviewPullRequest(ctx, bannerRepoOwner, bannerRepoName, targetBranch, bannerRepoBranch)
+		if existing != nil {
+			log.Info().Msgf("[DantaService.tryModifyBannerConfig] Pull request already exists: %s", existing.HTMLURL)
+			pullRequest, err = existing, nil
+		}
+	}
 	if err != nil {
-		log.Err(err).Msg("[DantaService.UpdateBanner] Failed to update file content in Github")
+		log.Err(err).Msg("[DantaService.tryModifyBannerConfig] Failed to create pull request")
+		s.deleteReviewBranch(ctx, bannerRepoOwner, bannerRepoName, targetBranch)
+		return nil, err
+	}
+	log.Info().Msgf("[DantaService.tryModifyBannerConfig] Pull request created: %s", pullRequest.HTMLURL)
+	return &entity.BannerConfigChange{
+		CommitSHA: commitResp.Commit.SHA,
+		Link:      pullRequest.HTMLURL,
+	}, nil
+}
+
+// findReviewPullRequest returns the open pull request merging the branch of a change in review mode into base,
+// or nil if there is none or the lookup fails.
+func (s *DantaService) findReviewPullRequest(ctx context.Context, owner, repo, branch, base string) *entity.PullRequest {
+	pullRequests, err := s.githubService.ListOpenPullRequests(ctx, owner, repo, owner+":"+branch, base)
+	if err != nil {
+		log.Err(err).Msgf("[DantaService.findReviewPullRequest] Failed to list pull requests, branch: %s", branch)
+		return nil
+	}
+	if len(pullRequests) == 0 {
+		return nil
+	}
+	return pullRequests[0]
+}
+
+// createReviewBranch creates the branch of a change in review mode at the given commit.
+// A branch of the same name is left behind if an earlier attempt failed to clean it up, and is replaced.
+func (s *DantaService) createReviewBranch(ctx context.Context, owner, repo, branch, sha string) error {
+	_, err := s.githubService.CreateRef(ctx, owner, repo, "refs/heads/"+branch, sha)
+	var apiErr *GithubAPIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != consts.StatusUnprocessableEntity {
 		return err
 	}
 
+	log.Warn().Msgf("[DantaService.createReviewBranch] Branch already exists, recreate it, branch: %s", branch)
+	err = s.githubService.DeleteRef(ctx, owner, repo, "heads/"+branch)
+	if err != nil {
+		log.Err(err).Msgf("[DantaService.createReviewBranch] Failed to delete branch: %s", branch)
+		return err
+	}
+	_, err = s.githubService.CreateRef(ctx, owner, repo, "refs/heads/"+branch, sha)
+	return err
+}
+
+// deleteReviewBranch deletes the branch of a failed change in review mode.
+// A failure is only logged, since createReviewBranch replaces the branch on the next attempt anyway.
+func (s *DantaService) deleteReviewBranch(ctx context.Context, owner, repo, branch string) {
+	err := s.githubService.DeleteRef(ctx, owner, repo, "heads/"+branch)
+	if err != nil {
+		log.Err(err).Msgf("[DantaService.deleteReviewBranch] Failed to delete branch: %s", branch)
+	}
+}
+
+// LogBannerUsage adds the record of an approved application to the usage table, once for each application.
+// The application saved in the repository is logged, and marked as logged, so that it is never logged twice.
+func (s *DantaService) LogBannerUsage(ctx context.Context, recordID string) error {
+	state, err := s.bannerApplicationRepository.Get(recordID)
+	if err != nil {
+		log.Err(err).Msg("[DantaService.LogBannerUsage] Failed to get banner application")
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("banner application not found: %s", recordID)
+	}
+	if state.UsageLoggedAt != 0 {
+		log.Info().Msgf("[DantaService.LogBannerUsage] Usage log already added, record ID: %s", recordID)
+		return nil
+	}
+
+	bannerAnalysisDocToken := config.Config.LarkBannerBitableAppToken
+	bannerUsageLogTableID := config.Config.LarkBannerBitableUsageTableID
+	if bannerAnalysisDocToken == "" || bannerUsageLogTableID == "" {
+		log.Error().Msg("[DantaService.LogBannerUsage] LARK_BANNER_BITABLE_APP_TOKEN or LARK_BANNER_BITABLE_USAGE_TABLE_ID is empty")
+		return fmt.Errorf("LARK_BANNER_BITABLE_APP_TOKEN or LARK_BANNER_BITABLE_USAGE_TABLE_ID is empty")
+	}
+	usageLogFields := map[string]interface{}{
+		"Banner": state.Title,
+		"联系邮箱":   state.ApplicantEmail,
+		"action": state.Action,
+		"button": state.Button,
+	}
+	// unspecified dates are left empty
+	if state.StartDate != 0 {
+		usageLogFields["开始日期"] = state.StartDate
+	}
+	if state.EndDate != 0 {
+		usageLogFields["截止日期"] = state.EndDate
+	}
+	usageRecordID, err := s.larkDocService.AddBitableRecord(ctx, bannerAnalysisDocToken, bannerUsageLogTableID, usageLogFields)
+	if err != nil {
+		log.Err(err).Msg("[DantaService.LogBannerUsage] Failed to add bitable record")
+		return err
+	}
+	log.Info().Msgf("[DantaService.LogBannerUsage] Usage log added, record ID: %s, usage record ID: %s", recordID, usageRecordID)
+	_, err = s.bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+		state.UsageLoggedAt = time.Now().UnixMilli()
+		state.UsageRecordID = usageRecordID
+		return nil
+	})
+	if err != nil {
+		// the usage log has been added, failing here would add it again on retry
+		log.Err(err).Msg("[DantaService.LogBannerUsage] Failed to mark usage log as added")
+	}
 	return nil
 }
 
-// NotifyBannerUpdate send email to applicants when banner is updated
-func (s *DantaService) NotifyBannerUpdate(newBanner entity.Banner, toEmailList []string) error {
+// NotifyBannerUpdate send email to applicants when banner is approved.
+// change is the committed change of the banner config file, or nil if the banner is not published yet.
+func (s *DantaService) NotifyBannerUpdate(ctx context.Context, usageLog entity.BannerUsageLog, change *entity.BannerConfigChange, toEmailList []string) error {
+	err := s.sendBannerEmail(
+		pkg.EMAIL_TEMPLATE_BANNER_APPROVED,
+		newBannerEmailData(usageLog.BannerApplication, usageLog.Approver, change, nil),
+		toEmailList,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("[DantaService.NotifyBannerUpdate] Failed to send email")
+		return err
+	}
+	return nil
+}
 
-	for _, email := range toEmailList {
-		log.Info().Msgf("[DantaService.NotifyBannerUpdate] Sending email to: %s", email)
-		err := s.larkEmailService.SendEmailSimple(
-			config.Config.DantaDevEmail,
-			"您提交的置顶申请已经通过",
-			email,
-			"测试toname",
-			"旦挞的小菜鸡周迅",
-			"您提交的置顶申请已经通过",
-		)
-		if err != nil {
-			log.Error().Err(err).Msg("[DantaService.NotifyBannerUpdate] Failed to send email")
-			return err
-		}
-		log.Info().Msgf("[DantaService.NotifyBannerUpdate] Email sent to: %s", email)
+// sendBannerEmail renders the email template with the given name, and enqueues the email to each of the given addresses.
+// The emails are delivered in background, so a failed delivery does not fail the caller.
+func (s *DantaService) sendBannerEmail(templateName string, data *entity.BannerEmailData, toEmailList []string) error {
+	email, err := s.emailTemplateService.Render(templateName, data)
+	if err != nil {
+		log.Err(err).Msgf("[DantaService.sendBannerEmail] Failed to render email, template: %s", templateName)
+		return err
 	}
 
+	err = s.notificationOutbox.Enqueue(templateName, email, toEmailList)
+	if err != nil {
+		log.Err(err).Msgf("[DantaService.sendBannerEmail] Failed to enqueue email, template: %s", templateName)
+		return err
+	}
 	return nil
 }
 
+// newBannerEmailData builds the variables of banner notification email templates.
+func newBannerEmailData(application entity.BannerApplication, approver string, change *entity.BannerConfigChange, reasons []string) *entity.BannerEmailData {
+	commitLink := ""
+	if change != nil {
+		commitLink = change.Link
+	}
+	if reasons == nil {
+		reasons = make([]string, 0)
+	}
+	return &entity.BannerEmailData{
+		Title:          application.Title,
+		Action:         application.Action,
+		Button:         application.Button,
+		ApplicantEmail: application.ApplicantEmail,
+		StartDate:      FormatBannerDate(application.StartDate),
+		EndDate:        FormatBannerDate(application.EndDate),
+		Approver:       approver,
+		CommitLink:     commitLink,
+		Reasons:        reasons,
+	}
+}
+
+// FormatBannerDate formats a banner date (Unix timestamp in milliseconds) for display in cards and emails.
+func FormatBannerDate(timestamp int64) string {
+	if timestamp == 0 {
+		return "-"
+	}
+	return time.UnixMilli(timestamp).Format(time.DateOnly)
+}
+
 // ConvertBitableRecord2BannerApplication converts a BitableRecord to a Banner application.
 // It returns a pointer to Banner.
 func (s *DantaService) ConvertBitableRecord2BannerApplication(record *larkbitable.AppTableRecord) *entity.BannerApplication {
@@ -201,6 +718,16 @@ func (s *DantaService) ConvertBitableRecord2BannerApplication(record *larkbitabl
 		log.Err(err).Msg("[DantaService.ConvertBitableRecord2BannerApplication] Failed to get applicant email")
 		return nil
 	}
+	startDate, err := getFieldDateValueFromRecord(record, "开始日期")
+	if err != nil {
+		log.Err(err).Msg("[DantaService.ConvertBitableRecord2BannerApplication] Failed to get banner start date")
+		return nil
+	}
+	endDate, err := getFieldDateValueFromRecord(record, "截止日期")
+	if err != nil {
+		log.Err(err).Msg("[DantaService.ConvertBitableRecord2BannerApplication] Failed to get banner end date")
+		return nil
+	}
 	return &entity.BannerApplication{
 		Banner: entity.Banner{
 			Title:  bannerTitle,
@@ -208,5 +735,17 @@ func (s *DantaService) ConvertBitableRecord2BannerApplication(record *larkbitabl
 			Button: bannerButton,
 		},
 		ApplicantEmail: applicantEmail,
+		StartDate:      startDate,
+		EndDate:        endDate,
+	}
+}
+
+// IsBitableRecordWithdrawn checks whether the status of a banner application BitableRecord is set to withdrawn.
+func (s *DantaService) IsBitableRecordWithdrawn(record *larkbitable.AppTableRecord) bool {
+	status, err := getFieldOptionValueFromRecord(record, pkg.LARK_BITABLE_FIELD_BANNER_STATUS)
+	if err != nil {
+		log.Err(err).Msg("[DantaService.IsBitableRecordWithdrawn] Failed to get banner status")
+		return false
 	}
+	return status == pkg.LARK_BITABLE_FIELD_BANNER_STATUS_WITHDRAWN
 }
@@ -0,0 +1,401 @@
+package service
+
+import (
+	"context"
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/internal/githubfake"
+	"dantaautotool/internal/repository"
+	"dantaautotool/pkg"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testBannerConfig = `# Banners shown on the home page
+[[banners]]
+title = "Welcome"
+action = "https://danxi.fduhole.com"
+button = "Go"
+`
+
+// recordingGithubService records the calls made to GithubService, and runs beforePut before each file update,
+// and beforePull before each pull request creation.
+type recordingGithubService struct {
+	GithubServiceIntf
+
+	calls      []string
+	beforePut  func()
+	beforePull func()
+}
+
+func (s *recordingGithubService) GetFileContent(ctx context.Context, owner, repo, path, ref string) (*entity.RepoContent, error) {
+	s.calls = append(s.calls, "GET "+ref)
+	return s.GithubServiceIntf.GetFileContent(ctx, owner, repo, path, ref)
+}
+
+func (s *recordingGithubService) CreateOrUpdateFileContent(ctx context.Context, owner, repo, path, message, content, sha, branch string, committer *entity.Committer) (*entity.CreateOrUpdateFileContentResponse, error) {
+	s.calls = append(s.calls, "PUT "+branch)
+	if s.beforePut != nil {
+		s.beforePut()
+	}
+	return s.GithubServiceIntf.CreateOrUpdateFileContent(ctx, owner, repo, path, message, content, sha, branch, committer)
+}
+
+func (s *recordingGithubService) GetRef(ctx context.Context, owner, repo, ref string) (*entity.GitRef, error) {
+	s.calls = append(s.calls, "GET ref "+ref)
+	return s.GithubServiceIntf.GetRef(ctx, owner, repo, ref)
+}
+
+func (s *recordingGithubService) CreateRef(ctx context.Context, owner, repo, ref, sha string) (*entity.GitRef, error) {
+	s.calls = append(s.calls, "CREATE ref "+ref)
+	return s.GithubServiceIntf.CreateRef(ctx, owner, repo, ref, sha)
+}
+
+func (s *recordingGithubService) DeleteRef(ctx context.Context, owner, repo, ref string) error {
+	s.calls = append(s.calls, "DELETE ref "+ref)
+	return s.GithubServiceIntf.DeleteRef(ctx, owner, repo, ref)
+}
+
+func (s *recordingGithubService) CreatePullRequest(ctx context.Context, owner, repo, title, head, base, body string) (*entity.PullRequest, error) {
+	s.calls = append(s.calls, "PULL "+head+" -> "+base)
+	if s.beforePull != nil {
+		s.beforePull()
+	}
+	return s.GithubServiceIntf.CreatePullRequest(ctx, owner, repo, title, head, base, body)
+}
+
+func (s *recordingGithubService) ListOpenPullRequests(ctx context.Context, owner, repo, head, base string) ([]*entity.PullRequest, error) {
+	s.calls = append(s.calls, "LIST pulls "+head+" -> "+base)
+	return s.GithubServiceIntf.ListOpenPullRequests(ctx, owner, repo, head, base)
+}
+
+// recordingLarkDocService records the IDs of the bitable records updated.
+type recordingLarkDocService struct {
+	LarkDocServiceIntf
+
+	updated []string
+}
+
+func (s *recordingLarkDocService) UpdateBitableRecord(ctx context.Context, appToken, tableID, recordID string, fields map[string]interface{}) error {
+	s.updated = append(s.updated, recordID)
+	return nil
+}
+
+// recordingNotifier renders each email as its template name, and records the emails enqueued.
+type recordingNotifier struct {
+	NotificationOutboxIntf
+
+	enqueued []string
+}
+
+func (s *recordingNotifier) Render(name string, data any) (*entity.RenderedEmail, error) {
+	return &entity.RenderedEmail{Subject: name}, nil
+}
+
+func (s *recordingNotifier) Enqueue(templateName string, email *entity.RenderedEmail, toEmailList []string) error {
+	s.enqueued = append(s.enqueued, templateName)
+	return nil
+}
+
+// newFakeDantaService starts a fake Github seeded with the banner config file,
+// and creates a DantaService working with it through GITHUB_API_BASE_URL.
+func newFakeDantaService(t *testing.T) (*githubfake.Server, *recordingGithubService, *DantaService) {
+	t.Helper()
+	server := githubfake.NewServer("main")
+	t.Cleanup(server.Close)
+	useGithubTestConfig(t, server.URL)
+	server.SetFile(config.Config.GithubDanxiRepoOwner, config.Config.GithubDanxiRepoName, "main", config.Config.GithubDanxiRepoAppConfigPath, testBannerConfig)
+
+	githubService, err := NewGithubService()
+	if err != nil {
+		t.Fatalf("NewGithubService() error = %v", err)
+	}
+	recorder := &recordingGithubService{GithubServiceIntf: githubService}
+	return server, recorder, NewDantaService(nil, recorder, nil, nil, nil, nil)
+}
+
+// bannerConfigAt returns the content of the banner config file at the given ref of the fake Github.
+func bannerConfigAt(t *testing.T, server *githubfake.Server, ref string) string {
+	t.Helper()
+	content, _, ok := server.File(config.Config.GithubDanxiRepoOwner, config.Config.GithubDanxiRepoName, ref, config.Config.GithubDanxiRepoAppConfigPath)
+	if !ok {
+		t.Fatalf("banner config file not found at %s", ref)
+	}
+	return content
+}
+
+func TestDantaServiceUpdateAndRemoveBanner(t *testing.T) {
+	server, _, dantaService := newFakeDantaService(t)
+	ctx := context.Background()
+	banner := entity.Banner{Title: "New", Action: "https://example.com", Button: "Open"}
+
+	change, err := dantaService.UpdateBanner(ctx, "rec1", banner, "Alice")
+	if err != nil {
+		t.Fatalf("UpdateBanner() error = %v", err)
+	}
+	if change == nil || change.CommitSHA == "" || !strings.Contains(change.Link, change.CommitSHA) {
+		t.Fatalf("UpdateBanner() change = %+v", change)
+	}
+	want := testBannerConfig + "\n[[banners]]\ntitle = \"New\"\naction = \"https://example.com\"\nbutton = \"Open\"\n"
+	if got := bannerConfigAt(t, server, "main"); got != want {
+		t.Errorf("config after UpdateBanner() = %q, want %q", got, want)
+	}
+
+	// adding the same banner again changes nothing
+	change, err = dantaService.UpdateBanner(ctx, "rec1", banner, "Alice")
+	if err != nil || change != nil {
+		t.Errorf("UpdateBanner() of an existing banner = %+v, %v, want nil, nil", change, err)
+	}
+
+	change, err = dantaService.RemoveBanner(ctx, "rec1", banner.Title)
+	if err != nil || change == nil {
+		t.Fatalf("RemoveBanner() = %+v, %v", change, err)
+	}
+	if got := bannerConfigAt(t, server, "main"); got != testBannerConfig {
+		t.Errorf("config after RemoveBanner() = %q, want %q", got, testBannerConfig)
+	}
+
+	// removing a banner that does not exist changes nothing
+	change, err = dantaService.RemoveBanner(ctx, "rec1", banner.Title)
+	if err != nil || change != nil {
+		t.Errorf("RemoveBanner() of a missing banner = %+v, %v, want nil, nil", change, err)
+	}
+}
+
+func TestDantaServiceUpdateBannerRetriesOnConflict(t *testing.T) {
+	server, recorder, dantaService := newFakeDantaService(t)
+	owner, repo, path := config.Config.GithubDanxiRepoOwner, config.Config.GithubDanxiRepoName, config.Config.GithubDanxiRepoAppConfigPath
+	otherChange := testBannerConfig + "\n[[banners]]\ntitle = \"Other\"\naction = \"https://other.com\"\nbutton = \"Go\"\n"
+	// someone else commits between our read and our write, which makes our SHA stale
+	recorder.beforePut = func() {
+		recorder.beforePut = nil
+		server.SetFile(owner, repo, "main", path, otherChange)
+	}
+
+	banner := entity.Banner{Title: "New", Action: "https://example.com", Button: "Open"}
+	change, err := dantaService.UpdateBanner(context.Background(), "rec1", banner, "Alice")
+	if err != nil || change == nil {
+		t.Fatalf("UpdateBanner() = %+v, %v", change, err)
+	}
+	want := otherChange + "\n[[banners]]\ntitle = \"New\"\naction = \"https://example.com\"\nbutton = \"Open\"\n"
+	if got := bannerConfigAt(t, server, "main"); got != want {
+		t.Errorf("config after retry = %q, want %q", got, want)
+	}
+	wantCalls := []string{"GET main", "PUT main", "GET main", "PUT main"}
+	if strings.Join(recorder.calls, "\n") != strings.Join(wantCalls, "\n") {
+		t.Errorf("calls = %q, want %q", recorder.calls, wantCalls)
+	}
+}
+
+func TestDantaServiceReviewMode(t *testing.T) {
+	server, recorder, dantaService := newFakeDantaService(t)
+	config.Config.GithubReviewMode = true
+	owner, repo, path := config.Config.GithubDanxiRepoOwner, config.Config.GithubDanxiRepoName, config.Config.GithubDanxiRepoAppConfigPath
+	ctx := context.Background()
+	banner := entity.Banner{Title: "Welcome"}
+
+	// a branch left behind by an earlier failed attempt is replaced
+	server.SetFile(owner, repo, "danta-auto-tool/remove-banner-rec1", path, "stale")
+
+	change, err := dantaService.RemoveBanner(ctx, "rec1", banner.Title)
+	if err != nil || change == nil {
+		t.Fatalf("RemoveBanner() = %+v, %v", change, err)
+	}
+	baseSHA := server.Branches(owner, repo)["main"]
+	wantCalls := []string{
+		"GET ref heads/main",
+		"GET " + baseSHA,
+		"CREATE ref refs/heads/danta-auto-tool/remove-banner-rec1",
+		"DELETE ref heads/danta-auto-tool/remove-banner-rec1",
+		"CREATE ref refs/heads/danta-auto-tool/remove-banner-rec1",
+		"PUT danta-auto-tool/remove-banner-rec1",
+		"PULL danta-auto-tool/remove-banner-rec1 -> main",
+	}
+	if strings.Join(recorder.calls, "\n") != strings.Join(wantCalls, "\n") {
+		t.Errorf("calls = %q, want %q", recorder.calls, wantCalls)
+	}
+
+	// the change is proposed on the branch, and the base branch is left as is
+	if got := bannerConfigAt(t, server, "main"); got != testBannerConfig {
+		t.Errorf("config on main = %q, want %q", got, testBannerConfig)
+	}
+	if got, want := bannerConfigAt(t, server, "danta-auto-tool/remove-banner-rec1"), "# Banners shown on the home page\n"; got != want {
+		t.Errorf("config on branch = %q, want %q", got, want)
+	}
+	pullRequests := server.PullRequests(owner, repo)
+	if len(pullRequests) != 1 || pullRequests[0].HTMLURL != change.Link || pullRequests[0].Title != `Remove banner "Welcome"` {
+		t.Errorf("pull requests = %+v, change = %+v", pullRequests, change)
+	}
+}
+
+func TestDantaServiceReviewModeKeepsExistingPullRequest(t *testing.T) {
+	server, recorder, dantaService := newFakeDantaService(t)
+	config.Config.GithubReviewMode = true
+	owner, repo := config.Config.GithubDanxiRepoOwner, config.Config.GithubDanxiRepoName
+	ctx := context.Background()
+	branch := "danta-auto-tool/add-banner-rec1"
+	// the pull request has been created by an earlier request whose response was lost
+	recorder.beforePull = func() {
+		recorder.beforePull = nil
+		_, err := recorder.GithubServiceIntf.CreatePullRequest(ctx, owner, repo, "Earlier", branch, "main", "")
+		if err != nil {
+			t.Errorf("CreatePullRequest() error = %v", err)
+		}
+	}
+
+	change, err := dantaService.UpdateBanner(ctx, "rec1", entity.Banner{Title: "New"}, "Alice")
+	if err != nil || change == nil {
+		t.Fatalf("UpdateBanner() = %+v, %v", change, err)
+	}
+	wantCalls := []string{
+		"PULL " + branch + " -> main",
+		"LIST pulls " + owner + ":" + branch + " -> main",
+	}
+	if got := recorder.calls[len(recorder.calls)-2:]; strings.Join(got, "\n") != strings.Join(wantCalls, "\n") {
+		t.Errorf("last calls = %q, want %q, and the branch not deleted", recorder.calls, wantCalls)
+	}
+	pullRequests := server.PullRequests(owner, repo)
+	if len(pullRequests) != 1 || pullRequests[0].State != "open" || pullRequests[0].HTMLURL != change.Link {
+		t.Errorf("pull requests = %+v, change = %+v, want the existing one kept open", pullRequests, change)
+	}
+	if _, ok := server.Branches(owner, repo)[branch]; !ok {
+		t.Errorf("branch %s is deleted", branch)
+	}
+}
+
+func TestDantaServiceWithdrawBannerIsIdempotent(t *testing.T) {
+	server, recorder, _ := newFakeDantaService(t)
+	db, err := repository.OpenBoltDB(filepath.Join(t.TempDir(), "danta.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltDB() error = %v", err)
+	}
+	defer db.Close()
+	bannerApplicationRepository, err := repository.NewBannerApplicationRepository(db)
+	if err != nil {
+		t.Fatalf("NewBannerApplicationRepository() error = %v", err)
+	}
+	dantaService := NewDantaService(nil, recorder, nil, bannerApplicationRepository, nil, nil)
+	application := entity.BannerApplication{Banner: entity.Banner{Title: "Welcome"}}
+
+	// a finished withdrawal is not done again
+	_, err = bannerApplicationRepository.Update("rec1", func(state *entity.BannerApplicationState) error {
+		state.Status = pkg.BANNER_STATUS_WITHDRAWN
+		state.WithdrawnAt = 1
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := dantaService.WithdrawBanner(context.Background(), "rec1", application); err != nil {
+		t.Fatalf("WithdrawBanner() of a withdrawn application error = %v", err)
+	}
+	if len(recorder.calls) != 0 {
+		t.Errorf("WithdrawBanner() of a withdrawn application calls Github: %q", recorder.calls)
+	}
+
+	// a withdrawal failed halfway is done again, and it fails at the usage table not configured here
+	_, err = bannerApplicationRepository.Update("rec2", func(state *entity.BannerApplicationState) error {
+		state.Status = pkg.BANNER_STATUS_WITHDRAWN
+		state.Published = true
+		state.UsageRecordID = "usage2"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := dantaService.WithdrawBanner(context.Background(), "rec2", application); err == nil {
+		t.Error("WithdrawBanner() without the usage table configured succeeded, want an error")
+	}
+	if got := bannerConfigAt(t, server, "main"); got != "# Banners shown on the home page\n" {
+		t.Errorf("config after WithdrawBanner() = %q, want the banner removed", got)
+	}
+	state, err := bannerApplicationRepository.Get("rec2")
+	if err != nil || state.WithdrawnAt != 0 {
+		t.Errorf("state after a failed withdrawal = %+v, %v, want it not finished", state, err)
+	}
+}
+
+func TestDantaServiceWithdrawBannerOnlyTouchesItsApplication(t *testing.T) {
+	server, recorder, _ := newFakeDantaService(t)
+	config.Config.LarkBannerBitableAppToken = "app"
+	config.Config.LarkBannerBitableUsageTableID = "usage"
+	db, err := repository.OpenBoltDB(filepath.Join(t.TempDir(), "danta.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltDB() error = %v", err)
+	}
+	defer db.Close()
+	bannerApplicationRepository, err := repository.NewBannerApplicationRepository(db)
+	if err != nil {
+		t.Fatalf("NewBannerApplicationRepository() error = %v", err)
+	}
+	larkDocService := &recordingLarkDocService{}
+	notifier := &recordingNotifier{}
+	dantaService := NewDantaService(larkDocService, recorder, nil, bannerApplicationRepository, notifier, notifier)
+	// the banner "Welcome" in the config file belongs to another application
+	application := entity.BannerApplication{Banner: entity.Banner{Title: "Welcome"}}
+	states := map[string]func(state *entity.BannerApplicationState){
+		"scheduled": func(state *entity.BannerApplicationState) {
+			state.Status = pkg.BANNER_STATUS_APPROVED
+			state.UsageRecordID = "usage1"
+		},
+		"disapproved": func(state *entity.BannerApplicationState) {
+			state.Status = pkg.BANNER_STATUS_DISAPPROVED
+		},
+		"published": func(state *entity.BannerApplicationState) {
+			state.Status = pkg.BANNER_STATUS_APPROVED
+			state.Published = true
+		},
+	}
+	for recordID, set := range states {
+		_, err = bannerApplicationRepository.Update(recordID, func(state *entity.BannerApplicationState) error {
+			state.BannerApplication = application
+			set(state)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+
+	// an application not published yet leaves the config file alone, and only its own usage record is updated
+	if err := dantaService.WithdrawBanner(context.Background(), "scheduled", application); err != nil {
+		t.Fatalf("WithdrawBanner() error = %v", err)
+	}
+	if len(recorder.calls) != 0 || bannerConfigAt(t, server, "main") != testBannerConfig {
+		t.Errorf("WithdrawBanner() of an unpublished application changes the config file, calls: %q", recorder.calls)
+	}
+	if !slices.Equal(larkDocService.updated, []string{"usage1"}) {
+		t.Errorf("usage records updated = %q, want only usage1", larkDocService.updated)
+	}
+	if !slices.Equal(notifier.enqueued, []string{pkg.EMAIL_TEMPLATE_BANNER_WITHDRAWN}) {
+		t.Errorf("emails enqueued = %q, want the withdrawal email", notifier.enqueued)
+	}
+	if state, err := bannerApplicationRepository.Get("scheduled"); err != nil || state.Status != pkg.BANNER_STATUS_WITHDRAWN || state.WithdrawnAt == 0 {
+		t.Errorf("state after WithdrawBanner() = %+v, %v, want it withdrawn", state, err)
+	}
+
+	// a disapproved application has nothing to withdraw
+	if err := dantaService.WithdrawBanner(context.Background(), "disapproved", application); !errors.Is(err, ErrBannerDisapproved) {
+		t.Errorf("WithdrawBanner() of a disapproved application error = %v, want %v", err, ErrBannerDisapproved)
+	}
+	if state, err := bannerApplicationRepository.Get("disapproved"); err != nil || state.Status != pkg.BANNER_STATUS_DISAPPROVED {
+		t.Errorf("state after WithdrawBanner() of a disapproved application = %+v, %v, want it disapproved", state, err)
+	}
+
+	// a published banner is removed, and an application never logged updates no usage record
+	if err := dantaService.WithdrawBanner(context.Background(), "published", application); err != nil {
+		t.Fatalf("WithdrawBanner() error = %v", err)
+	}
+	if got := bannerConfigAt(t, server, "main"); got != "# Banners shown on the home page\n" {
+		t.Errorf("config after WithdrawBanner() = %q, want the banner removed", got)
+	}
+	if len(larkDocService.updated) != 1 {
+		t.Errorf("usage records updated = %q, want none more", larkDocService.updated)
+	}
+	if state, err := bannerApplicationRepository.Get("published"); err != nil || state.Published || state.WithdrawnAt == 0 {
+		t.Errorf("state after WithdrawBanner() = %+v, %v, want it taken down", state, err)
+	}
+}
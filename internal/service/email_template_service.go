@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bytes"
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/pkg"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Each email template consists of the following files in the directory of its locale, e.g. zh_cn/banner_approved.subject.tmpl
+const (
+	// emailSubjectSuffix is the suffix of the subject template (text/template), which is required
+	emailSubjectSuffix = ".subject.tmpl"
+
+	// emailPlainTextSuffix is the suffix of the plain text body template (text/template), which is required
+	emailPlainTextSuffix = ".txt.tmpl"
+
+	// emailHtmlSuffix is the suffix of the HTML body template (html/template), which is optional
+	emailHtmlSuffix = ".html.tmpl"
+)
+
+// EmailTemplateServiceIntf defines the interface for EmailTemplateService.
+type EmailTemplateServiceIntf interface {
+	// Render renders the email template with the given name in the configured locale.
+	// It falls back to zh_cn if the template does not exist in the configured locale.
+	Render(name string, data any) (*entity.RenderedEmail, error)
+}
+
+// emailTemplate is a parsed email template.
+type emailTemplate struct {
+	subject   *texttemplate.Template
+	plainText *texttemplate.Template
+
+	// html is nil if the template has no HTML body
+	html *htmltemplate.Template
+}
+
+// EmailTemplateService renders notification emails from template files.
+//
+// Templates are loaded from the configured directory at startup, organized by locale:
+//
+//	<dir>/zh_cn/banner_approved.subject.tmpl
+//	<dir>/zh_cn/banner_approved.txt.tmpl
+//	<dir>/zh_cn/banner_approved.html.tmpl
+//	<dir>/en_us/...
+type EmailTemplateService struct {
+	// locale is the locale used to render emails
+	locale string
+
+	// templates are the parsed templates, keyed by locale and then by template name
+	templates map[string]map[string]*emailTemplate
+}
+
+// NewEmailTemplateService creates a new instance of EmailTemplateService, loading all templates in the configured directory.
+// It returns an error if any template fails to parse, so that broken templates are found at startup.
+func NewEmailTemplateService() (*EmailTemplateService, error) {
+	locale := config.Config.EmailLocale
+	if locale != pkg.EMAIL_LOCALE_ZH_CN && locale != pkg.EMAIL_LOCALE_EN_US {
+		log.Warn().Msgf("[NewEmailTemplateService] Invalid locale: %s, fallback to %s", locale, pkg.EMAIL_LOCALE_ZH_CN)
+		locale = pkg.EMAIL_LOCALE_ZH_CN
+	}
+
+	templateDir := config.Config.EmailTemplateDir
+	templates := make(map[string]map[string]*emailTemplate)
+	for _, templateLocale := range []string{pkg.EMAIL_LOCALE_ZH_CN, pkg.EMAIL_LOCALE_EN_US} {
+		localeTemplates, err := loadEmailTemplates(filepath.Join(templateDir, templateLocale))
+		if err != nil {
+			log.Err(err).Msgf("[NewEmailTemplateService] Failed to load email templates, locale: %s", templateLocale)
+			return nil, err
+		}
+		templates[templateLocale] = localeTemplates
+	}
+	// zh_cn is the fallback locale, so it must have all templates
+	for _, name := range []string{pkg.EMAIL_TEMPLATE_BANNER_APPROVED, pkg.EMAIL_TEMPLATE_BANNER_DISAPPROVED, pkg.EMAIL_TEMPLATE_BANNER_WITHDRAWN} {
+		if _, ok := templates[pkg.EMAIL_LOCALE_ZH_CN][name]; !ok {
+			log.Error().Msgf("[NewEmailTemplateService] Email template not found in %s: %s", pkg.EMAIL_LOCALE_ZH_CN, name)
+			return nil, fmt.Errorf("email template not found in %s: %s", pkg.EMAIL_LOCALE_ZH_CN, name)
+		}
+	}
+	log.Info().Msgf("[NewEmailTemplateService] Email templates loaded from %s, locale: %s", templateDir, locale)
+
+	return &EmailTemplateService{
+		locale:    locale,
+		templates: templates,
+	}, nil
+}
+
+// Render renders the email template with the given name in the configured locale.
+// It falls back to zh_cn if the template does not exist in the configured locale.
+func (s *EmailTemplateService) Render(name string, data any) (*entity.RenderedEmail, error) {
+	tmpl, ok := s.templates[s.locale][name]
+	if !ok {
+		tmpl, ok = s.templates[pkg.EMAIL_LOCALE_ZH_CN][name]
+	}
+	if !ok {
+		log.Error().Msgf("[EmailTemplateService.Render] Email template not found: %s", name)
+		return nil, fmt.Errorf("email template not found: %s", name)
+	}
+
+	var subject, plainText, html bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		log.Err(err).Msgf("[EmailTemplateService.Render] Failed to render subject, template: %s", name)
+		return nil, err
+	}
+	if err := tmpl.plainText.Execute(&plainText, data); err != nil {
+		log.Err(err).Msgf("[EmailTemplateService.Render] Failed to render plain text body, template: %s", name)
+		return nil, err
+	}
+	if tmpl.html != nil {
+		if err := tmpl.html.Execute(&html, data); err != nil {
+			log.Err(err).Msgf("[EmailTemplateService.Render] Failed to render HTML body, template: %s", name)
+			return nil, err
+		}
+	}
+	return &entity.RenderedEmail{
+		// the subject must be a single line
+		Subject:       strings.Join(strings.Fields(subject.String()), " "),
+		BodyPlainText: plainText.String(),
+		BodyHtml:      html.String(),
+	}, nil
+}
+
+// loadEmailTemplates parses all email templates in the directory of a locale, keyed by template name.
+// A missing directory is treated as having no templates.
+func loadEmailTemplates(dir string) (map[string]*emailTemplate, error) {
+	templates := make(map[string]*emailTemplate)
+	subjectFiles, err := filepath.Glob(filepath.Join(dir, "*"+emailSubjectSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, subjectFile := range subjectFiles {
+		name := strings.TrimSuffix(filepath.Base(subjectFile), emailSubjectSuffix)
+
+		subject, err := texttemplate.ParseFiles(subjectFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse email template %s: %w", subjectFile, err)
+		}
+		plainTextFile := filepath.Join(dir, name+emailPlainTextSuffix)
+		plainText, err := texttemplate.ParseFiles(plainTextFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse email template %s: %w", plainTextFile, err)
+		}
+		tmpl := &emailTemplate{
+			subject:   subject,
+			plainText: plainText,
+		}
+
+		htmlFile := filepath.Join(dir, name+emailHtmlSuffix)
+		if _, err := os.Stat(htmlFile); err == nil {
+			tmpl.html, err = htmltemplate.ParseFiles(htmlFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse email template %s: %w", htmlFile, err)
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
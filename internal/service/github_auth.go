@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"dantaautotool/internal/entity"
+	"dantaautotool/pkg/utils/http"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// githubAppJWTLifetime is the lifetime of a JWT signed by the app private key, which Github limits to 10 minutes
+	githubAppJWTLifetime = 9 * time.Minute
+
+	// githubAppJWTClockSkew is how far the issue time of a JWT is set in the past, to allow for clock drift
+	githubAppJWTClockSkew = time.Minute
+)
+
+// githubAuthenticator provides the Authorization header of requests to Github.
+type githubAuthenticator interface {
+	// authorization returns the value of the Authorization header.
+	authorization(ctx context.Context) (string, error)
+
+	// committer returns the committer of commits made through the API, or nil to commit as the authenticated identity.
+	committer() *entity.Committer
+}
+
+// githubPATAuthenticator authenticates by a personal access token, so requests are made as its owner.
+type githubPATAuthenticator struct {
+	pat string
+}
+
+// authorization returns the value of the Authorization header.
+func (a *githubPATAuthenticator) authorization(ctx context.Context) (string, error) {
+	return "token " + a.pat, nil
+}
+
+// committer returns the committer of commits made through the API.
+// The commits are made on behalf of the token owner, so a committer of the tool is given.
+func (a *githubPATAuthenticator) committer() *entity.Committer {
+	return &entity.Committer{
+		Name:  "Danta Auto Tool",
+		Email: "danta@example.com",
+	} // TODO: use the real committer @xunzhou24
+}
+
+// githubAppAuthenticator authenticates as an installation of a Github App, so requests are made as the app bot.
+//
+// A JWT signed by the app private key is exchanged for an installation access token, which expires in an hour,
+// see https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/authenticating-as-a-github-app-installation.
+// The token is cached, and exchanged again refreshAhead before it expires.
+type githubAppAuthenticator struct {
+	// client is used to exchange tokens, sharing the TLS and proxy settings of other requests to Github.
+	// It never records or replays the exchanges with a cassette, since the responses carry the tokens.
+	client *http.HTTPClient
+
+	// retryPolicy decides whether and when failed exchanges are retried
+	retryPolicy *http.RetryPolicy
+
+	// appID is the App ID or the Client ID of the app, used as the issuer of JWTs
+	appID string
+
+	// installationID is the ID of the installation of the app on the repository
+	installationID string
+
+	// privateKey signs JWTs
+	privateKey *rsa.PrivateKey
+
+	// refreshAhead is how long before the installation access token expires it is exchanged again
+	refreshAhead time.Duration
+
+	// mu protects token, and serializes exchanges
+	mu sync.Mutex
+
+	// token is the cached installation access token, nil before the first exchange
+	token *entity.InstallationAccessToken
+}
+
+// newGithubAppAuthenticator creates a new instance of githubAppAuthenticator, loading the private key from the PEM file.
+func newGithubAppAuthenticator(client *http.HTTPClient, retryPolicy *http.RetryPolicy, appID, installationID, privateKeyFile string, refreshAhead time.Duration) (*githubAppAuthenticator, error) {
+	if appID == "" {
+		return nil, errors.New("GITHUB_APP_ID is empty")
+	}
+	if installationID == "" {
+		return nil, errors.New("GITHUB_APP_INSTALLATION_ID is empty")
+	}
+	if privateKeyFile == "" {
+		return nil, errors.New("GITHUB_APP_PRIVATE_KEY_FILE is empty")
+	}
+	privateKeyPEM, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Github App private key: %w", err)
+	}
+	privateKey, err := parseRSAPrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Github App private key %s: %w", privateKeyFile, err)
+	}
+	return &githubAppAuthenticator{
+		client:         client,
+		retryPolicy:    retryPolicy,
+		appID:          appID,
+		installationID: installationID,
+		privateKey:     privateKey,
+		refreshAhead:   refreshAhead,
+	}, nil
+}
+
+// authorization returns the value of the Authorization header, exchanging a new installation access token
+// if the cached one is about to expire.
+func (a *githubAppAuthenticator) authorization(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token == nil || !time.Now().Add(a.refreshAhead).Before(a.token.ExpiresAt) {
+		token, err := a.exchange(ctx)
+		if err != nil {
+			log.Err(err).Msgf("[githubAppAuthenticator.authorization] Failed to get installation access token, installation ID: %s", a.installationID)
+			return "", err
+		}
+		a.token = token
+		log.Info().Msgf("[githubAppAuthenticator.authorization] Installation access token refreshed, expires at: %s", token.ExpiresAt.Format(time.RFC3339))
+	}
+	return "Bearer " + a.token.Token, nil
+}
+
+// committer returns nil, so that commits are made as the app bot, and are marked as verified by Github.
+func (a *githubAppAuthenticator) committer() *entity.Committer {
+	return nil
+}
+
+// exchange exchanges a JWT for a new installation access token.
+// See https://docs.github.com/en/rest/apps/apps?apiVersion=2022-11-28#create-an-installation-access-token-for-an-app for more details.
+func (a *githubAppAuthenticator) exchange(ctx context.Context) (*entity.InstallationAccessToken, error) {
+	jwt, err := a.signJWT(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign JWT: %w", err)
+	}
+	headers := map[string]string{
+		"Accept":               "application/vnd.github+json",
+		"Authorization":        "Bearer " + jwt,
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+	pathParams := map[string]string{
+		"installation_id": a.installationID,
+	}
+	statusCode, _, respBodyBytes, err := a.client.PerformRequestWithRetry(ctx, "/app/installations/{installation_id}/access_tokens", consts.MethodPost, headers, pathParams, nil, nil, a.retryPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != consts.StatusCreated {
+		log.Error().Msgf("[githubAppAuthenticator.exchange] Failed to create installation access token, status code: %d, response: %s", statusCode, string(respBodyBytes))
+		return nil, newGithubAPIError("create installation access token", statusCode, respBodyBytes)
+	}
+
+	var token entity.InstallationAccessToken
+	if err := sonic.Unmarshal(respBodyBytes, &token); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal installation access token: %w", err)
+	}
+	if token.Token == "" {
+		return nil, errors.New("installation access token is empty")
+	}
+	return &token, nil
+}
+
+// signJWT signs a JWT issued by the app at the given time, with the RS256 algorithm required by Github.
+func (a *githubAppAuthenticator) signJWT(now time.Time) (string, error) {
+	header, err := sonic.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := sonic.Marshal(map[string]any{
+		"iat": now.Add(-githubAppJWTClockSkew).Unix(),
+		"exp": now.Add(githubAppJWTLifetime).Unix(),
+		"iss": a.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseRSAPrivateKey parses an RSA private key in PEM format,
+// either PKCS #1 as downloaded from Github, or PKCS #8.
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -1,11 +1,17 @@
 package service
 
 import (
+	"context"
 	"dantaautotool/config"
 	"dantaautotool/internal/entity"
+	"dantaautotool/pkg"
 	"dantaautotool/pkg/utils/http"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"maps"
 
@@ -14,16 +20,89 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// githubDefaultAPIBaseURL is the address of the Github API on github.com
+const githubDefaultAPIBaseURL = "https://api.github.com"
+
 // GithubServiceIntf defines the interface for GithubService.
 type GithubServiceIntf interface {
 
-	// GetFileContent retrieves the content of a file given its path.
+	// GetFileContent retrieves the content of a file given its path, at the given ref (branch, tag or commit SHA).
+	// If ref is empty, the default branch of the repository is used.
 	// It returns the content and an error if any occurs.
-	GetFileContent(owner, repo, path string) (*entity.RepoContent, error)
+	GetFileContent(ctx context.Context, owner, repo, path, ref string) (*entity.RepoContent, error)
 
 	// CreateOrUpdateFileContent creates or updates the content of a file given its path.
+	// It returns the created commit and an error if any occurs.
+	CreateOrUpdateFileContent(ctx context.Context, owner, repo, path, message, content, sha, branch string, committer *entity.Committer) (*entity.CreateOrUpdateFileContentResponse, error)
+
+	// GetRef retrieves a reference given its name without "refs/" prefix, e.g. "heads/main".
+	// It returns the reference and an error if any occurs.
+	GetRef(ctx context.Context, owner, repo, ref string) (*entity.GitRef, error)
+
+	// CreateRef creates a reference pointing to the given SHA, e.g. a new branch.
+	// ref is the fully qualified name, e.g. "refs/heads/new-branch".
+	// It returns the created reference and an error if any occurs.
+	CreateRef(ctx context.Context, owner, repo, ref, sha string) (*entity.GitRef, error)
+
+	// DeleteRef deletes a reference given its name without "refs/" prefix, e.g. "heads/feature".
 	// It returns an error if any occurs.
-	CreateOrUpdateFileContent(owner, repo, path, message, content, sha, branch string, committer *entity.Committer) error
+	DeleteRef(ctx context.Context, owner, repo, ref string) error
+
+	// CreatePullRequest creates a pull request merging head into base.
+	// It returns the created pull request and an error if any occurs.
+	CreatePullRequest(ctx context.Context, owner, repo, title, head, base, body string) (*entity.PullRequest, error)
+
+	// ListOpenPullRequests lists the open pull requests merging head into base.
+	// head is the branch prefixed with its owner, e.g. "octocat:feature".
+	// It returns the pull requests and an error if any occurs.
+	ListOpenPullRequests(ctx context.Context, owner, repo, head, base string) ([]*entity.PullRequest, error)
+
+	// Committer returns the committer to commit file changes as, or nil to commit as the authenticated identity.
+	Committer() *entity.Committer
+}
+
+// GithubAPIError is returned when Github API responds with an unexpected status code.
+type GithubAPIError struct {
+	// Operation is the operation that failed, e.g. "get file content"
+	Operation string
+
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+
+	// Body is the response body, which usually contains the error message from Github
+	Body string
+}
+
+// newGithubAPIError creates a new GithubAPIError.
+func newGithubAPIError(operation string, statusCode int, respBodyBytes []byte) *GithubAPIError {
+	return &GithubAPIError{
+		Operation:  operation,
+		StatusCode: statusCode,
+		Body:       string(respBodyBytes),
+	}
+}
+
+// Error implements the error interface.
+func (e *GithubAPIError) Error() string {
+	return fmt.Sprintf("failed to %s, status code: %d", e.Operation, e.StatusCode)
+}
+
+// githubOperationUpdateFileContent is the operation of GithubAPIError returned by CreateOrUpdateFileContent.
+const githubOperationUpdateFileContent = "create or update file content"
+
+// IsGithubConflictError checks whether err is caused by a conflict, e.g. the file has been changed since we read it,
+// in which case Github responds with 409 Conflict, or with 422 Unprocessable Entity when updating a file.
+// Other endpoints respond with 422 for requests which fail the same way if retried, e.g. a pull request that already exists,
+// so they are not conflicts.
+func IsGithubConflictError(err error) bool {
+	var apiErr *GithubAPIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode == consts.StatusConflict {
+		return true
+	}
+	return apiErr.StatusCode == consts.StatusUnprocessableEntity && apiErr.Operation == githubOperationUpdateFileContent
 }
 
 // GithubService provides methods to interact with Github.
@@ -31,55 +110,215 @@ type GithubService struct {
 	// client is used to interact with Github
 	client *http.HTTPClient
 
-	// config for github authentication
-	authHeaders map[string]string
+	// headers are sent with every request to Github
+	headers map[string]string
+
+	// authenticator provides the Authorization header, by a personal access token or as a Github App
+	authenticator githubAuthenticator
+
+	// retryPolicy decides whether and when failed requests to Github are retried
+	retryPolicy *http.RetryPolicy
 }
 
 // NewGithubService creates a new instance of GithubService.
-func NewGithubService() *GithubService {
-	cli := http.NewHTTPClient(
-		"https://api.github.com",
+// It returns an error if the configuration is invalid, e.g. the auth mode, the TLS settings or the proxy.
+func NewGithubService() (*GithubService, error) {
+	tlsMinVersion, err := http.ParseTLSVersion(config.Config.GithubTLSMinVersion)
+	if err != nil {
+		log.Err(err).Msg("[NewGithubService] Invalid GITHUB_TLS_MIN_VERSION")
+		return nil, err
+	}
+	// tlsAndProxyOptions are shared by the client to exchange Github App tokens, which must not be recorded
+	tlsAndProxyOptions := []http.HTTPClientOption{
+		http.WithTLSOptions(http.TLSOptions{
+			CAFile:             config.Config.GithubTLSCAFile,
+			CertFile:           config.Config.GithubTLSCertFile,
+			KeyFile:            config.Config.GithubTLSKeyFile,
+			MinVersion:         tlsMinVersion,
+			InsecureSkipVerify: config.Config.GithubTLSInsecureSkipVerify,
+		}),
+		http.WithProxyURL(config.Config.GithubProxyURL),
+	}
+	options := tlsAndProxyOptions
+	if cassetteMode := config.Config.GithubCassetteMode; cassetteMode != "" {
+		cassette, err := newGithubCassette(cassetteMode, config.Config.GithubCassettePath)
+		if err != nil {
+			log.Err(err).Msg("[NewGithubService] Failed to create cassette")
+			return nil, err
+		}
+		log.Warn().Msgf("[NewGithubService] Github requests are in %s mode, cassette: %s", cassetteMode, cassette.Path)
+		options = append(slices.Clone(tlsAndProxyOptions), http.WithCassette(cassette))
+	}
+	baseURL := strings.TrimSuffix(config.Config.GithubAPIBaseURL, "/")
+	if baseURL == "" {
+		log.Warn().Msgf("[NewGithubService] GITHUB_API_BASE_URL is empty, fallback to %s", githubDefaultAPIBaseURL)
+		baseURL = githubDefaultAPIBaseURL
+	}
+	middlewares := http.EmptyHTTPClientMiddlewareSlice()
+	if scriptPath := config.Config.GithubMiddlewareScriptPath; scriptPath != "" {
+		scriptMiddleware := http.NewHTTPClientScriptMiddleware(scriptPath)
+		if scriptMiddleware == nil {
+			log.Error().Msgf("[NewGithubService] Failed to load GITHUB_MIDDLEWARE_SCRIPT_PATH: %s", scriptPath)
+			return nil, fmt.Errorf("failed to load GITHUB_MIDDLEWARE_SCRIPT_PATH: %s", scriptPath)
+		}
+		// the script is watched as long as the service lives, i.e. until the program exits
+		if watchSeconds := config.Config.GithubMiddlewareScriptWatchSeconds; watchSeconds > 0 {
+			scriptMiddleware.Watch(time.Duration(watchSeconds) * time.Second)
+		}
+		middlewares = append(middlewares, scriptMiddleware)
+	}
+	cli, err := http.NewHTTPClient(
+		baseURL,
 		[]string{},
-		http.EmptyHTTPClientMiddlewareSlice(),
+		middlewares,
+		options...,
 	)
+	if err != nil {
+		log.Err(err).Msg("[NewGithubService] Failed to create HTTP client")
+		return nil, err
+	}
+	timeoutSeconds := config.Config.GithubRequestTimeoutSeconds
+	if timeoutSeconds <= 0 {
+		log.Warn().Msgf("[NewGithubService] Invalid request timeout: %d, fallback to %s", timeoutSeconds, http.DefaultTimeout)
+	} else {
+		cli.Timeout = time.Duration(timeoutSeconds) * time.Second
+	}
 
-	pat := config.Config.GithubPersonalAccessToken
-	if pat == "" {
-		log.Error().Msg("[NewGithubService] GITHUB_PERSONAL_ACCESS_TOKEN is empty")
-		return nil
+	retryPolicy := newGithubRetryPolicy()
+	var authenticator githubAuthenticator
+	switch authMode := config.Config.GithubAuthMode; authMode {
+	case pkg.GITHUB_AUTH_MODE_PAT:
+		pat := config.Config.GithubPersonalAccessToken
+		if pat == "" {
+			log.Error().Msg("[NewGithubService] GITHUB_PERSONAL_ACCESS_TOKEN is empty")
+			return nil, errors.New("GITHUB_PERSONAL_ACCESS_TOKEN is empty")
+		}
+		authenticator = &githubPATAuthenticator{pat: pat}
+	case pkg.GITHUB_AUTH_MODE_APP:
+		refreshAheadSeconds := config.Config.GithubAppTokenRefreshAheadSeconds
+		if refreshAheadSeconds <= 0 {
+			log.Warn().Msgf("[NewGithubService] Invalid app token refresh ahead seconds: %d, fallback to 300", refreshAheadSeconds)
+			refreshAheadSeconds = 300
+		}
+		// installation access tokens would be saved in plaintext if they were exchanged through the cassette
+		tokenCli, err := http.NewHTTPClient(baseURL, []string{}, http.EmptyHTTPClientMiddlewareSlice(), tlsAndProxyOptions...)
+		if err != nil {
+			log.Err(err).Msg("[NewGithubService] Failed to create HTTP client for Github App tokens")
+			return nil, err
+		}
+		tokenCli.Timeout = cli.Timeout
+		authenticator, err = newGithubAppAuthenticator(
+			tokenCli,
+			retryPolicy,
+			config.Config.GithubAppID,
+			config.Config.GithubAppInstallationID,
+			config.Config.GithubAppPrivateKeyFile,
+			time.Duration(refreshAheadSeconds)*time.Second,
+		)
+		if err != nil {
+			log.Err(err).Msg("[NewGithubService] Failed to create Github App authenticator")
+			return nil, err
+		}
+		log.Info().Msgf("[NewGithubService] Authenticate as Github App %s, installation ID: %s", config.Config.GithubAppID, config.Config.GithubAppInstallationID)
+	default:
+		log.Error().Msgf("[NewGithubService] Invalid GITHUB_AUTH_MODE: %s", authMode)
+		return nil, fmt.Errorf("invalid GITHUB_AUTH_MODE: %s", authMode)
 	}
 
 	return &GithubService{
 		client: cli,
-		authHeaders: map[string]string{
+		headers: map[string]string{
 			"Accept":               "application/vnd.github+json",
-			"Authorization":        "token " + pat,
 			"X-GitHub-Api-Version": "2022-11-28",
 		},
+		authenticator: authenticator,
+		retryPolicy:   retryPolicy,
+	}, nil
+}
+
+// Committer returns the committer to commit file changes as, or nil to commit as the authenticated identity.
+func (s *GithubService) Committer() *entity.Committer {
+	return s.authenticator.committer()
+}
+
+// requestHeaders returns the headers of a request to Github, including the Authorization header.
+func (s *GithubService) requestHeaders(ctx context.Context) (map[string]string, error) {
+	authorization, err := s.authenticator.authorization(ctx)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string)
+	maps.Copy(headers, s.headers)
+	headers["Authorization"] = authorization
+	return headers, nil
+}
+
+// newGithubRetryPolicy creates the retry policy of requests to Github from the configuration.
+// Besides 429 and 5xx responses, requests are also retried when the rate limit is exhausted,
+// after waiting until it resets, see https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api.
+func newGithubRetryPolicy() *http.RetryPolicy {
+	policy := http.DefaultRetryPolicy()
+	if config.Config.GithubRetryMaxAttempts > 0 {
+		policy.MaxAttempts = config.Config.GithubRetryMaxAttempts
+	} else {
+		log.Warn().Msgf("[newGithubRetryPolicy] Invalid max attempts: %d, fallback to %d", config.Config.GithubRetryMaxAttempts, policy.MaxAttempts)
+	}
+	if config.Config.GithubRetryBaseDelayMilliseconds > 0 {
+		policy.BaseDelay = time.Duration(config.Config.GithubRetryBaseDelayMilliseconds) * time.Millisecond
+	}
+	if config.Config.GithubRetryMaxDelaySeconds > 0 {
+		policy.MaxDelay = time.Duration(config.Config.GithubRetryMaxDelaySeconds) * time.Second
+	}
+	return policy
+}
+
+// newGithubCassette creates the cassette to record requests to Github in, or to replay them from, by the mode.
+func newGithubCassette(mode, path string) (*http.Cassette, error) {
+	if path == "" {
+		return nil, errors.New("GITHUB_CASSETTE_PATH is empty")
+	}
+	switch mode {
+	case http.CassetteModeRecord:
+		return http.NewRecordingCassette(path), nil
+	case http.CassetteModeReplay:
+		return http.LoadCassette(path)
+	default:
+		return nil, fmt.Errorf("invalid GITHUB_CASSETTE_MODE: %s", mode)
 	}
 }
 
-// GetFileContent retrieves the content of a file given its path.
+// GetFileContent retrieves the content of a file given its path, at the given ref (branch, tag or commit SHA).
+// If ref is empty, the default branch of the repository is used.
 // It returns the content and an error if any occurs.
-func (s *GithubService) GetFileContent(owner, repo, path string) (*entity.RepoContent, error) {
-	headers := make(map[string]string)
-	maps.Copy(headers, s.authHeaders)
+func (s *GithubService) GetFileContent(ctx context.Context, owner, repo, path, ref string) (*entity.RepoContent, error) {
+	headers, err := s.requestHeaders(ctx)
+	if err != nil {
+		log.Err(err).Msg("[GetFileContent] Failed to authenticate")
+		return nil, err
+	}
 	pathParams := map[string]string{
 		"owner": owner,
 		"repo":  repo,
 		// "path":  path,
 	}
 	queryParams := map[string]string{}
+	if ref != "" {
+		queryParams["ref"] = ref
+	}
 
 	// To avoid '/' in path being encoded, we need to put it in path in advance
-	_, _, respBytes, err := s.client.PerformGet(fmt.Sprintf("/repos/{owner}/{repo}/contents/%s", path), headers, pathParams, queryParams)
+	statusCode, _, respBodyBytes, err := s.client.PerformRequestWithRetry(ctx, fmt.Sprintf("/repos/{owner}/{repo}/contents/%s", path), consts.MethodGet, headers, pathParams, queryParams, nil, s.retryPolicy)
 	if err != nil {
 		log.Err(err).Msg("[GetFileContent] Failed to get file content")
 		return nil, err
 	}
+	if statusCode != consts.StatusOK {
+		log.Error().Msgf("[GetFileContent] Failed to get file content, status code: %d, response: %s", statusCode, string(respBodyBytes))
+		return nil, newGithubAPIError("get file content", statusCode, respBodyBytes)
+	}
 
 	var getFileContentResp entity.GetRepoContentResponse
-	err = sonic.Unmarshal(respBytes, &getFileContentResp)
+	err = sonic.Unmarshal(respBodyBytes, &getFileContentResp)
 	if err != nil {
 		log.Err(err).Msg("[GetFileContent] Failed to unmarshal response")
 		return nil, err
@@ -104,10 +343,13 @@ func (s *GithubService) GetFileContent(owner, repo, path string) (*entity.RepoCo
 }
 
 // CreateOrUpdateFileContent creates or updates the content of a file given its path.
-// It returns an error if any occurs.
-func (s *GithubService) CreateOrUpdateFileContent(owner, repo, path, message, content, sha, branch string, committer *entity.Committer) error {
-	headers := make(map[string]string)
-	maps.Copy(headers, s.authHeaders)
+// It returns the created commit and an error if any occurs.
+func (s *GithubService) CreateOrUpdateFileContent(ctx context.Context, owner, repo, path, message, content, sha, branch string, committer *entity.Committer) (*entity.CreateOrUpdateFileContentResponse, error) {
+	headers, err := s.requestHeaders(ctx)
+	if err != nil {
+		log.Err(err).Msg("[CreateOrUpdateFileContent] Failed to authenticate")
+		return nil, err
+	}
 	pathParams := map[string]string{
 		"owner": owner,
 		"repo":  repo,
@@ -128,19 +370,218 @@ func (s *GithubService) CreateOrUpdateFileContent(owner, repo, path, message, co
 	bodyBytes, err := sonic.Marshal(body)
 	if err != nil {
 		log.Error().Err(err).Msg("[CreateOrUpdateFileContent] Failed to marshal request body")
-		return err
+		return nil, err
 	}
 
-	statusCode, _, respBodyBytes, err := s.client.PerformRequest(fmt.Sprintf("/repos/{owner}/{repo}/contents/%s", path), consts.MethodPut, headers, pathParams, queryParams, bodyBytes)
+	statusCode, _, respBodyBytes, err := s.client.PerformRequestWithRetry(ctx, fmt.Sprintf("/repos/{owner}/{repo}/contents/%s", path), consts.MethodPut, headers, pathParams, queryParams, bodyBytes, s.retryPolicy)
 	if err != nil {
 		log.Error().Err(err).Msg("[CreateOrUpdateFileContent] Failed to create or update file content")
-		return err
+		return nil, err
 	}
-	if statusCode != consts.StatusOK {
+	// 200 for update, 201 for creation
+	if statusCode != consts.StatusOK && statusCode != consts.StatusCreated {
 		respBody := string(respBodyBytes)
 		log.Error().Err(err).Msgf("[CreateOrUpdateFileContent] Failed to create or update file content, status code: %d, response: %s", statusCode, respBody)
-		return fmt.Errorf("failed to create or update file content, status code: %d", statusCode)
+		return nil, newGithubAPIError(githubOperationUpdateFileContent, statusCode, respBodyBytes)
+	}
+
+	var createOrUpdateFileContentResp entity.CreateOrUpdateFileContentResponse
+	err = sonic.Unmarshal(respBodyBytes, &createOrUpdateFileContentResp)
+	if err != nil {
+		log.Err(err).Msg("[CreateOrUpdateFileContent] Failed to unmarshal response")
+		return nil, err
+	}
+
+	return &createOrUpdateFileContentResp, nil
+}
+
+// GetRef retrieves a reference given its name without "refs/" prefix, e.g. "heads/main".
+// It returns the reference and an error if any occurs.
+// See https://docs.github.com/en/rest/git/refs?apiVersion=2022-11-28#get-a-reference for more details.
+func (s *GithubService) GetRef(ctx context.Context, owner, repo, ref string) (*entity.GitRef, error) {
+	headers, err := s.requestHeaders(ctx)
+	if err != nil {
+		log.Err(err).Msg("[GetRef] Failed to authenticate")
+		return nil, err
+	}
+	pathParams := map[string]string{
+		"owner": owner,
+		"repo":  repo,
+	}
+	queryParams := map[string]string{}
+
+	// To avoid '/' in ref being encoded, we need to put it in path in advance
+	statusCode, _, respBodyBytes, err := s.client.PerformRequestWithRetry(ctx, fmt.Sprintf("/repos/{owner}/{repo}/git/ref/%s", ref), consts.MethodGet, headers, pathParams, queryParams, nil, s.retryPolicy)
+	if err != nil {
+		log.Err(err).Msg("[GetRef] Failed to get ref")
+		return nil, err
+	}
+	if statusCode != consts.StatusOK {
+		log.Error().Msgf("[GetRef] Failed to get ref, status code: %d, response: %s", statusCode, string(respBodyBytes))
+		return nil, newGithubAPIError("get ref", statusCode, respBodyBytes)
+	}
+
+	var gitRef entity.GitRef
+	err = sonic.Unmarshal(respBodyBytes, &gitRef)
+	if err != nil {
+		log.Err(err).Msg("[GetRef] Failed to unmarshal response")
+		return nil, err
+	}
+	return &gitRef, nil
+}
+
+// CreateRef creates a reference pointing to the given SHA, e.g. a new branch.
+// ref is the fully qualified name, e.g. "refs/heads/new-branch".
+// It returns the created reference and an error if any occurs.
+// See https://docs.github.com/en/rest/git/refs?apiVersion=2022-11-28#create-a-reference for more details.
+func (s *GithubService) CreateRef(ctx context.Context, owner, repo, ref, sha string) (*entity.GitRef, error) {
+	headers, err := s.requestHeaders(ctx)
+	if err != nil {
+		log.Err(err).Msg("[CreateRef] Failed to authenticate")
+		return nil, err
 	}
+	pathParams := map[string]string{
+		"owner": owner,
+		"repo":  repo,
+	}
+	queryParams := map[string]string{}
+
+	bodyBytes, err := sonic.Marshal(entity.CreateRefRequest{
+		Ref: ref,
+		SHA: sha,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("[CreateRef] Failed to marshal request body")
+		return nil, err
+	}
+
+	statusCode, _, respBodyBytes, err := s.client.PerformRequestWithRetry(ctx, "/repos/{owner}/{repo}/git/refs", consts.MethodPost, headers, pathParams, queryParams, bodyBytes, s.retryPolicy)
+	if err != nil {
+		log.Err(err).Msg("[CreateRef] Failed to create ref")
+		return nil, err
+	}
+	if statusCode != consts.StatusCreated {
+		log.Error().Msgf("[CreateRef] Failed to create ref, status code: %d, response: %s", statusCode, string(respBodyBytes))
+		return nil, newGithubAPIError("create ref", statusCode, respBodyBytes)
+	}
+
+	var gitRef entity.GitRef
+	err = sonic.Unmarshal(respBodyBytes, &gitRef)
+	if err != nil {
+		log.Err(err).Msg("[CreateRef] Failed to unmarshal response")
+		return nil, err
+	}
+	return &gitRef, nil
+}
+
+// DeleteRef deletes a reference given its name without "refs/" prefix, e.g. "heads/feature".
+// It returns an error if any occurs.
+// See https://docs.github.com/en/rest/git/refs?apiVersion=2022-11-28#delete-a-reference for more details.
+func (s *GithubService) DeleteRef(ctx context.Context, owner, repo, ref string) error {
+	headers, err := s.requestHeaders(ctx)
+	if err != nil {
+		log.Err(err).Msg("[DeleteRef] Failed to authenticate")
+		return err
+	}
+	pathParams := map[string]string{
+		"owner": owner,
+		"repo":  repo,
+	}
+	queryParams := map[string]string{}
 
+	// To avoid '/' in ref being encoded, we need to put it in path in advance
+	statusCode, _, respBodyBytes, err := s.client.PerformRequestWithRetry(ctx, fmt.Sprintf("/repos/{owner}/{repo}/git/refs/%s", ref), consts.MethodDelete, headers, pathParams, queryParams, nil, s.retryPolicy)
+	if err != nil {
+		log.Err(err).Msg("[DeleteRef] Failed to delete ref")
+		return err
+	}
+	if statusCode != consts.StatusNoContent {
+		log.Error().Msgf("[DeleteRef] Failed to delete ref, status code: %d, response: %s", statusCode, string(respBodyBytes))
+		return newGithubAPIError("delete ref", statusCode, respBodyBytes)
+	}
 	return nil
 }
+
+// CreatePullRequest creates a pull request merging head into base.
+// It returns the created pull request and an error if any occurs.
+// See https://docs.github.com/en/rest/pulls/pulls?apiVersion=2022-11-28#create-a-pull-request for more details.
+func (s *GithubService) CreatePullRequest(ctx context.Context, owner, repo, title, head, base, body string) (*entity.PullRequest, error) {
+	headers, err := s.requestHeaders(ctx)
+	if err != nil {
+		log.Err(err).Msg("[CreatePullRequest] Failed to authenticate")
+		return nil, err
+	}
+	pathParams := map[string]string{
+		"owner": owner,
+		"repo":  repo,
+	}
+	queryParams := map[string]string{}
+
+	bodyBytes, err := sonic.Marshal(entity.CreatePullRequestRequest{
+		Title: title,
+		Head:  head,
+		Base:  base,
+		Body:  body,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("[CreatePullRequest] Failed to marshal request body")
+		return nil, err
+	}
+
+	statusCode, _, respBodyBytes, err := s.client.PerformRequestWithRetry(ctx, "/repos/{owner}/{repo}/pulls", consts.MethodPost, headers, pathParams, queryParams, bodyBytes, s.retryPolicy)
+	if err != nil {
+		log.Err(err).Msg("[CreatePullRequest] Failed to create pull request")
+		return nil, err
+	}
+	if statusCode != consts.StatusCreated {
+		log.Error().Msgf("[CreatePullRequest] Failed to create pull request, status code: %d, response: %s", statusCode, string(respBodyBytes))
+		return nil, newGithubAPIError("create pull request", statusCode, respBodyBytes)
+	}
+
+	var pullRequest entity.PullRequest
+	err = sonic.Unmarshal(respBodyBytes, &pullRequest)
+	if err != nil {
+		log.Err(err).Msg("[CreatePullRequest] Failed to unmarshal response")
+		return nil, err
+	}
+	return &pullRequest, nil
+}
+
+// ListOpenPullRequests lists the open pull requests merging head into base.
+// head is the branch prefixed with its owner, e.g. "octocat:feature".
+// It returns the pull requests and an error if any occurs.
+// See https://docs.github.com/en/rest/pulls/pulls?apiVersion=2022-11-28#list-pull-requests for more details.
+func (s *GithubService) ListOpenPullRequests(ctx context.Context, owner, repo, head, base string) ([]*entity.PullRequest, error) {
+	headers, err := s.requestHeaders(ctx)
+	if err != nil {
+		log.Err(err).Msg("[ListOpenPullRequests] Failed to authenticate")
+		return nil, err
+	}
+	pathParams := map[string]string{
+		"owner": owner,
+		"repo":  repo,
+	}
+	queryParams := map[string]string{
+		"state": "open",
+		"head":  head,
+		"base":  base,
+	}
+
+	statusCode, _, respBodyBytes, err := s.client.PerformRequestWithRetry(ctx, "/repos/{owner}/{repo}/pulls", consts.MethodGet, headers, pathParams, queryParams, nil, s.retryPolicy)
+	if err != nil {
+		log.Err(err).Msg("[ListOpenPullRequests] Failed to list pull requests")
+		return nil, err
+	}
+	if statusCode != consts.StatusOK {
+		log.Error().Msgf("[ListOpenPullRequests] Failed to list pull requests, status code: %d, response: %s", statusCode, string(respBodyBytes))
+		return nil, newGithubAPIError("list pull requests", statusCode, respBodyBytes)
+	}
+
+	var pullRequests []*entity.PullRequest
+	err = sonic.Unmarshal(respBodyBytes, &pullRequests)
+	if err != nil {
+		log.Err(err).Msg("[ListOpenPullRequests] Failed to unmarshal response")
+		return nil, err
+	}
+	return pullRequests, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/pkg"
+	"dantaautotool/pkg/utils/http"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// useGithubTestConfig replaces the configuration with one for testing GithubService against baseURL,
+// and restores the original configuration when the test ends.
+func useGithubTestConfig(t *testing.T, baseURL string) {
+	t.Helper()
+	original := config.Config
+	t.Cleanup(func() {
+		config.Config = original
+	})
+	config.Config = config.GlobalConfig{
+		GithubAPIBaseURL:                  baseURL,
+		GithubAuthMode:                    pkg.GITHUB_AUTH_MODE_PAT,
+		GithubPersonalAccessToken:         "ghp_test",
+		GithubDanxiRepoOwner:              "DanXi-Dev",
+		GithubDanxiRepoName:               "DanXi-Static",
+		GithubDanxiRepoAppConfigPath:      "public/tmp_wait_for_json_editor.toml",
+		GithubDanxiRepoBranch:             "main",
+		GithubConflictMaxAttempts:         3,
+		GithubRetryMaxAttempts:            1,
+		GithubRequestTimeoutSeconds:       5,
+		GithubTLSMinVersion:               "1.2",
+		GithubAppTokenRefreshAheadSeconds: 300,
+	}
+}
+
+func TestGithubServiceReplayFileContent(t *testing.T) {
+	useGithubTestConfig(t, "https://api.github.com")
+	config.Config.GithubCassetteMode = http.CassetteModeReplay
+	config.Config.GithubCassettePath = "testdata/github_contents_cassette.json"
+	githubService, err := NewGithubService()
+	if err != nil {
+		t.Fatalf("NewGithubService() error = %v", err)
+	}
+	ctx := context.Background()
+	owner, repo, path := "DanXi-Dev", "DanXi-Static", "public/tmp_wait_for_json_editor.toml"
+	committer := &entity.Committer{Name: "Danta Auto Tool", Email: "danta@example.com"}
+
+	content, err := githubService.GetFileContent(ctx, owner, repo, path, "main")
+	if err != nil {
+		t.Fatalf("GetFileContent() error = %v", err)
+	}
+	if !strings.Contains(content.DecodedContent, `title = "Welcome"`) || content.SHA == "" {
+		t.Fatalf("GetFileContent() = %q, SHA %q", content.DecodedContent, content.SHA)
+	}
+
+	newContent := content.DecodedContent + "\n[[banners]]\ntitle = \"New\"\naction = \"https://example.com\"\nbutton = \"Go\"\n"
+	commit, err := githubService.CreateOrUpdateFileContent(ctx, owner, repo, path, `Add banner "New"`, newContent, content.SHA, "main", committer)
+	if err != nil {
+		t.Fatalf("CreateOrUpdateFileContent() error = %v", err)
+	}
+	if commit.Commit.SHA == "" || commit.Commit.Message != `Add banner "New"` {
+		t.Errorf("CreateOrUpdateFileContent() commit = %+v", commit.Commit)
+	}
+
+	updated, err := githubService.GetFileContent(ctx, owner, repo, path, "main")
+	if err != nil {
+		t.Fatalf("GetFileContent() after update error = %v", err)
+	}
+	if updated.DecodedContent != newContent || updated.SHA == content.SHA {
+		t.Errorf("GetFileContent() after update = %q, SHA %q", updated.DecodedContent, updated.SHA)
+	}
+
+	// the old SHA is stale after the update
+	_, err = githubService.CreateOrUpdateFileContent(ctx, owner, repo, path, `Add banner "Stale"`, "stale", content.SHA, "main", committer)
+	if !IsGithubConflictError(err) {
+		t.Errorf("CreateOrUpdateFileContent() with stale SHA error = %v, want a conflict", err)
+	}
+
+	// requests not in the cassette are never sent
+	_, err = githubService.GetFileContent(ctx, owner, repo, path, "dev")
+	if !errors.Is(err, http.ErrCassetteInteractionNotFound) {
+		t.Errorf("GetFileContent() of an unrecorded ref error = %v, want %v", err, http.ErrCassetteInteractionNotFound)
+	}
+}
+
+func TestNewGithubServiceInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *config.GlobalConfig)
+	}{
+		{"invalid auth mode", func(cfg *config.GlobalConfig) { cfg.GithubAuthMode = "password" }},
+		{"empty PAT", func(cfg *config.GlobalConfig) { cfg.GithubPersonalAccessToken = "" }},
+		{"missing app private key", func(cfg *config.GlobalConfig) {
+			cfg.GithubAuthMode = pkg.GITHUB_AUTH_MODE_APP
+			cfg.GithubAppID, cfg.GithubAppInstallationID = "1", "2"
+			cfg.GithubAppPrivateKeyFile = "testdata/missing.pem"
+		}},
+		{"invalid TLS version", func(cfg *config.GlobalConfig) { cfg.GithubTLSMinVersion = "2.0" }},
+		{"invalid proxy", func(cfg *config.GlobalConfig) { cfg.GithubProxyURL = "ftp://proxy" }},
+		{"missing cassette", func(cfg *config.GlobalConfig) {
+			cfg.GithubCassetteMode = http.CassetteModeReplay
+			cfg.GithubCassettePath = "testdata/missing.json"
+		}},
+		{"missing middleware script", func(cfg *config.GlobalConfig) { cfg.GithubMiddlewareScriptPath = "testdata/missing.star" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useGithubTestConfig(t, "https://api.github.com")
+			tt.modify(&config.Config)
+			githubService, err := NewGithubService()
+			if err == nil || githubService != nil {
+				t.Errorf("NewGithubService() = %v, %v, want an error", githubService, err)
+			}
+		})
+	}
+}
+
+func TestIsGithubConflictError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"conflict", newGithubAPIError("create ref", consts.StatusConflict, nil), true},
+		{"unprocessable file update", newGithubAPIError(githubOperationUpdateFileContent, consts.StatusUnprocessableEntity, nil), true},
+		{"pull request exists", newGithubAPIError("create pull request", consts.StatusUnprocessableEntity, nil), false},
+		{"wrapped conflict", fmt.Errorf("failed: %w", newGithubAPIError(githubOperationUpdateFileContent, consts.StatusConflict, nil)), true},
+		{"not found", newGithubAPIError("get ref", consts.StatusNotFound, nil), false},
+		{"other error", errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsGithubConflictError(tt.err); got != tt.want {
+				t.Errorf("IsGithubConflictError(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
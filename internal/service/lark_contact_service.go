@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"dantaautotool/pkg/utils/http"
+	"fmt"
+
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// LarkContactServiceIntf defines the interface for LarkContactService.
+type LarkContactServiceIntf interface {
+	// GetUserName retrieves the name of a user given its open ID.
+	// It returns the name as a string and an error if any occurs.
+	GetUserName(ctx context.Context, openID string) (string, error)
+}
+
+// LarkContactService provides methods to interact with Lark contacts.
+type LarkContactService struct {
+	client *lark.Client
+}
+
+// NewLarkContactService creates a new instance of LarkContactService.
+func NewLarkContactService() *LarkContactService {
+	return &LarkContactService{
+		client: http.LarkClient,
+	}
+}
+
+// GetUserName retrieves the name of a user given its open ID.
+// It returns the name as a string and an error if any occurs.
+// See https://open.feishu.cn/document/server-docs/contact-v3/user/get for more details.
+func (s *LarkContactService) GetUserName(ctx context.Context, openID string) (string, error) {
+	req := larkcontact.NewGetUserReqBuilder().
+		UserId(openID).
+		UserIdType(larkcontact.UserIdTypeOpenId).
+		Build()
+	resp, err := s.client.Contact.V3.User.Get(ctx, req)
+	if err != nil {
+		log.Err(err).Msg("[LarkContactService.GetUserName] Failed to get user")
+		return "", err
+	}
+	if !resp.Success() {
+		log.Error().Msgf("[LarkContactService.GetUserName] Failed to get user: %s", resp.Msg)
+		return "", fmt.Errorf("failed to get user: %s", resp.Msg)
+	}
+	if resp.Data == nil || resp.Data.User == nil || resp.Data.User.Name == nil {
+		return "", fmt.Errorf("user data is nil for openID: %s", openID)
+	}
+	return *resp.Data.User.Name, nil
+}
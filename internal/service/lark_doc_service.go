@@ -15,14 +15,22 @@ import (
 type LarkDocServiceIntf interface {
 	// GetDocumentTitle retrieves the title of a document given its ID.
 	// It returns the title as a string and an error if any occurs.
-	GetDocumentTitle(documentID string) (string, error)
+	GetDocumentTitle(ctx context.Context, documentID string) (string, error)
 
 	// BatchQueryBitableRecords retrieves records from Bitable given a list of record IDs.
 	// It returns a slice of pointers to larkbitable.AppTableRecord and an error if any occurs.
-	BatchQueryBitableRecords(appToken, tableID string, recordIDs []string) ([]*larkbitable.AppTableRecord, error)
+	BatchQueryBitableRecords(ctx context.Context, appToken, tableID string, recordIDs []string) ([]*larkbitable.AppTableRecord, error)
 
 	// AddBitableRecord adds a record to a Bitable.
-	AddBitableRecord(appToken, tableID string, fields map[string]interface{}) error
+	// It returns the ID of the added record and an error if any occurs.
+	AddBitableRecord(ctx context.Context, appToken, tableID string, fields map[string]interface{}) (string, error)
+
+	// SearchBitableRecords retrieves records from Bitable whose field fieldName equals to value.
+	// It returns a slice of pointers to larkbitable.AppTableRecord and an error if any occurs.
+	SearchBitableRecords(ctx context.Context, appToken, tableID, fieldName, value string) ([]*larkbitable.AppTableRecord, error)
+
+	// UpdateBitableRecord updates the given fields of a record in a Bitable.
+	UpdateBitableRecord(ctx context.Context, appToken, tableID, recordID string, fields map[string]interface{}) error
 }
 
 // LarkDocService provides methods to interact with Lark documents.
@@ -39,12 +47,12 @@ func NewLarkDocService() *LarkDocService {
 
 // getBasicInfo retrieves the basic information of a document given its ID.
 // It returns a pointer to larkdocx.GetDocumentRespData and an error if any occurs.
-func (s *LarkDocService) getBasicInfo(documentID string) (*larkdocx.GetDocumentRespData, error) {
+func (s *LarkDocService) getBasicInfo(ctx context.Context, documentID string) (*larkdocx.GetDocumentRespData, error) {
 	// 创建请求对象
 	req := larkdocx.NewGetDocumentReqBuilder().
 		DocumentId(documentID).
 		Build()
-	resp, err := s.client.Docx.V1.Document.Get(context.Background(), req)
+	resp, err := s.client.Docx.V1.Document.Get(ctx, req)
 	if err != nil {
 		log.Error().Err(err).Msg("[getBasicInfo] Failed to get document")
 		return nil, err
@@ -58,8 +66,8 @@ func (s *LarkDocService) getBasicInfo(documentID string) (*larkdocx.GetDocumentR
 
 // GetDocumentTitle retrieves the title of a document given its ID.
 // It returns the title as a string and an error if any occurs.
-func (s *LarkDocService) GetDocumentTitle(documentID string) (string, error) {
-	data, err := s.getBasicInfo(documentID)
+func (s *LarkDocService) GetDocumentTitle(ctx context.Context, documentID string) (string, error) {
+	data, err := s.getBasicInfo(ctx, documentID)
 	if err != nil {
 		return "", err
 	}
@@ -71,7 +79,7 @@ func (s *LarkDocService) GetDocumentTitle(documentID string) (string, error) {
 
 // BatchQueryBitableRecords retrieves records from Bitable given a list of record IDs.
 // It returns a slice of pointers to larkbitable.AppTableRecord and an error if any occurs.
-func (s *LarkDocService) BatchQueryBitableRecords(appToken, tableID string, recordIDs []string) ([]*larkbitable.AppTableRecord, error) {
+func (s *LarkDocService) BatchQueryBitableRecords(ctx context.Context, appToken, tableID string, recordIDs []string) ([]*larkbitable.AppTableRecord, error) {
 	if len(recordIDs) == 0 {
 		return nil, nil
 	}
@@ -86,7 +94,7 @@ func (s *LarkDocService) BatchQueryBitableRecords(appToken, tableID string, reco
 			Build()).
 		Build()
 
-	resp, err := s.client.Bitable.V1.AppTableRecord.BatchGet(context.Background(), req)
+	resp, err := s.client.Bitable.V1.AppTableRecord.BatchGet(ctx, req)
 
 	if err != nil {
 		log.Error().Err(err).Msg("[BatchQueryBitableRecords] Failed to batch query records")
@@ -122,8 +130,40 @@ func getFieldTextValueFromRecord(record *larkbitable.AppTableRecord, fieldKey st
 	return fieldValue, nil
 }
 
+// getFieldOptionValueFromRecord retrieves the value of a single option field from a record.
+// It returns the option as a string, or an empty string if the field is empty, and an error if any occurs.
+func getFieldOptionValueFromRecord(record *larkbitable.AppTableRecord, fieldKey string) (string, error) {
+	fieldValue, ok := record.Fields[fieldKey]
+	if !ok || fieldValue == nil {
+		return "", nil
+	}
+	option, ok := fieldValue.(string)
+	if !ok {
+		log.Error().Msgf("[GetFieldOptionValueFromRecord] Invalid format for field key: %s", fieldKey)
+		return "", fmt.Errorf("invalid format for field key: %s", fieldKey)
+	}
+	return option, nil
+}
+
+// getFieldDateValueFromRecord retrieves the value of a date field from a record.
+// It returns the date as a Unix timestamp in milliseconds, or 0 if the field is empty, and an error if any occurs.
+func getFieldDateValueFromRecord(record *larkbitable.AppTableRecord, fieldKey string) (int64, error) {
+	fieldValue, ok := record.Fields[fieldKey]
+	if !ok || fieldValue == nil {
+		return 0, nil
+	}
+	// Numbers are decoded as float64 from JSON
+	timestamp, ok := fieldValue.(float64)
+	if !ok {
+		log.Error().Msgf("[GetFieldDateValueFromRecord] Invalid format for field key: %s", fieldKey)
+		return 0, fmt.Errorf("invalid format for field key: %s", fieldKey)
+	}
+	return int64(timestamp), nil
+}
+
 // AddBitableRecord adds a record to a Bitable.
-func (s *LarkDocService) AddBitableRecord(appToken, tableID string, fields map[string]interface{}) error {
+// It returns the ID of the added record and an error if any occurs.
+func (s *LarkDocService) AddBitableRecord(ctx context.Context, appToken, tableID string, fields map[string]interface{}) (string, error) {
 	req := larkbitable.NewCreateAppTableRecordReqBuilder().
 		AppToken(appToken).
 		TableId(tableID).
@@ -131,16 +171,87 @@ func (s *LarkDocService) AddBitableRecord(appToken, tableID string, fields map[s
 			Fields(fields).
 			Build()).
 		Build()
-	resp, err := s.client.Bitable.V1.AppTableRecord.Create(context.Background(), req)
+	resp, err := s.client.Bitable.V1.AppTableRecord.Create(ctx, req)
 
 	if err != nil {
 		log.Err(err).Msg("[LarkDocService.AddBitableRecord] Failed to create record")
-		return err
+		return "", err
 	}
 
 	if !resp.Success() {
 		log.Error().Msgf("[LarkDocService.AddBitableRecord] Failed to add record: %s", resp.CodeError)
-		return fmt.Errorf("failed to add record: %s", resp.CodeError)
+		return "", fmt.Errorf("failed to add record: %s", resp.CodeError)
+	}
+	if resp.Data == nil || resp.Data.Record == nil || resp.Data.Record.RecordId == nil {
+		log.Error().Msg("[LarkDocService.AddBitableRecord] Record ID is missing in the response")
+		return "", fmt.Errorf("record ID is missing in the response")
+	}
+	return *resp.Data.Record.RecordId, nil
+}
+
+// SearchBitableRecords retrieves records from Bitable whose field fieldName equals to value.
+// It returns a slice of pointers to larkbitable.AppTableRecord and an error if any occurs.
+// See https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/bitable-v1/app-table-record/search for more details.
+func (s *LarkDocService) SearchBitableRecords(ctx context.Context, appToken, tableID, fieldName, value string) ([]*larkbitable.AppTableRecord, error) {
+	req := larkbitable.NewSearchAppTableRecordReqBuilder().
+		AppToken(appToken).
+		TableId(tableID).
+		UserIdType(`open_id`).
+		Body(larkbitable.NewSearchAppTableRecordReqBodyBuilder().
+			Filter(larkbitable.NewFilterInfoBuilder().
+				Conjunction(`and`).
+				Conditions([]*larkbitable.Condition{
+					larkbitable.NewConditionBuilder().
+						FieldName(fieldName).
+						Operator(`is`).
+						Value([]string{value}).
+						Build(),
+				}).
+				Build()).
+			AutomaticFields(false).
+			Build()).
+		Build()
+
+	records := make([]*larkbitable.AppTableRecord, 0)
+	iterator, err := s.client.Bitable.V1.AppTableRecord.SearchByIterator(ctx, req)
+	if err != nil {
+		log.Err(err).Msg("[LarkDocService.SearchBitableRecords] Failed to search records")
+		return nil, err
+	}
+	for {
+		hasNext, record, err := iterator.Next()
+		if err != nil {
+			log.Err(err).Msg("[LarkDocService.SearchBitableRecords] Failed to search records")
+			return nil, err
+		}
+		if !hasNext {
+			break
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// UpdateBitableRecord updates the given fields of a record in a Bitable.
+func (s *LarkDocService) UpdateBitableRecord(ctx context.Context, appToken, tableID, recordID string, fields map[string]interface{}) error {
+	req := larkbitable.NewUpdateAppTableRecordReqBuilder().
+		AppToken(appToken).
+		TableId(tableID).
+		RecordId(recordID).
+		AppTableRecord(larkbitable.NewAppTableRecordBuilder().
+			Fields(fields).
+			Build()).
+		Build()
+	resp, err := s.client.Bitable.V1.AppTableRecord.Update(ctx, req)
+
+	if err != nil {
+		log.Err(err).Msg("[LarkDocService.UpdateBitableRecord] Failed to update record")
+		return err
+	}
+
+	if !resp.Success() {
+		log.Error().Msgf("[LarkDocService.UpdateBitableRecord] Failed to update record: %s", resp.CodeError)
+		return fmt.Errorf("failed to update record: %s", resp.CodeError)
 	}
 	return nil
 }
@@ -5,7 +5,6 @@ import (
 	"dantaautotool/pkg/utils/http"
 	"fmt"
 	"net/mail"
-	"os"
 
 	lark "github.com/larksuite/oapi-sdk-go/v3"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
@@ -27,7 +26,7 @@ type LarkEmailServiceIntf interface {
 	//
 	// Returns:
 	// - error: An error if the email could not be sent, otherwise nil.
-	SendEmailSimple(me, subject, toMail, toName, meName, bodyPlainText string) error
+	SendEmailSimple(ctx context.Context, me, subject, toMail, toName, meName, bodyPlainText string) error
 
 	// SendEmail sends an email to the specified email address.
 	//
@@ -45,18 +44,22 @@ type LarkEmailServiceIntf interface {
 	// - error: An error if the email could not be sent, otherwise nil.
 	//
 	// See https://open.feishu.cn/document/server-docs/mail-v1/user_mailbox-message/send for more details.
-	SendEmail(me, subject string, to, cc, bcc []*larkmail.MailAddress, headFrom *larkmail.MailAddress, bodyHtml, bodyPlainText string) error
+	SendEmail(ctx context.Context, me, subject string, to, cc, bcc []*larkmail.MailAddress, headFrom *larkmail.MailAddress, bodyHtml, bodyPlainText string) error
 }
 
 // LarkEmailService provides methods to interact with Lark IM.
 type LarkEmailService struct {
 	client *lark.Client
+
+	// larkUserTokenManager provides the user access token to send emails
+	larkUserTokenManager LarkUserTokenManagerIntf
 }
 
 // NewLarkEmailService creates a new instance of LarkEmailService.
-func NewLarkEmailService() *LarkEmailService {
+func NewLarkEmailService(larkUserTokenManager LarkUserTokenManagerIntf) *LarkEmailService {
 	return &LarkEmailService{
-		client: http.LarkClient,
+		client:               http.LarkClient,
+		larkUserTokenManager: larkUserTokenManager,
 	}
 }
 
@@ -72,10 +75,10 @@ func NewLarkEmailService() *LarkEmailService {
 //
 // Returns:
 // - error: An error if the email could not be sent, otherwise nil.
-func (s *LarkEmailService) SendEmailSimple(me, subject, toMail, toName, meName, bodyPlainText string) error {
+func (s *LarkEmailService) SendEmailSimple(ctx context.Context, me, subject, toMail, toName, meName, bodyPlainText string) error {
 	toMailAddr := larkmail.NewMailAddressBuilder().MailAddress(toMail).Name(toName).Build()
 	meMailAddr := larkmail.NewMailAddressBuilder().MailAddress(me).Name(meName).Build()
-	return s.SendEmail(me, subject, []*larkmail.MailAddress{toMailAddr}, nil, nil, meMailAddr, "", bodyPlainText)
+	return s.SendEmail(ctx, me, subject, []*larkmail.MailAddress{toMailAddr}, nil, nil, meMailAddr, "", bodyPlainText)
 }
 
 // SendEmail sends an email to the specified email address.
@@ -94,10 +97,11 @@ func (s *LarkEmailService) SendEmailSimple(me, subject, toMail, toName, meName,
 // - error: An error if the email could not be sent, otherwise nil.
 //
 // See https://open.feishu.cn/document/server-docs/mail-v1/user_mailbox-message/send for more details.
-func (s *LarkEmailService) SendEmail(me, subject string, to, cc, bcc []*larkmail.MailAddress, headFrom *larkmail.MailAddress, bodyHtml, bodyPlainText string) error {
-	userAccessToken := os.Getenv("LARK_USER_ACCESS_TOKEN")
-	if userAccessToken == "" {
-		log.Warn().Msg("[LarkEmailService.SendEmail] LARK_USER_ACCESS_TOKEN is empty")
+func (s *LarkEmailService) SendEmail(ctx context.Context, me, subject string, to, cc, bcc []*larkmail.MailAddress, headFrom *larkmail.MailAddress, bodyHtml, bodyPlainText string) error {
+	userAccessToken, err := s.larkUserTokenManager.AccessToken(ctx)
+	if err != nil {
+		log.Err(err).Msg("[LarkEmailService.SendEmail] Failed to get user access token")
+		return err
 	}
 
 	if me == "" {
@@ -125,7 +129,7 @@ func (s *LarkEmailService) SendEmail(me, subject string, to, cc, bcc []*larkmail
 		Build()
 
 	resp, err := s.client.Mail.V1.UserMailboxMessage.Send(
-		context.Background(),
+		ctx,
 		req,
 		larkcore.WithUserAccessToken(userAccessToken),
 	)
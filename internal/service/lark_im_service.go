@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"dantaautotool/config"
 	"dantaautotool/pkg/utils/http"
+	"fmt"
 
 	"github.com/bytedance/sonic"
 	lark "github.com/larksuite/oapi-sdk-go/v3"
@@ -16,11 +18,23 @@ type LarkIMServiceIntf interface {
 
 	// SendCardMessageByTemplate sends a card message to a chat given its ID.
 	// It returns an error if any occurs.
-	SendCardMessageByTemplate(receiveIdType, receiveID string, templateCardID string, templateVariables map[string]interface{}) error
+	SendCardMessageByTemplate(ctx context.Context, receiveIdType, receiveID string, templateCardID string, templateVariables map[string]interface{}) error
+
+	// UpdateCardMessageByTemplate replaces the content of a card message given its ID with a template card.
+	// It returns an error if any occurs.
+	UpdateCardMessageByTemplate(ctx context.Context, messageID string, templateCardID string, templateVariables map[string]interface{}) error
 
 	// SendMessage sends a message to a chat given its ID.
 	// It returns an error if any occurs.
-	SendMessage(receiveIdType, receiveID, content string) error
+	SendMessage(ctx context.Context, receiveIdType, receiveID, content string) error
+
+	// SendTextMessage sends a text message to a chat given its ID.
+	// It returns an error if any occurs.
+	SendTextMessage(ctx context.Context, receiveIdType, receiveID, text string) error
+
+	// ReplyTextMessage replies a text message to a message given its ID, in the thread of the message.
+	// It returns an error if any occurs.
+	ReplyTextMessage(ctx context.Context, messageID, text string) error
 }
 
 // LarkIMService provides methods to interact with Lark IM.
@@ -37,7 +51,7 @@ func NewLarkIMService() *LarkIMService {
 
 // SendCardMessageByTemplate sends a card message to a chat given its ID.
 // It returns an error if any occurs.
-func (s *LarkIMService) SendCardMessageByTemplate(receiveIdType, receiveID string, templateCardID string, templateVariables map[string]interface{}) error {
+func (s *LarkIMService) SendCardMessageByTemplate(ctx context.Context, receiveIdType, receiveID string, templateCardID string, templateVariables map[string]interface{}) error {
 	card := &callback.Card{
 		Type: "template",
 		Data: &callback.TemplateCard{
@@ -52,7 +66,7 @@ func (s *LarkIMService) SendCardMessageByTemplate(receiveIdType, receiveID strin
 		return err
 	}
 
-	err = s.SendMessage(receiveIdType, receiveID, content)
+	err = s.SendMessage(ctx, receiveIdType, receiveID, content)
 	if err != nil {
 		log.Err(err).Msg("[LarkIMService] Failed to send card message")
 		return err
@@ -61,11 +75,47 @@ func (s *LarkIMService) SendCardMessageByTemplate(receiveIdType, receiveID strin
 	return nil
 }
 
+// UpdateCardMessageByTemplate replaces the content of a card message given its ID with a template card.
+// It is used to update a card after its callback has been answered, and only shared cards can be updated this way.
+// It returns an error if any occurs.
+// See https://open.feishu.cn/document/server-docs/im-v1/message-card/patch for more details.
+func (s *LarkIMService) UpdateCardMessageByTemplate(ctx context.Context, messageID string, templateCardID string, templateVariables map[string]interface{}) error {
+	card := &callback.Card{
+		Type: "template",
+		Data: &callback.TemplateCard{
+			TemplateID:       templateCardID,
+			TemplateVariable: templateVariables,
+		},
+	}
+
+	content, err := sonic.MarshalString(card)
+	if err != nil {
+		log.Err(err).Msg("[LarkIMService] Failed to marshal card")
+		return err
+	}
+
+	resp, err := s.client.Im.Message.Patch(ctx, larkim.NewPatchMessageReqBuilder().
+		MessageId(messageID).
+		Body(larkim.NewPatchMessageReqBodyBuilder().
+			Content(content).
+			Build()).
+		Build())
+	if err != nil {
+		log.Err(err).Msg("[LarkIMService] Failed to update card message")
+		return err
+	}
+	if !resp.Success() {
+		log.Error().Msgf("[LarkIMService] Failed to update card message: %s", resp.Error())
+		return fmt.Errorf("failed to update card message: %s", resp.Error())
+	}
+	return nil
+}
+
 // SendMessage sends a message to a chat given its ID.
 // It returns an error if any occurs.
 // See https://open.feishu.cn/document/server-docs/im-v1/message/create for more details.
-func (s *LarkIMService) SendMessage(receiveIdType, receiveID, content string) error {
-	resp, err := s.client.Im.Message.Create(context.Background(), larkim.NewCreateMessageReqBuilder().
+func (s *LarkIMService) SendMessage(ctx context.Context, receiveIdType, receiveID, content string) error {
+	resp, err := s.client.Im.Message.Create(ctx, larkim.NewCreateMessageReqBuilder().
 		ReceiveIdType(receiveIdType).
 		Body(larkim.NewCreateMessageReqBodyBuilder().
 			MsgType(larkim.MsgTypeInteractive).
@@ -84,3 +134,63 @@ func (s *LarkIMService) SendMessage(receiveIdType, receiveID, content string) er
 	}
 	return nil
 }
+
+// SendTextMessage sends a text message to a chat given its ID.
+// It returns an error if any occurs.
+// See https://open.feishu.cn/document/server-docs/im-v1/message/create for more details.
+func (s *LarkIMService) SendTextMessage(ctx context.Context, receiveIdType, receiveID, text string) error {
+	content := larkim.NewTextMsgBuilder().
+		Text(text).
+		Build()
+	resp, err := s.client.Im.Message.Create(ctx, larkim.NewCreateMessageReqBuilder().
+		ReceiveIdType(receiveIdType).
+		Body(larkim.NewCreateMessageReqBodyBuilder().
+			MsgType(larkim.MsgTypeText).
+			ReceiveId(receiveID).
+			Content(content).
+			Build()).
+		Build())
+	if err != nil {
+		log.Err(err).Msg("[LarkIMService] Failed to send text message")
+		return err
+	}
+	if !resp.Success() {
+		log.Error().Msgf("[LarkIMService] Failed to send text message: %s", resp.Error())
+		return fmt.Errorf("failed to send text message: %s", resp.Error())
+	}
+	return nil
+}
+
+// ReplyTextMessage replies a text message to a message given its ID, in the thread of the message.
+// It returns an error if any occurs.
+// See https://open.feishu.cn/document/server-docs/im-v1/message/reply for more details.
+func (s *LarkIMService) ReplyTextMessage(ctx context.Context, messageID, text string) error {
+	content := larkim.NewTextMsgBuilder().
+		Text(text).
+		Build()
+	resp, err := s.client.Im.Message.Reply(ctx, larkim.NewReplyMessageReqBuilder().
+		MessageId(messageID).
+		Body(larkim.NewReplyMessageReqBodyBuilder().
+			MsgType(larkim.MsgTypeText).
+			Content(content).
+			ReplyInThread(true).
+			Build()).
+		Build())
+	if err != nil {
+		log.Err(err).Msg("[LarkIMService] Failed to reply message")
+		return err
+	}
+	if !resp.Success() {
+		log.Error().Msgf("[LarkIMService] Failed to reply message: %s", resp.Error())
+		return fmt.Errorf("failed to reply message: %s", resp.Error())
+	}
+	return nil
+}
+
+// AdminGroupID returns the ID of the group receiving alerts, which falls back to the approve group if not configured.
+func AdminGroupID() string {
+	if config.Config.LarkAdminGroupID != "" {
+		return config.Config.LarkAdminGroupID
+	}
+	return config.Config.LarkBannerApproveGroupID
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/internal/repository"
+	"dantaautotool/pkg/utils/http"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkauthen "github.com/larksuite/oapi-sdk-go/v3/service/authen/v1"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// larkUserTokenCheckInterval is the interval between two checks of whether the user access token should be refreshed
+	larkUserTokenCheckInterval = time.Minute
+
+	// larkUserTokenRefreshTimeout is the timeout of a refresh in a periodic check
+	larkUserTokenRefreshTimeout = 30 * time.Second
+)
+
+// LarkUserTokenManagerIntf defines the interface for LarkUserTokenManager.
+type LarkUserTokenManagerIntf interface {
+	// AccessToken returns the user access token, refreshing it first if it is about to expire.
+	AccessToken(ctx context.Context) (string, error)
+
+	// Start starts refreshing the user access token periodically in background.
+	Start()
+}
+
+// LarkUserTokenManager keeps the Lark user access token used to send emails valid.
+//
+// The access token expires in about two hours, so it is refreshed by the refresh token before it expires,
+// see https://open.feishu.cn/document/server-docs/authentication-management/access-token/oidc-refresh-access-token.
+// A refresh token can only be used once, so every refreshed pair is persisted in the repository at once.
+// The admin group is alerted when a refresh fails, since emails cannot be sent after the access token expires.
+type LarkUserTokenManager struct {
+	client *lark.Client
+
+	// larkIMService is used to alert the admin group
+	larkIMService LarkIMServiceIntf
+
+	// larkUserTokenRepository is used to persist the token
+	larkUserTokenRepository repository.LarkUserTokenRepositoryIntf
+
+	// refreshAhead is how long before the access token expires it is refreshed
+	refreshAhead time.Duration
+
+	// mu protects token and alerted, and serializes refreshes, so that a refresh token is never used twice
+	mu sync.Mutex
+
+	// token is the current token
+	token *entity.LarkUserToken
+
+	// alerted indicates whether the admin group has been alerted since the last successful refresh
+	alerted bool
+}
+
+// NewLarkUserTokenManager creates a new instance of LarkUserTokenManager.
+//
+// The token is loaded from the repository. It is seeded from the configuration on first run,
+// or when the configured refresh token changes, which means the user has authorized again.
+func NewLarkUserTokenManager(larkIMService LarkIMServiceIntf, larkUserTokenRepository repository.LarkUserTokenRepositoryIntf) (*LarkUserTokenManager, error) {
+	refreshAheadSeconds := config.Config.LarkUserTokenRefreshAheadSeconds
+	if refreshAheadSeconds <= 0 {
+		log.Warn().Msgf("[NewLarkUserTokenManager] Invalid refresh ahead seconds: %d, fallback to 600", refreshAheadSeconds)
+		refreshAheadSeconds = 600
+	}
+
+	token, err := larkUserTokenRepository.Get()
+	if err != nil {
+		log.Err(err).Msg("[NewLarkUserTokenManager] Failed to load user token")
+		return nil, err
+	}
+	seedRefreshToken := config.Config.LarkUserRefreshToken
+	if token == nil || (seedRefreshToken != "" && seedRefreshToken != token.SeedRefreshToken) {
+		token = &entity.LarkUserToken{
+			AccessToken:      config.Config.LarkUserAccessToken,
+			RefreshToken:     seedRefreshToken,
+			SeedRefreshToken: seedRefreshToken,
+		}
+		if err := larkUserTokenRepository.Save(token); err != nil {
+			log.Err(err).Msg("[NewLarkUserTokenManager] Failed to save user token")
+			return nil, err
+		}
+		log.Info().Msg("[NewLarkUserTokenManager] User token seeded from configuration")
+	}
+	if token.RefreshToken == "" {
+		log.Warn().Msg("[NewLarkUserTokenManager] LARK_USER_REFRESH_TOKEN is empty, the user access token will not be refreshed")
+	}
+
+	return &LarkUserTokenManager{
+		client:                  http.LarkClient,
+		larkIMService:           larkIMService,
+		larkUserTokenRepository: larkUserTokenRepository,
+		refreshAhead:            time.Duration(refreshAheadSeconds) * time.Second,
+		token:                   token,
+	}, nil
+}
+
+// AccessToken returns the user access token, refreshing it first if it is about to expire.
+// If the refresh fails but the current access token has not expired yet, the current one is returned.
+func (m *LarkUserTokenManager) AccessToken(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.shouldRefresh(now) {
+		if err := m.refresh(ctx); err != nil {
+			if m.token.AccessToken == "" || m.token.ExpiresAt == 0 || now.UnixMilli() >= m.token.ExpiresAt {
+				return "", err
+			}
+			log.Warn().Err(err).Msg("[LarkUserTokenManager.AccessToken] Failed to refresh user access token, use the current one")
+		}
+	}
+	if m.token.AccessToken == "" {
+		log.Error().Msg("[LarkUserTokenManager.AccessToken] User access token is empty")
+		return "", errors.New("lark user access token is not configured")
+	}
+	return m.token.AccessToken, nil
+}
+
+// Start starts refreshing the user access token periodically in background.
+// The token is checked at once, so that a token seeded from the configuration is refreshed on startup.
+func (m *LarkUserTokenManager) Start() {
+	go func() {
+		m.check()
+		ticker := time.NewTicker(larkUserTokenCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			m.check()
+		}
+	}()
+	log.Info().Msgf("[LarkUserTokenManager.Start] User token manager started, refresh ahead: %s", m.refreshAhead)
+}
+
+// check refreshes the user access token if it is about to expire.
+// Failed refreshes are retried in the next check.
+func (m *LarkUserTokenManager) check() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.shouldRefresh(time.Now()) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), larkUserTokenRefreshTimeout)
+	defer cancel()
+	if err := m.refresh(ctx); err != nil {
+		log.Err(err).Msg("[LarkUserTokenManager.check] Failed to refresh user access token")
+	}
+}
+
+// shouldRefresh returns whether the access token should be refreshed at the given time.
+// A token whose expiration time is unknown, e.g. one seeded from the configuration, is refreshed at once.
+// The caller must hold m.mu.
+func (m *LarkUserTokenManager) shouldRefresh(now time.Time) bool {
+	if m.token.RefreshToken == "" {
+		return false
+	}
+	return m.token.ExpiresAt == 0 || !now.Add(m.refreshAhead).Before(time.UnixMilli(m.token.ExpiresAt))
+}
+
+// refresh gets a new pair of tokens by the refresh token, and persists it.
+// The admin group is alerted if it fails.
+// The caller must hold m.mu.
+func (m *LarkUserTokenManager) refresh(ctx context.Context) error {
+	req := larkauthen.NewCreateOidcRefreshAccessTokenReqBuilder().
+		Body(larkauthen.NewCreateOidcRefreshAccessTokenReqBodyBuilder().
+			GrantType("refresh_token").
+			RefreshToken(m.token.RefreshToken).
+			Build()).
+		Build()
+	resp, err := m.client.Authen.V1.OidcRefreshAccessToken.Create(ctx, req)
+	if err != nil {
+		log.Err(err).Msg("[LarkUserTokenManager.refresh] Failed to refresh user access token")
+		m.alert(err)
+		return err
+	}
+	if !resp.Success() || resp.Data == nil {
+		err = fmt.Errorf("failed to refresh user access token: %s", resp.Msg)
+		log.Error().Msgf("[LarkUserTokenManager.refresh] Failed to refresh user access token: %s", resp.Msg)
+		m.alert(err)
+		return err
+	}
+
+	now := time.Now()
+	token := &entity.LarkUserToken{
+		AccessToken:      larkcore.StringValue(resp.Data.AccessToken),
+		RefreshToken:     larkcore.StringValue(resp.Data.RefreshToken),
+		SeedRefreshToken: m.token.SeedRefreshToken,
+		ExpiresAt:        now.Add(time.Duration(larkcore.IntValue(resp.Data.ExpiresIn)) * time.Second).UnixMilli(),
+		RefreshExpiresAt: now.Add(time.Duration(larkcore.IntValue(resp.Data.RefreshExpiresIn)) * time.Second).UnixMilli(),
+		RefreshedAt:      now.UnixMilli(),
+	}
+	// The old refresh token has been used up, so the new pair is kept even if it fails to be persisted
+	m.token = token
+	if err := m.larkUserTokenRepository.Save(token); err != nil {
+		log.Err(err).Msg("[LarkUserTokenManager.refresh] Failed to save user token")
+		m.alert(fmt.Errorf("failed to save refreshed user token, it will be lost after restart: %w", err))
+		return nil
+	}
+	log.Info().Msgf("[LarkUserTokenManager.refresh] User access token refreshed, expires at: %s", time.UnixMilli(token.ExpiresAt).Format(time.RFC3339))
+	m.alerted = false
+	return nil
+}
+
+// alert notifies the admin group of a refresh failure, once until the next successful refresh.
+// The caller must hold m.mu.
+func (m *LarkUserTokenManager) alert(err error) {
+	if m.alerted {
+		return
+	}
+	// the context of the refresh may have been done, so the alert has its own
+	ctx, cancel := context.WithTimeout(context.Background(), larkUserTokenRefreshTimeout)
+	defer cancel()
+	sendErr := m.larkIMService.SendTextMessage(
+		ctx,
+		larkim.ReceiveIdTypeChatId,
+		AdminGroupID(),
+		fmt.Sprintf("飞书用户访问令牌刷新失败，通知邮件可能无法发送。如果刷新令牌已失效，请重新授权并更新 LARK_USER_REFRESH_TOKEN 后重启。\n错误：%s", err),
+	)
+	if sendErr != nil {
+		log.Err(sendErr).Msg("[LarkUserTokenManager.alert] Failed to alert admin group")
+		return
+	}
+	m.alerted = true
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/internal/repository"
+	"dantaautotool/pkg"
+	"fmt"
+	"time"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"github.com/larksuite/oapi-sdk-go/v3/service/mail/v1"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// notificationOutboxCheckInterval is the interval between two checks of pending notifications
+	notificationOutboxCheckInterval = 10 * time.Second
+
+	// notificationDeliverTimeout is the timeout of a delivery attempt
+	notificationDeliverTimeout = 30 * time.Second
+)
+
+// NotificationOutboxIntf defines the interface for NotificationOutbox.
+type NotificationOutboxIntf interface {
+	// Enqueue adds an email to the outbox, one notification for each recipient, to be delivered in background.
+	// It returns an error only if the notifications cannot be persisted.
+	Enqueue(templateName string, email *entity.RenderedEmail, toEmailList []string) error
+
+	// ListDeadLetters returns the notifications which have run out of attempts, in the order they are enqueued.
+	ListDeadLetters() ([]*entity.Notification, error)
+
+	// RetryDeadLetter moves the dead letter with the given ID back to the pending notifications,
+	// to be delivered again at once with a fresh number of attempts.
+	RetryDeadLetter(id string) error
+
+	// DiscardDeadLetter deletes the dead letter with the given ID, after the recipient has been notified by other means.
+	DiscardDeadLetter(id string) error
+
+	// Start starts delivering pending notifications in background.
+	Start()
+}
+
+// NotificationOutbox delivers notification emails in background, so that a mail outage never blocks the approval flow.
+//
+// Enqueued emails are persisted in the repository, one notification for each recipient, so that they survive restarts.
+// A failed delivery is retried with exponential backoff, and the notification is moved to the dead letters
+// after the configured number of attempts, in which case the admin group is alerted.
+// Sent notifications are deleted, and dead letters are kept until an admin retries or discards them.
+type NotificationOutbox struct {
+	// larkEmailService is used to send emails, through Lark mail or SMTP
+	larkEmailService LarkEmailServiceIntf
+
+	// larkIMService is used to alert the admin group
+	larkIMService LarkIMServiceIntf
+
+	// notificationOutboxRepository is used to persist the notifications
+	notificationOutboxRepository repository.NotificationOutboxRepositoryIntf
+
+	// maxAttempts is the number of attempts before a notification is moved to the dead letters
+	maxAttempts int
+
+	// retryBase and retryMax are the delay before the first retry, and the upper limit of the delay
+	retryBase time.Duration
+	retryMax  time.Duration
+
+	// wake wakes the worker up to deliver newly enqueued notifications at once
+	wake chan struct{}
+}
+
+// NewNotificationOutbox creates a new instance of NotificationOutbox.
+func NewNotificationOutbox(larkEmailService LarkEmailServiceIntf, larkIMService LarkIMServiceIntf, notificationOutboxRepository repository.NotificationOutboxRepositoryIntf) *NotificationOutbox {
+	maxAttempts := config.Config.NotificationMaxAttempts
+	if maxAttempts <= 0 {
+		log.Warn().Msgf("[NewNotificationOutbox] Invalid max attempts: %d, fallback to 8", maxAttempts)
+		maxAttempts = 8
+	}
+	retryBaseSeconds := config.Config.NotificationRetryBaseSeconds
+	if retryBaseSeconds <= 0 {
+		log.Warn().Msgf("[NewNotificationOutbox] Invalid retry base seconds: %d, fallback to 30", retryBaseSeconds)
+		retryBaseSeconds = 30
+	}
+	retryMaxSeconds := config.Config.NotificationRetryMaxSeconds
+	if retryMaxSeconds < retryBaseSeconds {
+		log.Warn().Msgf("[NewNotificationOutbox] Invalid retry max seconds: %d, fallback to %d", retryMaxSeconds, retryBaseSeconds)
+		retryMaxSeconds = retryBaseSeconds
+	}
+	return &NotificationOutbox{
+		larkEmailService:             larkEmailService,
+		larkIMService:                larkIMService,
+		notificationOutboxRepository: notificationOutboxRepository,
+		maxAttempts:                  maxAttempts,
+		retryBase:                    time.Duration(retryBaseSeconds) * time.Second,
+		retryMax:                     time.Duration(retryMaxSeconds) * time.Second,
+		wake:                         make(chan struct{}, 1),
+	}
+}
+
+// Enqueue adds an email to the outbox, one notification for each recipient, to be delivered in background.
+// It returns an error only if the notifications cannot be persisted.
+func (o *NotificationOutbox) Enqueue(templateName string, email *entity.RenderedEmail, toEmailList []string) error {
+	notifications := make([]*entity.Notification, 0, len(toEmailList))
+	for _, toEmail := range toEmailList {
+		notifications = append(notifications, &entity.Notification{
+			TemplateName: templateName,
+			Recipient:    toEmail,
+			Email:        *email,
+		})
+	}
+	if err := o.notificationOutboxRepository.Add(notifications); err != nil {
+		log.Err(err).Msgf("[NotificationOutbox.Enqueue] Failed to enqueue notifications, template: %s", templateName)
+		return err
+	}
+	for _, notification := range notifications {
+		log.Info().Msgf("[NotificationOutbox.Enqueue] Notification enqueued, ID: %s, recipient: %s, template: %s", notification.ID, notification.Recipient, templateName)
+	}
+	o.wakeUp()
+	return nil
+}
+
+// ListDeadLetters returns the notifications which have run out of attempts, in the order they are enqueued.
+func (o *NotificationOutbox) ListDeadLetters() ([]*entity.Notification, error) {
+	return o.notificationOutboxRepository.ListByStatus(pkg.NOTIFICATION_STATUS_DEAD)
+}
+
+// RetryDeadLetter moves the dead letter with the given ID back to the pending notifications,
+// to be delivered again at once with a fresh number of attempts.
+func (o *NotificationOutbox) RetryDeadLetter(id string) error {
+	_, err := o.notificationOutboxRepository.Update(id, func(notification *entity.Notification) error {
+		if notification.Status != pkg.NOTIFICATION_STATUS_DEAD {
+			return fmt.Errorf("notification %s is not a dead letter", id)
+		}
+		notification.Status = pkg.NOTIFICATION_STATUS_PENDING
+		notification.Attempts = 0
+		notification.NextAttemptAt = time.Now().UnixMilli()
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("[NotificationOutbox.RetryDeadLetter] Failed to retry dead letter, ID: %s", id)
+		return err
+	}
+	log.Info().Msgf("[NotificationOutbox.RetryDeadLetter] Dead letter moved back to pending notifications, ID: %s", id)
+	o.wakeUp()
+	return nil
+}
+
+// DiscardDeadLetter deletes the dead letter with the given ID, after the recipient has been notified by other means.
+func (o *NotificationOutbox) DiscardDeadLetter(id string) error {
+	if err := o.notificationOutboxRepository.Delete(id, pkg.NOTIFICATION_STATUS_DEAD); err != nil {
+		log.Err(err).Msgf("[NotificationOutbox.DiscardDeadLetter] Failed to discard dead letter, ID: %s", id)
+		return err
+	}
+	log.Info().Msgf("[NotificationOutbox.DiscardDeadLetter] Dead letter discarded, ID: %s", id)
+	return nil
+}
+
+// wakeUp wakes the worker up to deliver pending notifications at once.
+func (o *NotificationOutbox) wakeUp() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+		// the worker has been woken up
+	}
+}
+
+// Start starts delivering pending notifications in background.
+// Notifications enqueued before a restart are picked up in the first check.
+func (o *NotificationOutbox) Start() {
+	deadLetters, err := o.ListDeadLetters()
+	if err != nil {
+		log.Err(err).Msg("[NotificationOutbox.Start] Failed to list dead letters")
+	} else if len(deadLetters) > 0 {
+		log.Warn().Msgf("[NotificationOutbox.Start] There are %d dead letters in the outbox", len(deadLetters))
+	}
+
+	go func() {
+		ticker := time.NewTicker(notificationOutboxCheckInterval)
+		defer ticker.Stop()
+		for {
+			o.check()
+			select {
+			case <-ticker.C:
+			case <-o.wake:
+			}
+		}
+	}()
+	log.Info().Msgf("[NotificationOutbox.Start] Notification outbox started, max attempts: %d, retry base: %s, retry max: %s", o.maxAttempts, o.retryBase, o.retryMax)
+}
+
+// check delivers the pending notifications whose next attempt is due.
+func (o *NotificationOutbox) check() {
+	notifications, err := o.notificationOutboxRepository.ListByStatus(pkg.NOTIFICATION_STATUS_PENDING)
+	if err != nil {
+		log.Err(err).Msg("[NotificationOutbox.check] Failed to list pending notifications")
+		return
+	}
+	now := time.Now().UnixMilli()
+	for _, notification := range notifications {
+		if notification.NextAttemptAt > now {
+			continue
+		}
+		o.deliver(notification)
+	}
+}
+
+// deliver sends a notification, and records the result of the attempt.
+// A sent notification is deleted from the outbox.
+func (o *NotificationOutbox) deliver(notification *entity.Notification) {
+	me := config.Config.DantaDevEmail
+	headFrom := larkmail.NewMailAddressBuilder().MailAddress(me).Name(config.Config.EmailSenderName).Build()
+	to := larkmail.NewMailAddressBuilder().MailAddress(notification.Recipient).Name(notification.Recipient).Build()
+	ctx, cancel := context.WithTimeout(context.Background(), notificationDeliverTimeout)
+	sendErr := o.larkEmailService.SendEmail(
+		ctx,
+		me,
+		notification.Email.Subject,
+		[]*larkmail.MailAddress{to},
+		nil,
+		nil,
+		headFrom,
+		notification.Email.BodyHtml,
+		notification.Email.BodyPlainText,
+	)
+	cancel()
+
+	if sendErr == nil {
+		if err := o.notificationOutboxRepository.Delete(notification.ID, pkg.NOTIFICATION_STATUS_PENDING); err != nil {
+			// the notification may be delivered again
+			log.Err(err).Msgf("[NotificationOutbox.deliver] Failed to delete sent notification, ID: %s", notification.ID)
+			return
+		}
+		log.Info().Msgf("[NotificationOutbox.deliver] Notification sent, ID: %s, recipient: %s, template: %s", notification.ID, notification.Recipient, notification.TemplateName)
+		return
+	}
+
+	updated, err := o.notificationOutboxRepository.Update(notification.ID, func(notification *entity.Notification) error {
+		now := time.Now()
+		notification.Attempts++
+		notification.LastError = sendErr.Error()
+		if notification.Attempts >= o.maxAttempts {
+			notification.Status = pkg.NOTIFICATION_STATUS_DEAD
+			return nil
+		}
+		notification.NextAttemptAt = now.Add(o.backoff(notification.Attempts)).UnixMilli()
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("[NotificationOutbox.deliver] Failed to record failed delivery, ID: %s", notification.ID)
+		return
+	}
+
+	if updated.Status == pkg.NOTIFICATION_STATUS_DEAD {
+		log.Error().Err(sendErr).Msgf("[NotificationOutbox.deliver] Notification moved to dead letters after %d attempts, ID: %s, recipient: %s", updated.Attempts, updated.ID, updated.Recipient)
+		o.alert(updated)
+	} else {
+		log.Warn().Err(sendErr).Msgf("[NotificationOutbox.deliver] Failed to send notification, attempt %d/%d, next attempt at: %s, ID: %s, recipient: %s",
+			updated.Attempts, o.maxAttempts, time.UnixMilli(updated.NextAttemptAt).Format(time.RFC3339), updated.ID, updated.Recipient)
+	}
+}
+
+// backoff returns the delay before the next attempt, after the given number of failed attempts.
+// The delay doubles after each failed attempt, up to retryMax.
+func (o *NotificationOutbox) backoff(attempts int) time.Duration {
+	delay := o.retryBase
+	for i := 1; i < attempts && delay < o.retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, o.retryMax)
+}
+
+// alert notifies the admin group of a notification moved to the dead letters.
+func (o *NotificationOutbox) alert(notification *entity.Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), notificationDeliverTimeout)
+	defer cancel()
+	err := o.larkIMService.SendTextMessage(
+		ctx,
+		larkim.ReceiveIdTypeChatId,
+		AdminGroupID(),
+		fmt.Sprintf("通知邮件在 %d 次尝试后仍发送失败，已放入死信列表。\nID：%s\n收件人：%s\n标题：%s\n错误：%s\n在本群 @机器人 发送“/retry %s”重新发送，或手动通知收件人后发送“/discard %s”丢弃；发送“/deadletters”查看所有死信。",
+			notification.Attempts, notification.ID, notification.Recipient, notification.Email.Subject, notification.LastError, notification.ID, notification.ID),
+	)
+	if err != nil {
+		log.Err(err).Msg("[NotificationOutbox.alert] Failed to alert admin group")
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"dantaautotool/config"
+	"dantaautotool/internal/entity"
+	"dantaautotool/internal/repository"
+	"dantaautotool/pkg"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/larksuite/oapi-sdk-go/v3/service/mail/v1"
+)
+
+// fakeEmailService fails to send emails to the recipients in failing.
+type fakeEmailService struct {
+	failing map[string]bool
+	sent    []string
+}
+
+func (s *fakeEmailService) SendEmailSimple(ctx context.Context, me, subject, toMail, toName, meName, bodyPlainText string) error {
+	return errors.New("not implemented")
+}
+
+func (s *fakeEmailService) SendEmail(ctx context.Context, me, subject string, to, cc, bcc []*larkmail.MailAddress, headFrom *larkmail.MailAddress, bodyHtml, bodyPlainText string) error {
+	recipient := *to[0].MailAddress
+	if s.failing[recipient] {
+		return errors.New("mailbox unavailable")
+	}
+	s.sent = append(s.sent, recipient)
+	return nil
+}
+
+// fakeIMService records the text messages sent.
+type fakeIMService struct {
+	texts []string
+}
+
+func (s *fakeIMService) SendCardMessageByTemplate(ctx context.Context, receiveIdType, receiveID string, templateCardID string, templateVariables map[string]interface{}) error {
+	return nil
+}
+
+func (s *fakeIMService) UpdateCardMessageByTemplate(ctx context.Context, messageID string, templateCardID string, templateVariables map[string]interface{}) error {
+	return nil
+}
+
+func (s *fakeIMService) SendMessage(ctx context.Context, receiveIdType, receiveID, content string) error {
+	return nil
+}
+
+func (s *fakeIMService) SendTextMessage(ctx context.Context, receiveIdType, receiveID, text string) error {
+	s.texts = append(s.texts, text)
+	return nil
+}
+
+func (s *fakeIMService) ReplyTextMessage(ctx context.Context, messageID, text string) error {
+	return nil
+}
+
+func TestNotificationOutboxDeadLetters(t *testing.T) {
+	original := config.Config
+	t.Cleanup(func() {
+		config.Config = original
+	})
+	config.Config.NotificationMaxAttempts = 1
+	config.Config.NotificationRetryBaseSeconds = 30
+	config.Config.NotificationRetryMaxSeconds = 30
+
+	db, err := repository.OpenBoltDB(filepath.Join(t.TempDir(), "danta.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltDB() error = %v", err)
+	}
+	defer db.Close()
+	notificationOutboxRepository, err := repository.NewNotificationOutboxRepository(db)
+	if err != nil {
+		t.Fatalf("NewNotificationOutboxRepository() error = %v", err)
+	}
+	emailService := &fakeEmailService{failing: map[string]bool{"bob@example.com": true}}
+	imService := &fakeIMService{}
+	outbox := NewNotificationOutbox(emailService, imService, notificationOutboxRepository)
+
+	email := &entity.RenderedEmail{Subject: "Banner approved"}
+	if err := outbox.Enqueue(pkg.EMAIL_TEMPLATE_BANNER_APPROVED, email, []string{"alice@example.com", "bob@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	outbox.check()
+
+	// the sent notification is deleted, and the failed one is moved to the dead letters
+	pending, err := notificationOutboxRepository.ListByStatus(pkg.NOTIFICATION_STATUS_PENDING)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("pending notifications = %+v, %v, want none", pending, err)
+	}
+	deadLetters, err := outbox.ListDeadLetters()
+	if err != nil || len(deadLetters) != 1 || deadLetters[0].Recipient != "bob@example.com" {
+		t.Fatalf("ListDeadLetters() = %+v, %v, want the notification to bob", deadLetters, err)
+	}
+	id := deadLetters[0].ID
+	if len(imService.texts) != 1 || !strings.Contains(imService.texts[0], "/retry "+id) {
+		t.Errorf("alerts = %q, want one with the retry command", imService.texts)
+	}
+
+	// only dead letters can be discarded
+	if err := notificationOutboxRepository.Delete(id, pkg.NOTIFICATION_STATUS_PENDING); err == nil {
+		t.Error("Delete() of a dead letter as pending succeeded, want an error")
+	}
+	if err := outbox.DiscardDeadLetter("missing"); !errors.Is(err, repository.ErrNotificationNotFound) {
+		t.Errorf("DiscardDeadLetter() of a missing notification error = %v, want %v", err, repository.ErrNotificationNotFound)
+	}
+
+	// a retried dead letter is delivered again with a fresh number of attempts
+	if err := outbox.RetryDeadLetter(id); err != nil {
+		t.Fatalf("RetryDeadLetter() error = %v", err)
+	}
+	if err := outbox.RetryDeadLetter(id); err == nil {
+		t.Error("RetryDeadLetter() of a pending notification succeeded, want an error")
+	}
+	delete(emailService.failing, "bob@example.com")
+	outbox.check()
+	if want := []string{"alice@example.com", "bob@example.com"}; strings.Join(emailService.sent, ",") != strings.Join(want, ",") {
+		t.Errorf("sent = %q, want %q", emailService.sent, want)
+	}
+	if _, err := notificationOutboxRepository.Update(id, func(notification *entity.Notification) error { return nil }); !errors.Is(err, repository.ErrNotificationNotFound) {
+		t.Errorf("Update() of the sent notification error = %v, want %v", err, repository.ErrNotificationNotFound)
+	}
+
+	// a discarded dead letter is deleted
+	emailService.failing["carol@example.com"] = true
+	if err := outbox.Enqueue(pkg.EMAIL_TEMPLATE_BANNER_APPROVED, email, []string{"carol@example.com"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	outbox.check()
+	deadLetters, err = outbox.ListDeadLetters()
+	if err != nil || len(deadLetters) != 1 {
+		t.Fatalf("ListDeadLetters() = %+v, %v, want the notification to carol", deadLetters, err)
+	}
+	if err := outbox.DiscardDeadLetter(deadLetters[0].ID); err != nil {
+		t.Fatalf("DiscardDeadLetter() error = %v", err)
+	}
+	if deadLetters, err := outbox.ListDeadLetters(); err != nil || len(deadLetters) != 0 {
+		t.Errorf("ListDeadLetters() after discarding = %+v, %v, want none", deadLetters, err)
+	}
+}
@@ -0,0 +1,316 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"dantaautotool/config"
+	"dantaautotool/pkg"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	"github.com/larksuite/oapi-sdk-go/v3/service/mail/v1"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// smtpDialTimeout is the timeout of connecting to the SMTP server
+	smtpDialTimeout = 10 * time.Second
+
+	// smtpSessionTimeout is the timeout of the whole SMTP session, from connecting to quitting
+	smtpSessionTimeout = 30 * time.Second
+)
+
+// SMTPEmailService sends emails through an SMTP server.
+// It implements LarkEmailServiceIntf, so that it can replace LarkEmailService to send emails from a shared address.
+type SMTPEmailService struct {
+	// host and port are the address of the SMTP server
+	host string
+	port int
+
+	// security is one of pkg.SMTP_SECURITY_STARTTLS, pkg.SMTP_SECURITY_TLS and pkg.SMTP_SECURITY_NONE
+	security string
+
+	// username and password are used to authenticate, and no authentication is done if username is empty
+	username string
+	password string
+
+	// from is the sender address, which overrides the addresses given by callers if not empty
+	from string
+
+	// rootCAs verifies the certificate of the server, nil to use the system roots
+	rootCAs *x509.CertPool
+}
+
+// NewSMTPEmailService creates a new instance of SMTPEmailService.
+func NewSMTPEmailService() (*SMTPEmailService, error) {
+	host := config.Config.SMTPHost
+	if host == "" {
+		log.Error().Msg("[NewSMTPEmailService] SMTP_HOST is empty")
+		return nil, errors.New("SMTP_HOST is empty")
+	}
+	security := config.Config.SMTPSecurity
+	if security != pkg.SMTP_SECURITY_STARTTLS && security != pkg.SMTP_SECURITY_TLS && security != pkg.SMTP_SECURITY_NONE {
+		log.Error().Msgf("[NewSMTPEmailService] Invalid SMTP security: %s", security)
+		return nil, fmt.Errorf("invalid SMTP security: %s", security)
+	}
+	if security == pkg.SMTP_SECURITY_NONE {
+		log.Warn().Msg("[NewSMTPEmailService] SMTP connection is not encrypted")
+	}
+	return &SMTPEmailService{
+		host:     host,
+		port:     config.Config.SMTPPort,
+		security: security,
+		username: config.Config.SMTPUsername,
+		password: config.Config.SMTPPassword,
+		from:     config.Config.SMTPFrom,
+	}, nil
+}
+
+// SendEmailSimple sends an email to the specified email address.
+//
+// Parameters:
+// - me: The sender's email address.
+// - subject: The subject of the email.
+// - toMail: The recipient's email address.
+// - toName: The recipient's name.
+// - meName: The sender's name.
+// - bodyPlainText: The plain text body of the email.
+//
+// Returns:
+// - error: An error if the email could not be sent, otherwise nil.
+func (s *SMTPEmailService) SendEmailSimple(ctx context.Context, me, subject, toMail, toName, meName, bodyPlainText string) error {
+	toMailAddr := larkmail.NewMailAddressBuilder().MailAddress(toMail).Name(toName).Build()
+	meMailAddr := larkmail.NewMailAddressBuilder().MailAddress(me).Name(meName).Build()
+	return s.SendEmail(ctx, me, subject, []*larkmail.MailAddress{toMailAddr}, nil, nil, meMailAddr, "", bodyPlainText)
+}
+
+// SendEmail sends an email to the specified email address.
+//
+// Parameters:
+// - me: The sender's email address, used as the envelope sender if the sender address is not configured.
+// - subject: The subject of the email.
+// - to: A slice of recipient email addresses.
+// - cc: A slice of CC email addresses.
+// - bcc: A slice of BCC email addresses, which are not shown in the email header.
+// - headFrom: The sender's email address to be displayed in the email header. The configured sender address replaces its address.
+// - bodyHtml: The HTML body of the email. If it is not empty, the email is sent as multipart/alternative.
+// - bodyPlainText: The plain text body of the email.
+//
+// Returns:
+// - error: An error if the email could not be sent, otherwise nil.
+func (s *SMTPEmailService) SendEmail(ctx context.Context, me, subject string, to, cc, bcc []*larkmail.MailAddress, headFrom *larkmail.MailAddress, bodyHtml, bodyPlainText string) error {
+	from := &mail.Address{Address: me}
+	if headFrom != nil {
+		from = toNetMailAddress(headFrom)
+	}
+	if s.from != "" {
+		from.Address = s.from
+	}
+	if from.Address == "" {
+		log.Error().Msg("[SMTPEmailService.SendEmail] Sender address is empty")
+		return errors.New("sender address is empty")
+	}
+
+	recipients := make([]string, 0, len(to)+len(cc)+len(bcc))
+	for _, addresses := range [][]*larkmail.MailAddress{to, cc, bcc} {
+		for _, address := range addresses {
+			recipients = append(recipients, larkcore.StringValue(address.MailAddress))
+		}
+	}
+	if len(recipients) == 0 {
+		log.Error().Msg("[SMTPEmailService.SendEmail] No recipient")
+		return errors.New("no recipient")
+	}
+
+	message, err := buildSMTPMessage(from, subject, to, cc, bodyHtml, bodyPlainText)
+	if err != nil {
+		log.Err(err).Msg("[SMTPEmailService.SendEmail] Failed to build message")
+		return err
+	}
+	if err := s.send(ctx, from.Address, recipients, message); err != nil {
+		log.Err(err).Msgf("[SMTPEmailService.SendEmail] Failed to send email, server: %s:%d", s.host, s.port)
+		return err
+	}
+	return nil
+}
+
+// send delivers the message in an SMTP session.
+func (s *SMTPEmailService) send(ctx context.Context, from string, recipients []string, message []byte) error {
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	tlsConfig := &tls.Config{ServerName: s.host, RootCAs: s.rootCAs}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	if s.security == pkg.SMTP_SECURITY_TLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	// the session ends at the deadline of ctx if it comes earlier
+	deadline := time.Now().Add(smtpSessionTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if s.security == pkg.SMTP_SECURITY_STARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if s.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", recipient, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildSMTPMessage builds a MIME message with the given headers and bodies.
+// The bodies are quoted-printable encoded, and the message is multipart/alternative if the HTML body is not empty.
+func buildSMTPMessage(from *mail.Address, subject string, to, cc []*larkmail.MailAddress, bodyHtml, bodyPlainText string) ([]byte, error) {
+	var buf bytes.Buffer
+	writeHeader := func(key, value string) {
+		buf.WriteString(key + ": " + value + "\r\n")
+	}
+	writeHeader("From", from.String())
+	if len(to) > 0 {
+		writeHeader("To", formatMailAddresses(to))
+	}
+	if len(cc) > 0 {
+		writeHeader("Cc", formatMailAddresses(cc))
+	}
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-ID", newMessageID(from.Address))
+	writeHeader("MIME-Version", "1.0")
+
+	if bodyHtml == "" {
+		writeHeader("Content-Type", "text/plain; charset=utf-8")
+		writeHeader("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, bodyPlainText); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		// the last part is preferred by clients, so HTML comes last
+		{"text/plain; charset=utf-8", bodyPlainText},
+		{"text/html; charset=utf-8", bodyHtml},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(pw, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	writeHeader("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// writeQuotedPrintable writes the content to w in quoted-printable encoding.
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+// formatMailAddresses formats the addresses as the value of an address list header, e.g. To.
+func formatMailAddresses(addresses []*larkmail.MailAddress) string {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		formatted = append(formatted, toNetMailAddress(address).String())
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// toNetMailAddress converts a Lark mail address to a net/mail address.
+func toNetMailAddress(address *larkmail.MailAddress) *mail.Address {
+	return &mail.Address{
+		Name:    larkcore.StringValue(address.Name),
+		Address: larkcore.StringValue(address.MailAddress),
+	}
+}
+
+// newMessageID generates a unique Message-ID in the domain of the sender address.
+func newMessageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain)
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"dantaautotool/config"
+	"dantaautotool/pkg"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/larksuite/oapi-sdk-go/v3/service/mail/v1"
+)
+
+// newTestCertificate creates a self-signed certificate for 127.0.0.1.
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// smtpSession is what the test SMTP server receives in a session.
+type smtpSession struct {
+	tls        bool
+	auth       string
+	from       string
+	recipients []string
+	data       string
+}
+
+// startTestSMTPServer starts an in-process SMTP server on 127.0.0.1, speaking implicit TLS or offering STARTTLS.
+// It accepts PLAIN auth only over TLS, and sends each finished session to the returned channel.
+func startTestSMTPServer(t *testing.T, cert tls.Certificate, implicitTLS bool) (int, <-chan smtpSession) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	sessions := make(chan smtpSession, 1)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSMTP(conn, tlsConfig, implicitTLS, sessions)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, sessions
+}
+
+// serveTestSMTP serves an SMTP session on conn.
+func serveTestSMTP(conn net.Conn, tlsConfig *tls.Config, implicitTLS bool, sessions chan<- smtpSession) {
+	defer conn.Close()
+	var session smtpSession
+	if implicitTLS {
+		conn = tls.Server(conn, tlsConfig)
+		session.tls = true
+	}
+	tc := textproto.NewConn(conn)
+	_ = tc.PrintfLine("220 127.0.0.1 ESMTP test")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			lines := []string{"127.0.0.1"}
+			if !session.tls {
+				lines = append(lines, "STARTTLS")
+			} else {
+				lines = append(lines, "AUTH PLAIN")
+			}
+			for _, l := range lines[:len(lines)-1] {
+				_ = tc.PrintfLine("250-%s", l)
+			}
+			_ = tc.PrintfLine("250 %s", lines[len(lines)-1])
+		case "STARTTLS":
+			_ = tc.PrintfLine("220 Ready to start TLS")
+			conn = tls.Server(conn, tlsConfig)
+			tc = textproto.NewConn(conn)
+			session.tls = true
+		case "AUTH":
+			mechanism, initialResponse, _ := strings.Cut(arg, " ")
+			auth, err := base64.StdEncoding.DecodeString(initialResponse)
+			if mechanism != "PLAIN" || err != nil || !session.tls {
+				_ = tc.PrintfLine("535 Authentication failed")
+				continue
+			}
+			session.auth = string(auth)
+			_ = tc.PrintfLine("235 Authentication succeeded")
+		case "MAIL":
+			session.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			_ = tc.PrintfLine("250 OK")
+		case "RCPT":
+			session.recipients = append(session.recipients, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			_ = tc.PrintfLine("250 OK")
+		case "DATA":
+			_ = tc.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(tc.DotReader())
+			if err != nil {
+				return
+			}
+			session.data = string(data)
+			_ = tc.PrintfLine("250 OK")
+		case "QUIT":
+			_ = tc.PrintfLine("221 Bye")
+			sessions <- session
+			return
+		default:
+			_ = tc.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPEmailServiceSendEmail(t *testing.T) {
+	cert, rootCAs := newTestCertificate(t)
+	plainText := "审批通过，Banner 将于明天上线。" + strings.Repeat("a", 100) + "\n= 结束"
+	html := "<p>审批通过，Banner 将于<b>明天</b>上线。</p>" + strings.Repeat("<br>", 30)
+
+	for _, security := range []string{pkg.SMTP_SECURITY_STARTTLS, pkg.SMTP_SECURITY_TLS} {
+		t.Run(security, func(t *testing.T) {
+			port, sessions := startTestSMTPServer(t, cert, security == pkg.SMTP_SECURITY_TLS)
+			original := config.Config
+			t.Cleanup(func() {
+				config.Config = original
+			})
+			config.Config = config.GlobalConfig{
+				SMTPHost:     "127.0.0.1",
+				SMTPPort:     port,
+				SMTPSecurity: security,
+				SMTPUsername: "danta",
+				SMTPPassword: "secret",
+				SMTPFrom:     "noreply@danxi.dev",
+			}
+			smtpService, err := NewSMTPEmailService()
+			if err != nil {
+				t.Fatalf("NewSMTPEmailService() error = %v", err)
+			}
+			smtpService.rootCAs = rootCAs
+
+			to := []*larkmail.MailAddress{larkmail.NewMailAddressBuilder().MailAddress("alice@example.com").Name("Alice").Build()}
+			bcc := []*larkmail.MailAddress{larkmail.NewMailAddressBuilder().MailAddress("audit@example.com").Build()}
+			headFrom := larkmail.NewMailAddressBuilder().MailAddress("dev@danxi.dev").Name("旦挞").Build()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err = smtpService.SendEmail(ctx, "dev@danxi.dev", "Banner 审批结果", to, nil, bcc, headFrom, html, plainText)
+			if err != nil {
+				t.Fatalf("SendEmail() error = %v", err)
+			}
+
+			var session smtpSession
+			select {
+			case session = <-sessions:
+			case <-ctx.Done():
+				t.Fatal("SMTP session did not finish")
+			}
+			if !session.tls || session.auth != "\x00danta\x00secret" {
+				t.Errorf("session TLS = %v, auth = %q, want TLS and PLAIN auth of danta", session.tls, session.auth)
+			}
+			if session.from != "noreply@danxi.dev" || strings.Join(session.recipients, ",") != "alice@example.com,audit@example.com" {
+				t.Errorf("envelope from = %s, recipients = %v", session.from, session.recipients)
+			}
+			checkSMTPMessage(t, session.data, plainText, html)
+		})
+	}
+}
+
+// checkSMTPMessage checks the headers of the message, and that it is multipart/alternative of the plain text and the HTML,
+// both quoted-printable encoded.
+func checkSMTPMessage(t *testing.T, data, plainText, html string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to read message: %v\n%s", err, data)
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil || from.Name != "旦挞" || from.Address != "noreply@danxi.dev" {
+		t.Errorf("From = %q, %v", msg.Header.Get("From"), err)
+	}
+	if got := msg.Header.Get("To"); got != `"Alice" <alice@example.com>` {
+		t.Errorf("To = %q", got)
+	}
+	if msg.Header.Get("Bcc") != "" || strings.Contains(data, "audit@example.com") {
+		t.Error("message reveals the BCC recipient")
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Banner 审批结果" {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", msg.Header.Get("Content-Type"), err)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for i, want := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", plainText},
+		{"text/html; charset=utf-8", html},
+	} {
+		// a raw part keeps its Content-Transfer-Encoding, and is not decoded by the reader
+		part, err := reader.NextRawPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if part.Header.Get("Content-Type") != want.contentType || part.Header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+			t.Errorf("part %d header = %v", i, part.Header)
+		}
+		raw, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+		for scanner.Scan() {
+			if len(scanner.Text()) > 76 {
+				t.Errorf("part %d has a line longer than 76 characters: %q", i, scanner.Text())
+			}
+		}
+		decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(string(raw))))
+		if err != nil || string(decoded) != want.content {
+			t.Errorf("part %d decoded = %q, %v, want %q", i, decoded, err, want.content)
+		}
+	}
+	if _, err := reader.NextRawPart(); err != io.EOF {
+		t.Errorf("more than 2 parts, error = %v", err)
+	}
+}
+
+func TestSMTPEmailServiceRejectsUntrustedCertificate(t *testing.T) {
+	cert, _ := newTestCertificate(t)
+	port, _ := startTestSMTPServer(t, cert, true)
+	smtpService := &SMTPEmailService{host: "127.0.0.1", port: port, security: pkg.SMTP_SECURITY_TLS}
+	err := smtpService.SendEmailSimple(context.Background(), "dev@danxi.dev", "subject", "alice@example.com", "Alice", "Dev", "body")
+	if err == nil {
+		t.Error("SendEmailSimple() to a server with an untrusted certificate succeeded, want an error")
+	}
+}
@@ -5,12 +5,53 @@ const (
 
 	LARK_IM_CARD_ACTION_APPROVE    = "approve"
 	LARK_IM_CARD_ACTION_DISAPPROVE = "disapprove"
+	LARK_IM_CARD_ACTION_WITHDRAW   = "withdraw"
 
 	BANNER_STATUS_PENDING     = "pending"
 	BANNER_STATUS_APPROVED    = "approved"
 	BANNER_STATUS_DISAPPROVED = "disapproved"
+	BANNER_STATUS_WITHDRAWN   = "withdrawn"
+	BANNER_STATUS_EXPIRED     = "expired"
+
+	// The status field of the banner application bitable, and its option meaning the application is withdrawn
+	LARK_BITABLE_FIELD_BANNER_STATUS           = "状态"
+	LARK_BITABLE_FIELD_BANNER_STATUS_WITHDRAWN = "撤回"
+	// The option of the status field meaning the application is disapproved, and the field holding the reasons
+	LARK_BITABLE_FIELD_BANNER_STATUS_DISAPPROVED = "驳回"
+	LARK_BITABLE_FIELD_BANNER_REJECT_REASON      = "驳回理由"
+
+	// Supported locales of email templates
+	EMAIL_LOCALE_ZH_CN = "zh_cn"
+	EMAIL_LOCALE_EN_US = "en_us"
+
+	// Supported backends to send emails
+	EMAIL_BACKEND_LARK = "lark"
+	EMAIL_BACKEND_SMTP = "smtp"
+
+	// Supported security modes of SMTP connections
+	SMTP_SECURITY_STARTTLS = "starttls"
+	SMTP_SECURITY_TLS      = "tls"
+	SMTP_SECURITY_NONE     = "none"
+
+	// Supported ways to authenticate to Github
+	GITHUB_AUTH_MODE_PAT = "pat"
+	GITHUB_AUTH_MODE_APP = "app"
+
+	// Statuses of notifications in the outbox
+	NOTIFICATION_STATUS_PENDING = "pending"
+	NOTIFICATION_STATUS_DEAD    = "dead"
+
+	// Names of email templates
+	EMAIL_TEMPLATE_BANNER_APPROVED    = "banner_approved"
+	EMAIL_TEMPLATE_BANNER_DISAPPROVED = "banner_disapproved"
+	EMAIL_TEMPLATE_BANNER_WITHDRAWN   = "banner_withdrawn"
 
 	LARK_BITABLE_RECORD_ACTION_ADD    = "record_added"
 	LARK_BITABLE_RECORD_ACTION_EDITED = "record_edited"
 	LARK_BITABLE_RECORD_ACTION_DELETE = "record_deleted"
+
+	// Commands sent to the bot in the admin group to manage dead letters in the outbox
+	LARK_IM_ADMIN_COMMAND_DEAD_LETTERS = "/deadletters"
+	LARK_IM_ADMIN_COMMAND_RETRY        = "/retry"
+	LARK_IM_ADMIN_COMMAND_DISCARD      = "/discard"
 )
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// TTLCache is a concurrency-safe in-memory cache, whose entries expire after a fixed time to live.
+// Expired entries are treated as absent at once, but removed from the map only from time to time when new entries are added.
+type TTLCache[K comparable, V any] struct {
+	// ttl is the time to live of each entry
+	ttl time.Duration
+
+	// mu protects entries and nextPurge
+	mu sync.Mutex
+
+	// entries are the cached entries
+	entries map[K]ttlCacheEntry[V]
+
+	// nextPurge is the time when expired entries are removed next time
+	nextPurge time.Time
+}
+
+// ttlCacheEntry is a cached value with its expiration time.
+type ttlCacheEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// NewTTLCache creates a new instance of TTLCache, whose entries expire after ttl.
+func NewTTLCache[K comparable, V any](ttl time.Duration) *TTLCache[K, V] {
+	return &TTLCache[K, V]{
+		ttl:       ttl,
+		entries:   make(map[K]ttlCacheEntry[V]),
+		nextPurge: time.Now().Add(ttl),
+	}
+}
+
+// Get returns the value of the given key, and whether it exists and has not expired.
+func (c *TTLCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// Set sets the value of the given key, and resets its time to live.
+func (c *TTLCache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value, time.Now())
+}
+
+// SetIfAbsent sets the value of the given key only if it does not exist or has expired.
+// It returns true if the value is set, which makes it usable as an atomic "claim" of the key.
+func (c *TTLCache[K, V]) SetIfAbsent(key K, value V) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := c.entries[key]; ok && now.Before(entry.expiresAt) {
+		return false
+	}
+	c.set(key, value, now)
+	return true
+}
+
+// Delete removes the given key.
+func (c *TTLCache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// set sets the value of the given key, and removes expired entries from time to time.
+// The caller must hold c.mu.
+func (c *TTLCache[K, V]) set(key K, value V, now time.Time) {
+	c.entries[key] = ttlCacheEntry[V]{
+		value:     value,
+		expiresAt: now.Add(c.ttl),
+	}
+	if now.Before(c.nextPurge) {
+		return
+	}
+	for k, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.nextPurge = now.Add(c.ttl)
+}
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCacheSetIfAbsent(t *testing.T) {
+	c := NewTTLCache[string, int](50 * time.Millisecond)
+
+	if !c.SetIfAbsent("a", 1) {
+		t.Fatal("SetIfAbsent() of a missing key = false, want true")
+	}
+	if c.SetIfAbsent("a", 2) {
+		t.Error("SetIfAbsent() of an existing key = true, want false")
+	}
+	if value, ok := c.Get("a"); !ok || value != 1 {
+		t.Errorf("Get() = %d, %v, want the value set first", value, ok)
+	}
+
+	// a deleted key can be claimed again
+	c.Delete("a")
+	if !c.SetIfAbsent("a", 3) {
+		t.Error("SetIfAbsent() of a deleted key = false, want true")
+	}
+}
+
+func TestTTLCacheExpiry(t *testing.T) {
+	c := NewTTLCache[string, int](50 * time.Millisecond)
+	c.Set("a", 1)
+	time.Sleep(60 * time.Millisecond)
+
+	// an expired entry is absent, though it stays in the map until the next purge
+	if value, ok := c.Get("a"); ok {
+		t.Errorf("Get() of an expired key = %d, %v, want absent", value, ok)
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("entries = %v, want the expired entry kept by Get()", c.entries)
+	}
+	if !c.SetIfAbsent("a", 2) {
+		t.Error("SetIfAbsent() of an expired key = false, want true")
+	}
+	if value, ok := c.Get("a"); !ok || value != 2 {
+		t.Errorf("Get() = %d, %v, want the value set after expiry", value, ok)
+	}
+
+	// adding an entry after the purge time removes the expired entries
+	time.Sleep(60 * time.Millisecond)
+	c.Set("b", 1)
+	if _, ok := c.entries["a"]; ok || len(c.entries) != 1 {
+		t.Errorf("entries = %v, want only the new entry", c.entries)
+	}
+}
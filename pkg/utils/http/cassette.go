@@ -0,0 +1,266 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bytedance/sonic"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/rs/zerolog/log"
+)
+
+// Modes of a cassette.
+const (
+	// CassetteModeRecord sends requests to the server, and records them with their responses
+	CassetteModeRecord = "record"
+
+	// CassetteModeReplay serves recorded responses without sending any request
+	CassetteModeReplay = "replay"
+)
+
+// cassetteRedacted replaces the values of redacted headers and body fields in cassette files
+const cassetteRedacted = "REDACTED"
+
+// defaultCassetteRedactHeaders are the headers redacted by default, in requests and responses
+var defaultCassetteRedactHeaders = []string{consts.HeaderAuthorization, consts.HeaderSetCookie}
+
+// defaultCassetteRedactBodyFields are the JSON fields redacted by default in response bodies,
+// e.g. the installation access tokens of Github Apps
+var defaultCassetteRedactBodyFields = []string{"token", "access_token", "refresh_token", "client_secret", "private_key", "password"}
+
+// ErrCassetteInteractionNotFound is returned in replay mode if no recorded interaction matches the request.
+var ErrCassetteInteractionNotFound = errors.New("no recorded interaction matches the request")
+
+// Cassette records requests sent by an HTTPClient with their responses to a file, and replays them later,
+// so that code calling remote APIs can be tested offline and deterministically.
+//
+// A recorded interaction matches a request with the same method, URL and body. Interactions are replayed in the order
+// they are recorded, and each one is replayed only once, so that repeated requests get the responses in turn,
+// e.g. a file read before and after it is updated.
+// The cassette works below middlewares, i.e. it records requests modified by them, and the responses they get.
+type Cassette struct {
+	// Path is the path to the cassette file
+	Path string
+
+	// Mode is CassetteModeRecord or CassetteModeReplay
+	Mode string
+
+	// RedactHeaders are the request and response headers whose values are not saved, Authorization and Set-Cookie by default
+	RedactHeaders []string
+
+	// RedactBodyFields are the fields of JSON response bodies whose values are not saved, at any depth.
+	// By default they are the common names of secrets, e.g. token and password.
+	RedactBodyFields []string
+
+	// mu protects interactions and replayed
+	mu sync.Mutex
+
+	// interactions are the recorded interactions
+	interactions []*CassetteInteraction
+
+	// replayed indicates whether each interaction has been replayed
+	replayed []bool
+}
+
+// CassetteInteraction is a request with its response recorded in a cassette.
+type CassetteInteraction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+// CassetteRequest is a recorded request.
+type CassetteRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// CassetteResponse is a recorded response.
+type CassetteResponse struct {
+	StatusCode int               `json:"status_code"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+}
+
+// NewRecordingCassette creates a cassette in record mode, which overwrites the file at the given path.
+func NewRecordingCassette(path string) *Cassette {
+	return &Cassette{
+		Path:             path,
+		Mode:             CassetteModeRecord,
+		RedactHeaders:    slices.Clone(defaultCassetteRedactHeaders),
+		RedactBodyFields: slices.Clone(defaultCassetteRedactBodyFields),
+	}
+}
+
+// LoadCassette loads a cassette in replay mode from the file at the given path.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Err(err).Msgf("[LoadCassette] Failed to read cassette, path: %s", path)
+		return nil, err
+	}
+	var interactions []*CassetteInteraction
+	if err := sonic.Unmarshal(data, &interactions); err != nil {
+		log.Err(err).Msgf("[LoadCassette] Failed to decode cassette, path: %s", path)
+		return nil, fmt.Errorf("failed to decode cassette %s: %w", path, err)
+	}
+	return &Cassette{
+		Path:             path,
+		Mode:             CassetteModeReplay,
+		RedactHeaders:    slices.Clone(defaultCassetteRedactHeaders),
+		RedactBodyFields: slices.Clone(defaultCassetteRedactBodyFields),
+		interactions:     interactions,
+		replayed:         make([]bool, len(interactions)),
+	}, nil
+}
+
+// roundTrip replays the response of the request in replay mode.
+// In record mode, it sends the request by send, and records the request with its response.
+func (c *Cassette) roundTrip(ctx context.Context, req *protocol.Request, resp *protocol.Response, send func(ctx context.Context, req *protocol.Request, resp *protocol.Response) error) error {
+	switch c.Mode {
+	case CassetteModeReplay:
+		return c.replay(req, resp)
+	case CassetteModeRecord:
+		if err := send(ctx, req, resp); err != nil {
+			return err
+		}
+		return c.record(req, resp)
+	default:
+		return fmt.Errorf("unknown cassette mode: %s", c.Mode)
+	}
+}
+
+// replay fills resp with the first unreplayed interaction matching the request.
+func (c *Cassette) replay(req *protocol.Request, resp *protocol.Response) error {
+	method, url, body := string(req.Method()), req.URI().String(), string(req.Body())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, interaction := range c.interactions {
+		if c.replayed[i] || interaction.Request.Method != method || interaction.Request.URL != url || interaction.Request.Body != body {
+			continue
+		}
+		c.replayed[i] = true
+		resp.SetStatusCode(interaction.Response.StatusCode)
+		for key, value := range interaction.Response.Headers {
+			resp.Header.Set(key, value)
+		}
+		resp.SetBody([]byte(interaction.Response.Body))
+		log.Debug().Msgf("[Cassette.replay] Replayed interaction %d, URL: %s, method: %s", i, url, method)
+		return nil
+	}
+	log.Error().Msgf("[Cassette.replay] No recorded interaction matches the request, URL: %s, method: %s, cassette: %s", url, method, c.Path)
+	return fmt.Errorf("%w: %s %s", ErrCassetteInteractionNotFound, method, url)
+}
+
+// record appends the request with its response to the cassette, and saves the cassette.
+func (c *Cassette) record(req *protocol.Request, resp *protocol.Response) error {
+	interaction := &CassetteInteraction{
+		Request: CassetteRequest{
+			Method:  string(req.Method()),
+			URL:     req.URI().String(),
+			Headers: make(map[string]string),
+			Body:    string(req.Body()),
+		},
+		Response: CassetteResponse{
+			StatusCode: resp.StatusCode(),
+			Headers:    make(map[string]string),
+			Body:       c.redactBody(resp.Body()),
+		},
+	}
+	req.Header.VisitAll(func(key, value []byte) {
+		c.recordHeader(interaction.Request.Headers, key, value)
+	})
+	resp.Header.VisitAll(func(key, value []byte) {
+		c.recordHeader(interaction.Response.Headers, key, value)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interactions = append(c.interactions, interaction)
+	c.replayed = append(c.replayed, false)
+	return c.save()
+}
+
+// recordHeader adds a header to the recorded headers, redacting its value if it is in RedactHeaders.
+func (c *Cassette) recordHeader(headers map[string]string, key, value []byte) {
+	name := textproto.CanonicalMIMEHeaderKey(string(key))
+	if slices.ContainsFunc(c.RedactHeaders, func(redacted string) bool {
+		return textproto.CanonicalMIMEHeaderKey(redacted) == name
+	}) {
+		headers[name] = cassetteRedacted
+		return
+	}
+	headers[name] = string(value)
+}
+
+// redactBody returns the response body to record, with the values of RedactBodyFields redacted if it is JSON.
+// Other bodies, and JSON bodies without such fields, are recorded as is.
+func (c *Cassette) redactBody(body []byte) string {
+	if len(c.RedactBodyFields) == 0 {
+		return string(body)
+	}
+	var value any
+	if err := sonic.Unmarshal(body, &value); err != nil {
+		return string(body)
+	}
+	if !c.redactValue(value) {
+		return string(body)
+	}
+	redacted, err := sonic.ConfigStd.Marshal(value)
+	if err != nil {
+		// never save the secrets even if the body cannot be encoded again
+		return cassetteRedacted
+	}
+	return string(redacted)
+}
+
+// redactValue replaces the values of RedactBodyFields in the decoded JSON value in place.
+// It returns whether anything is redacted.
+func (c *Cassette) redactValue(value any) bool {
+	redacted := false
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if slices.ContainsFunc(c.RedactBodyFields, func(name string) bool {
+				return strings.EqualFold(name, key)
+			}) {
+				v[key] = cassetteRedacted
+				redacted = true
+				continue
+			}
+			redacted = c.redactValue(field) || redacted
+		}
+	case []any:
+		for _, item := range v {
+			redacted = c.redactValue(item) || redacted
+		}
+	}
+	return redacted
+}
+
+// save writes all interactions to the cassette file.
+// The caller must hold c.mu.
+func (c *Cassette) save() error {
+	data, err := sonic.ConfigStd.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.Path, data, 0o644); err != nil {
+		log.Err(err).Msgf("[Cassette.save] Failed to save cassette, path: %s", c.Path)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		requests++
+		w.Header().Set("Set-Cookie", "session=secret-cookie")
+		w.Header().Set("X-Request-Count", fmt.Sprint(requests))
+		fmt.Fprintf(w, `{"count":%d,"query":%q,"token":"secret-token","nested":[{"password":"secret-password"}]}`, requests, r.URL.RawQuery)
+	}))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	headers := map[string]string{"Authorization": "token secret-pat"}
+	queryParams := map[string]string{"ref": "main"}
+
+	recorder, err := NewHTTPClient(server.URL, []string{"X-Request-Count"}, nil, WithCassette(NewRecordingCassette(path)))
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+	var recorded []string
+	for range 2 {
+		statusCode, _, body, err := recorder.PerformRequest(context.Background(), "/repos/{owner}", "GET", headers, map[string]string{"owner": "octo"}, queryParams, nil)
+		if err != nil || statusCode != nethttp.StatusOK {
+			t.Fatalf("PerformRequest() = %d, %v", statusCode, err)
+		}
+		recorded = append(recorded, string(body))
+	}
+	server.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read cassette: %v", err)
+	}
+	for _, secret := range []string{"secret-pat", "secret-cookie", "secret-token", "secret-password"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, data)
+		}
+	}
+
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette() error = %v", err)
+	}
+	replayer, err := NewHTTPClient(server.URL, []string{"X-Request-Count"}, nil, WithCassette(cassette))
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+	for i := range 2 {
+		statusCode, respHeaders, body, err := replayer.PerformRequest(context.Background(), "/repos/{owner}", "GET", headers, map[string]string{"owner": "octo"}, queryParams, nil)
+		if err != nil || statusCode != nethttp.StatusOK {
+			t.Fatalf("replayed PerformRequest() = %d, %v", statusCode, err)
+		}
+		if want := fmt.Sprint(i + 1); respHeaders["X-Request-Count"] != want {
+			t.Errorf("replayed X-Request-Count = %q, want %q", respHeaders["X-Request-Count"], want)
+		}
+		if want := fmt.Sprintf(`"count":%d`, i+1); !strings.Contains(string(body), want) || !strings.Contains(string(body), `"query":"ref=main"`) {
+			t.Errorf("replayed body = %s, recorded %s", body, recorded[i])
+		}
+	}
+
+	// every interaction is replayed only once
+	_, _, _, err = replayer.PerformRequest(context.Background(), "/repos/{owner}", "GET", headers, map[string]string{"owner": "octo"}, queryParams, nil)
+	if !errors.Is(err, ErrCassetteInteractionNotFound) {
+		t.Errorf("third replay error = %v, want %v", err, ErrCassetteInteractionNotFound)
+	}
+	// requests differing in the query do not match
+	_, _, _, err = replayer.PerformRequest(context.Background(), "/repos/{owner}", "GET", headers, map[string]string{"owner": "octo"}, map[string]string{"ref": "dev"}, nil)
+	if !errors.Is(err, ErrCassetteInteractionNotFound) {
+		t.Errorf("replay with another query error = %v, want %v", err, ErrCassetteInteractionNotFound)
+	}
+}
+
+func TestCassetteRedactBody(t *testing.T) {
+	cassette := NewRecordingCassette("")
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"not JSON", "token=secret", "token=secret"},
+		{"no secrets", `{"b":1,  "a":2}`, `{"b":1,  "a":2}`},
+		{"top level", `{"token":"secret","expires_at":"2026-01-01T00:00:00Z"}`, `{"expires_at":"2026-01-01T00:00:00Z","token":"REDACTED"}`},
+		{"nested", `[{"user":{"Password":"secret"}}]`, `[{"user":{"Password":"REDACTED"}}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cassette.redactBody([]byte(tt.body)); got != tt.want {
+				t.Errorf("redactBody(%s) = %s, want %s", tt.body, got, tt.want)
+			}
+		})
+	}
+}
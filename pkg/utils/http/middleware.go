@@ -2,13 +2,12 @@ package http
 
 import (
 	"fmt"
-	"io"
-	"os"
+	"maps"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"go.starlark.net/starlark"
-	"go.starlark.net/syntax"
-	"maps"
 )
 
 // HTTPClientMiddleware defines the interface for HTTP client middleware.
@@ -21,6 +20,24 @@ type HTTPClientMiddleware interface {
 	HandleRequest(path, method string, headers map[string]string, pathParams, queryParams map[string]string, body []byte) (resPath, resMethod string, resHeaders map[string]string, resPathParams, resQueryParams map[string]string, resBody []byte, err error)
 }
 
+// HTTPClientResponseHandler processes the HTTP response of a request.
+// It takes the status code, the captured headers, and the body of the response as input.
+// It returns the modified status code, headers, body, and an error if any.
+// Unlike HandleRequest, an error fails the request, so that it can be used to map responses to errors.
+type HTTPClientResponseHandler func(statusCode int, headers map[string]string, body []byte) (resStatusCode int, resHeaders map[string]string, resBody []byte, err error)
+
+// HTTPClientResponseMiddleware is an HTTPClientMiddleware which also processes the HTTP response.
+// HTTPClient calls HandleRequestForResponse instead of HandleRequest on a middleware implementing this interface,
+// so that middlewares handling requests only keep working as is.
+// Response handlers are run in the reverse order of request middlewares, i.e. the first middleware sees the response last.
+type HTTPClientResponseMiddleware interface {
+	HTTPClientMiddleware
+
+	// HandleRequestForResponse processes the HTTP request as HandleRequest does, and returns the handler of its response,
+	// or nil to keep the response as is. The handler is bound to the request, so it can use what is computed for it.
+	HandleRequestForResponse(path, method string, headers map[string]string, pathParams, queryParams map[string]string, body []byte) (resPath, resMethod string, resHeaders map[string]string, resPathParams, resQueryParams map[string]string, resBody []byte, handleResponse HTTPClientResponseHandler, err error)
+}
+
 // EmptyHTTPClientMiddlewareSlice returns an empty slice of HTTPClientMiddleware.
 // It is used when a HTTP Client has no middleware.
 func EmptyHTTPClientMiddlewareSlice() []HTTPClientMiddleware {
@@ -29,8 +46,9 @@ func EmptyHTTPClientMiddlewareSlice() []HTTPClientMiddleware {
 
 // HTTPClientScriptMiddleware is a middleware that runs a Starlark script to handle HTTP requests.
 // The script can modify the request and response by returning modified values for headers, path parameters, query parameters, and body.
-// The script should define global variables "headers", "pathParams", "queryParams", and "body" to return the modified values.
-// headers, pathParams, and queryParams should be a Dict, and body should be a string.
+// The script should define global variables "headers", "pathParams", "queryParams", and "body" to return the modified values,
+// and may define "path" and "method" to override them.
+// headers, pathParams, and queryParams should be a Dict, and the others should be strings.
 // For example:
 //
 //	# Example Starlark script
@@ -39,6 +57,52 @@ func EmptyHTTPClientMiddlewareSlice() []HTTPClientMiddleware {
 //	queryParams = {"search": "new_query"}
 //	body = "[1, 2, 3]"
 //
+// The current request is predeclared as "path", "method", "headers", "pathParams", "queryParams" and "body",
+// of the same types as the global variables above, so that the script can react to it.
+// headers, pathParams and queryParams are copies which the script can modify in place, and the modified values
+// are used unless the script defines the global variable of the same name. For example, to sign the request:
+//
+//	timestamp = str(time.now().unix)
+//	headers["X-Timestamp"] = timestamp
+//	headers["X-Signature"] = hmac_sha256(env("API_SECRET"), method + path + timestamp + body)
+//
+// A name assigned at the top level refers to the global variable everywhere in the script, so a script overriding
+// e.g. path cannot read the predeclared one. The request is also predeclared as "request", a frozen Dict with the keys
+// above, for such scripts:
+//
+//	path = "/v2" + request["path"]
+//
+// The builtins in scriptBuiltins (env, sha256, hmac_sha256 and time) are predeclared too.
+//
+// The script can also handle the response by defining a function named "handleResponse". It is called with a Dict
+// with keys "path", "method", "statusCode", "headers" and "body", and may return a Dict with (some of) "statusCode",
+// "headers" and "body" to modify the response, or None to keep it as is. Calling fail() in it fails the request.
+// It is taken from the globals of the run for the request, so it sees the same predeclared request and global variables.
+// For example:
+//
+//	def handleResponse(response):
+//	    if response["statusCode"] == 404:
+//	        return {"statusCode": 200, "body": "{}"}
+//	    return None
+//
+// The script above follows the global-variable convention, and its top level is run for every request.
+// A script defining a function named "handle_request" follows the function-call convention instead: its top level is
+// run only once when it is loaded (with the predeclared request values above set to None), and handle_request is called with the request Dict (a mutable copy) for every request.
+// It returns a Dict with (some of) the keys of the global variables above, or None to keep the request as is.
+// The response is handled by the function named "handle_response" in this convention. For example:
+//
+//	secret = env("API_SECRET")
+//
+//	def handle_request(req):
+//	    req["headers"]["X-Signature"] = hmac_sha256(secret, req["body"])
+//	    return {"headers": req["headers"]}
+//
+//	def handle_response(resp):
+//	    return None
+//
+// Each run of the script is stopped after MaxExecutionSteps steps or Timeout, whichever comes first.
+// The script is compiled once when the middleware is created, and can be reloaded by Reload, or by Watch when the file changes.
+//
 // It can be used for logging, authentication, modifying headers, etc.
 // For how to write Starlark scripts, see: https://github.com/google/starlark-go/blob/master/doc/spec.md
 type HTTPClientScriptMiddleware struct {
@@ -48,31 +112,38 @@ type HTTPClientScriptMiddleware struct {
 	// When executing the script, the content is used, and the path is used only for logging.
 	ScriptPath string `json:"scriptPath"`
 
-	// Script is the content of the Starlark script.
+	// Script is the content of the Starlark script, when the middleware is created.
 	Script []byte `json:"script"`
+
+	// MaxExecutionSteps is the limit of execution steps of each run of the script, and zero means no limit.
+	MaxExecutionSteps uint64 `json:"maxExecutionSteps"`
+
+	// Timeout is the timeout of each run of the script, and zero means no timeout.
+	Timeout time.Duration `json:"timeout"`
+
+	// compiled is the compiled script in use, replaced when the script is reloaded
+	compiled atomic.Pointer[compiledScript]
 }
 
 // NewHTTPClientMiddleware creates a new HTTPClientScriptMiddleware.
 // It takes script path as a parameter and returns an instance of HTTPClientScriptMiddleware.
+// The script is compiled at once, with the default limits DefaultScriptMaxExecutionSteps and DefaultScriptTimeout.
 func NewHTTPClientScriptMiddleware(scriptPath string) *HTTPClientScriptMiddleware {
-	// Load the script
-	file, err := os.Open(scriptPath)
-	if err != nil {
-		log.Err(err).Msgf("[NewHTTPClientScriptMiddleware] Failed to open file: %s", scriptPath)
-		return nil
+	m := &HTTPClientScriptMiddleware{
+		ScriptPath:        scriptPath,
+		MaxExecutionSteps: DefaultScriptMaxExecutionSteps,
+		Timeout:           DefaultScriptTimeout,
 	}
-	defer file.Close()
 
-	script, err := io.ReadAll(file)
+	// Load the script
+	compiled, script, err := m.loadScript(scriptPath)
 	if err != nil {
 		log.Err(err).Msgf("[NewHTTPClientScriptMiddleware] Failed to load script from path: %s", scriptPath)
 		return nil
 	}
-
-	return &HTTPClientScriptMiddleware{
-		ScriptPath: scriptPath,
-		Script:     script,
-	}
+	m.Script = script
+	m.compiled.Store(compiled)
+	return m
 }
 
 // HandleRequest runs the Starlark script to handle the request.
@@ -80,27 +151,105 @@ func NewHTTPClientScriptMiddleware(scriptPath string) *HTTPClientScriptMiddlewar
 // The script should define global variables "headers", "pathParams", "queryParams", and "body" to return the modified values.
 // It returns the modified request path, method, headers, path parameters, query parameters, body, and an error if any.
 func (m *HTTPClientScriptMiddleware) HandleRequest(path, method string, headers map[string]string, pathParams, queryParams map[string]string, body []byte) (resPath, resMethod string, resHeaders map[string]string, resPathParams, resQueryParams map[string]string, resBody []byte, err error) {
-	// Try to run the script
-	thread := &starlark.Thread{Name: "http_middleware_script"}
-	fileOptions := syntax.LegacyFileOptions()
-	globals, err := starlark.ExecFileOptions(fileOptions, thread, m.ScriptPath, m.Script, nil)
+	resPath, resMethod, resHeaders, resPathParams, resQueryParams, resBody, _, err = m.HandleRequestForResponse(path, method, headers, pathParams, queryParams, body)
+	return resPath, resMethod, resHeaders, resPathParams, resQueryParams, resBody, err
+}
+
+// HandleRequestForResponse runs the Starlark script to handle the request as HandleRequest does,
+// and returns the function defined in the script to handle the response, i.e. "handle_response" in the function-call
+// convention, or "handleResponse" in the global-variable convention. It returns a nil handler if the script does not define it,
+// or if the script fails to run, so the response is kept as is.
+func (m *HTTPClientScriptMiddleware) HandleRequestForResponse(path, method string, headers map[string]string, pathParams, queryParams map[string]string, body []byte) (resPath, resMethod string, resHeaders map[string]string, resPathParams, resQueryParams map[string]string, resBody []byte, handleResponse HTTPClientResponseHandler, err error) {
+	compiled := m.compiled.Load()
+	request := newScriptRequest(path, method, headers, pathParams, queryParams, body)
+	requestValues := newScriptRequestValues(path, method, headers, pathParams, queryParams, body)
+	results, handlerName, handler, err := m.runRequest(compiled, request, requestValues)
 	if err != nil {
-		log.Err(err).Msg("[HTTPClientScriptMiddleware.HandleRequest] Failed to execute script")
-		return path, method, headers, pathParams, queryParams, body, err
+		return path, method, headers, pathParams, queryParams, body, nil, err
+	}
+	path, method, headers, pathParams, queryParams, body, err = applyScriptResults(results, path, method, headers, pathParams, queryParams, body)
+	return path, method, headers, pathParams, queryParams, body, m.newResponseHandler(handlerName, handler, path, method), err
+}
+
+// runRequest runs the script for the request.
+// requestValues are the parts of the request predeclared in the global-variable convention, see newScriptRequestValues.
+// It returns the results of the script, i.e. the global variables in the global-variable convention
+// (falling back to requestValues, which the script may have modified), or the Dict returned by handle_request
+// in the function-call convention (nil if it returns None),
+// together with the name and the value of the response handler (nil if it is not defined).
+func (m *HTTPClientScriptMiddleware) runRequest(compiled *compiledScript, request *starlark.Dict, requestValues starlark.StringDict) (starlark.StringDict, string, starlark.Value, error) {
+	thread, done := m.newThread()
+	defer done()
+	if compiled.globals == nil {
+		request.Freeze()
+		globals, err := compiled.program.Init(thread, scriptPredeclared(request, requestValues))
+		if err != nil {
+			log.Err(err).Msg("[HTTPClientScriptMiddleware.runRequest] Failed to execute script")
+			return nil, "", nil, err
+		}
+		results := maps.Clone(requestValues)
+		maps.Copy(results, globals)
+		return results, scriptLegacyResponseHandlerName, globals[scriptLegacyResponseHandlerName], nil
 	}
 
+	handler := compiled.globals[scriptResponseHandlerName]
+	res, err := starlark.Call(thread, compiled.globals[scriptRequestHandlerName], starlark.Tuple{request}, nil)
+	if err != nil {
+		log.Err(err).Msgf("[HTTPClientScriptMiddleware.runRequest] Failed to run %s", scriptRequestHandlerName)
+		return nil, "", nil, err
+	}
+	if res == starlark.None {
+		return nil, scriptResponseHandlerName, handler, nil
+	}
+	resDict, isMap := res.(*starlark.Dict)
+	if !isMap {
+		log.Warn().Msgf("[HTTPClientScriptMiddleware.runRequest] %s returns neither a map nor None: %s", scriptRequestHandlerName, res.String())
+		return nil, scriptResponseHandlerName, handler, nil
+	}
+	results := make(starlark.StringDict, resDict.Len())
+	for _, item := range resDict.Items() {
+		if key, isStr := item[0].(starlark.String); isStr {
+			results[key.GoString()] = item[1]
+		}
+	}
+	return results, scriptResponseHandlerName, handler, nil
+}
+
+// applyScriptResults applies the results of the script for the request, see runRequest.
+// It returns the modified request path, method, headers, path parameters, query parameters, body, and an error if any.
+func applyScriptResults(globals starlark.StringDict, path, method string, headers map[string]string, pathParams, queryParams map[string]string, body []byte) (string, string, map[string]string, map[string]string, map[string]string, []byte, error) {
 	// Extract the results
+	if res, ok := globals["path"]; ok {
+		if str, isStr := res.(starlark.String); isStr {
+			log.Debug().Msgf("[applyScriptResults] Got path: %s", str.GoString())
+			path = str.GoString()
+		} else {
+			log.Warn().Msg("[applyScriptResults] path is not a string")
+		}
+	}
+	if res, ok := globals["method"]; ok {
+		if str, isStr := res.(starlark.String); isStr {
+			log.Debug().Msgf("[applyScriptResults] Got method: %s", str.GoString())
+			method = str.GoString()
+		} else {
+			log.Warn().Msg("[applyScriptResults] method is not a string")
+		}
+	}
+
 	if res, ok := globals["headers"]; ok {
 		if headersMap, isMap := res.(*starlark.Dict); isMap {
 			extraHeaders, err := convertStarlarkMapToStringMap(headersMap)
 			if err != nil {
-				log.Err(err).Msg("[HTTPClientScriptMiddleware.HandleRequest] Failed to convert headers map")
+				log.Err(err).Msg("[applyScriptResults] Failed to convert headers map")
 				return path, method, headers, pathParams, queryParams, body, err
 			}
-			log.Debug().Msgf("[HTTPClientScriptMiddleware.HandleRequest] Got extra headers: %v", extraHeaders)
+			log.Debug().Msgf("[applyScriptResults] Got extra headers: %v", extraHeaders)
+			if headers == nil {
+				headers = make(map[string]string, len(extraHeaders))
+			}
 			maps.Copy(headers, extraHeaders)
 		} else {
-			log.Warn().Msg("[HTTPClientScriptMiddleware.HandleRequest] headers is not a map")
+			log.Warn().Msg("[applyScriptResults] headers is not a map")
 		}
 	}
 
@@ -108,13 +257,16 @@ func (m *HTTPClientScriptMiddleware) HandleRequest(path, method string, headers
 		if pathParamsMap, isMap := res.(*starlark.Dict); isMap {
 			extraPathParams, err := convertStarlarkMapToStringMap(pathParamsMap)
 			if err != nil {
-				log.Err(err).Msg("[HTTPClientScriptMiddleware.HandleRequest] Failed to convert pathParams map")
+				log.Err(err).Msg("[applyScriptResults] Failed to convert pathParams map")
 				return path, method, headers, pathParams, queryParams, body, err
 			}
-			log.Debug().Msgf("[HTTPClientScriptMiddleware.HandleRequest] Got extra path params: %v", extraPathParams)
+			log.Debug().Msgf("[applyScriptResults] Got extra path params: %v", extraPathParams)
+			if pathParams == nil {
+				pathParams = make(map[string]string, len(extraPathParams))
+			}
 			maps.Copy(pathParams, extraPathParams)
 		} else {
-			log.Warn().Msg("[HTTPClientScriptMiddleware.HandleRequest] pathParams is not a map")
+			log.Warn().Msg("[applyScriptResults] pathParams is not a map")
 		}
 	}
 
@@ -122,30 +274,139 @@ func (m *HTTPClientScriptMiddleware) HandleRequest(path, method string, headers
 		if queryParamsMap, isMap := res.(*starlark.Dict); isMap {
 			extraQueryParams, err := convertStarlarkMapToStringMap(queryParamsMap)
 			if err != nil {
-				log.Err(err).Msg("[HTTPClientScriptMiddleware.HandleRequest] Failed to convert queryParams map")
+				log.Err(err).Msg("[applyScriptResults] Failed to convert queryParams map")
 				return path, method, headers, pathParams, queryParams, body, err
 			}
-			log.Debug().Msgf("[HTTPClientScriptMiddleware.HandleRequest] Got extra query params: %v", extraQueryParams)
+			log.Debug().Msgf("[applyScriptResults] Got extra query params: %v", extraQueryParams)
+			if queryParams == nil {
+				queryParams = make(map[string]string, len(extraQueryParams))
+			}
 			maps.Copy(queryParams, extraQueryParams)
 		} else {
-			log.Warn().Msg("[HTTPClientScriptMiddleware.HandleRequest] queryParams is not a map")
+			log.Warn().Msg("[applyScriptResults] queryParams is not a map")
 		}
 	}
 	if res, ok := globals["body"]; ok {
 		if str, isStr := res.(starlark.String); isStr {
 			// Use GoString() to get the raw string value without extra quotes
 			rawBody := string(str.GoString())
-			log.Debug().Msgf("[HTTPClientScriptMiddleware.HandleRequest] Got body: %s", rawBody)
+			log.Debug().Msgf("[applyScriptResults] Got body: %s", rawBody)
 			body = []byte(rawBody)
 		} else {
 			// Handle non-string body values (e.g., numbers, lists, etc.)
-			log.Warn().Msgf("[HTTPClientScriptMiddleware.HandleRequest] Body is not a string: %s", res.String())
+			log.Warn().Msgf("[applyScriptResults] Body is not a string: %s", res.String())
 			body = []byte(res.String())
 		}
 	}
 	return path, method, headers, pathParams, queryParams, body, nil
 }
 
+// newResponseHandler wraps the function defined in the Starlark script to handle the response of a request
+// with the given path and method. It returns nil if the function is not defined.
+func (m *HTTPClientScriptMiddleware) newResponseHandler(handlerName string, handler starlark.Value, path, method string) HTTPClientResponseHandler {
+	if handler == nil {
+		return nil
+	}
+	if _, isCallable := handler.(starlark.Callable); !isCallable {
+		log.Warn().Msgf("[HTTPClientScriptMiddleware.newResponseHandler] %s is not a function", handlerName)
+		return nil
+	}
+	return func(statusCode int, headers map[string]string, body []byte) (int, map[string]string, []byte, error) {
+		return m.handleResponse(handlerName, handler, path, method, statusCode, headers, body)
+	}
+}
+
+// handleResponse calls the function defined in the Starlark script to handle the response.
+// It returns the modified status code, headers, body, and an error if any.
+func (m *HTTPClientScriptMiddleware) handleResponse(handlerName string, handler starlark.Value, path, method string, statusCode int, headers map[string]string, body []byte) (resStatusCode int, resHeaders map[string]string, resBody []byte, err error) {
+	thread, done := m.newThread()
+	defer done()
+	response := starlark.NewDict(5)
+	for key, value := range map[string]starlark.Value{
+		"path":       starlark.String(path),
+		"method":     starlark.String(method),
+		"statusCode": starlark.MakeInt(statusCode),
+		"headers":    convertStringMapToStarlarkMap(headers),
+		"body":       starlark.String(body),
+	} {
+		if err := response.SetKey(starlark.String(key), value); err != nil {
+			return statusCode, headers, body, err
+		}
+	}
+	res, err := starlark.Call(thread, handler, starlark.Tuple{response}, nil)
+	if err != nil {
+		log.Err(err).Msgf("[HTTPClientScriptMiddleware.handleResponse] Failed to run %s", handlerName)
+		return statusCode, headers, body, err
+	}
+	if res == starlark.None {
+		return statusCode, headers, body, nil
+	}
+	resDict, isMap := res.(*starlark.Dict)
+	if !isMap {
+		log.Warn().Msgf("[HTTPClientScriptMiddleware.handleResponse] %s returns neither a map nor None: %s", handlerName, res.String())
+		return statusCode, headers, body, nil
+	}
+
+	// Extract the results
+	if value, found, _ := resDict.Get(starlark.String("statusCode")); found {
+		code, err := starlark.AsInt32(value)
+		if err != nil {
+			log.Err(err).Msg("[HTTPClientScriptMiddleware.handleResponse] statusCode is not an integer")
+			return statusCode, headers, body, err
+		}
+		log.Debug().Msgf("[HTTPClientScriptMiddleware.handleResponse] Got status code: %d", code)
+		statusCode = code
+	}
+	if value, found, _ := resDict.Get(starlark.String("headers")); found {
+		if headersMap, isMap := value.(*starlark.Dict); isMap {
+			newHeaders, err := convertStarlarkMapToStringMap(headersMap)
+			if err != nil {
+				log.Err(err).Msg("[HTTPClientScriptMiddleware.handleResponse] Failed to convert headers map")
+				return statusCode, headers, body, err
+			}
+			log.Debug().Msgf("[HTTPClientScriptMiddleware.handleResponse] Got headers: %v", newHeaders)
+			headers = newHeaders
+		} else {
+			log.Warn().Msg("[HTTPClientScriptMiddleware.handleResponse] headers is not a map")
+		}
+	}
+	if value, found, _ := resDict.Get(starlark.String("body")); found {
+		if str, isStr := value.(starlark.String); isStr {
+			body = []byte(str.GoString())
+		} else {
+			log.Warn().Msgf("[HTTPClientScriptMiddleware.handleResponse] Body is not a string: %s", value.String())
+			body = []byte(value.String())
+		}
+	}
+	return statusCode, headers, body, nil
+}
+
+// newScriptRequest converts a request to the Dict given to scripts, which is frozen when predeclared as "request".
+func newScriptRequest(path, method string, headers map[string]string, pathParams, queryParams map[string]string, body []byte) *starlark.Dict {
+	request := starlark.NewDict(6)
+	// setting a string key never fails
+	_ = request.SetKey(starlark.String("path"), starlark.String(path))
+	_ = request.SetKey(starlark.String("method"), starlark.String(method))
+	_ = request.SetKey(starlark.String("headers"), convertStringMapToStarlarkMap(headers))
+	_ = request.SetKey(starlark.String("pathParams"), convertStringMapToStarlarkMap(pathParams))
+	_ = request.SetKey(starlark.String("queryParams"), convertStringMapToStarlarkMap(queryParams))
+	_ = request.SetKey(starlark.String("body"), starlark.String(body))
+	return request
+}
+
+// newScriptRequestValues converts a request to the values predeclared for each part of it, keyed by scriptRequestNames.
+// The Dicts are copies of the ones in the request Dict, so that they stay mutable when the request Dict is frozen.
+func newScriptRequestValues(path, method string, headers map[string]string, pathParams, queryParams map[string]string, body []byte) starlark.StringDict {
+	return starlark.StringDict{
+		"path":        starlark.String(path),
+		"method":      starlark.String(method),
+		"headers":     convertStringMapToStarlarkMap(headers),
+		"pathParams":  convertStringMapToStarlarkMap(pathParams),
+		"queryParams": convertStringMapToStarlarkMap(queryParams),
+		"body":        starlark.String(body),
+	}
+}
+
 // Helper function to convert a Starlark map to a Go map[string]string
 func convertStarlarkMapToStringMap(starlarkMap *starlark.Dict) (map[string]string, error) {
 	goMap := make(map[string]string)
@@ -170,3 +431,13 @@ func convertStarlarkMapToStringMap(starlarkMap *starlark.Dict) (map[string]strin
 	}
 	return goMap, nil
 }
+
+// Helper function to convert a Go map[string]string to a Starlark map
+func convertStringMapToStarlarkMap(goMap map[string]string) *starlark.Dict {
+	starlarkMap := starlark.NewDict(len(goMap))
+	for key, value := range goMap {
+		// setting a string key never fails
+		_ = starlarkMap.SetKey(starlark.String(key), starlark.String(value))
+	}
+	return starlarkMap
+}
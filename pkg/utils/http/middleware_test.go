@@ -0,0 +1,245 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.starlark.net/resolve"
+)
+
+// newTestScriptMiddleware writes the script to a temporary file, and creates a middleware running it.
+func newTestScriptMiddleware(t *testing.T, script string) *HTTPClientScriptMiddleware {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "middleware.star")
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	m := NewHTTPClientScriptMiddleware(path)
+	if m == nil {
+		t.Fatalf("NewHTTPClientScriptMiddleware(%q) = nil", script)
+	}
+	return m
+}
+
+func TestHTTPClientScriptMiddlewareResponse(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(nethttp.StatusNotFound)
+		fmt.Fprintf(w, "signature=%s", r.Header.Get("X-Signature"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		script         string
+		wantStatusCode int
+		wantBody       string
+		wantErr        bool
+	}{
+		{
+			name: "global variables reused by handleResponse",
+			script: `signature = sha256(request["headers"]["X-User"])
+headers = {"X-Signature": signature}
+
+def handleResponse(response):
+    if response["statusCode"] == 404 and response["body"] == "signature=" + signature:
+        return {"statusCode": 200, "body": request["headers"]["X-User"] + " " + response["method"] + " " + response["path"]}
+    return None
+`,
+			wantStatusCode: nethttp.StatusOK,
+			wantBody:       "alice GET /users/{id}",
+		},
+		{
+			name:           "no handleResponse",
+			script:         `headers = {"X-Signature": "static"}`,
+			wantStatusCode: nethttp.StatusNotFound,
+			wantBody:       "signature=static",
+		},
+		{
+			name: "top-level error",
+			script: `fail("broken")
+
+def handleResponse(response):
+    return {"statusCode": 200}
+`,
+			wantStatusCode: nethttp.StatusNotFound,
+			wantBody:       "signature=",
+		},
+		{
+			name: "handle_response",
+			script: `def handle_request(req):
+    return {"headers": {"X-Signature": req["headers"]["X-User"]}}
+
+def handle_response(resp):
+    return {"body": resp["body"].upper()}
+`,
+			wantStatusCode: nethttp.StatusNotFound,
+			wantBody:       "SIGNATURE=ALICE",
+		},
+		{
+			name: "fail in handleResponse",
+			script: `def handleResponse(response):
+    fail("not found")
+`,
+			wantStatusCode: nethttp.StatusNotFound,
+			wantBody:       "signature=",
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestScriptMiddleware(t, tt.script)
+			client, err := NewHTTPClient(server.URL, nil, []HTTPClientMiddleware{m})
+			if err != nil {
+				t.Fatalf("NewHTTPClient() error = %v", err)
+			}
+			statusCode, _, body, err := client.PerformRequest(context.Background(), "/users/{id}", "GET", map[string]string{"X-User": "alice"}, map[string]string{"id": "1"}, nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PerformRequest() error = %v, want error %v", err, tt.wantErr)
+			}
+			if statusCode != tt.wantStatusCode || string(body) != tt.wantBody {
+				t.Errorf("PerformRequest() = %d, %q, want %d, %q", statusCode, body, tt.wantStatusCode, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHTTPClientScriptMiddlewareNoResponseHandler(t *testing.T) {
+	m := newTestScriptMiddleware(t, `headers = {"X-Signature": "static"}`)
+	_, _, headers, _, _, _, handleResponse, err := m.HandleRequestForResponse("/", "GET", map[string]string{}, nil, nil, nil)
+	if err != nil || handleResponse != nil || headers["X-Signature"] != "static" {
+		t.Errorf("HandleRequestForResponse() = headers %v, handler %v, error %v, want no handler", headers, handleResponse != nil, err)
+	}
+}
+
+func TestHTTPClientScriptMiddlewareLegacyOptions(t *testing.T) {
+	original := resolve.AllowRecursion
+	t.Cleanup(func() {
+		resolve.AllowRecursion = original
+	})
+	script := `def fib(n):
+    if n < 2:
+        return n
+    return fib(n - 1) + fib(n - 2)
+
+headers = {"X-Fib": str(fib(10))}
+`
+
+	// the legacy flags of the resolve package still apply
+	resolve.AllowRecursion = true
+	m := newTestScriptMiddleware(t, script)
+	_, _, headers, _, _, _, err := m.HandleRequest("/", "GET", map[string]string{}, nil, nil, nil)
+	if err != nil || headers["X-Fib"] != "55" {
+		t.Errorf("HandleRequest() = headers %v, error %v, want X-Fib 55", headers, err)
+	}
+
+	// recursion is an error by default, which is found when the script runs
+	resolve.AllowRecursion = false
+	m = newTestScriptMiddleware(t, script)
+	if _, _, _, _, _, _, err := m.HandleRequest("/", "GET", map[string]string{}, nil, nil, nil); err == nil {
+		t.Error("HandleRequest() of a recursive script without AllowRecursion succeeded, want an error")
+	}
+}
+
+func TestHTTPClientScriptMiddlewareWatch(t *testing.T) {
+	m := newTestScriptMiddleware(t, `headers = {"X-Version": "1"}`)
+	stop := m.Watch(10 * time.Millisecond)
+	defer stop()
+
+	version := func() string {
+		_, _, headers, _, _, _, _ := m.HandleRequest("/", "GET", map[string]string{}, nil, nil, nil)
+		return headers["X-Version"]
+	}
+	// waitFor writes the script with a newer modification time, and waits for the watcher to pick it up
+	modTime := time.Now()
+	waitFor := func(script, want string) {
+		t.Helper()
+		modTime = modTime.Add(time.Second)
+		if err := os.WriteFile(m.ScriptPath, []byte(script), 0o644); err != nil {
+			t.Fatalf("failed to write script: %v", err)
+		}
+		if err := os.Chtimes(m.ScriptPath, modTime, modTime); err != nil {
+			t.Fatalf("failed to touch script: %v", err)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for version() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("version = %q after the script changed, want %q", version(), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor(`headers = {"X-Version": "2"}`, "2")
+
+	// a broken script is not loaded, and the one in use is kept until the file changes again
+	waitFor(`headers = {`, "2")
+	time.Sleep(50 * time.Millisecond)
+	if got := version(); got != "2" {
+		t.Errorf("version = %q after a broken change, want the script in use kept", got)
+	}
+	waitFor(`headers = {"X-Version": "3"}`, "3")
+}
+
+func TestHTTPClientScriptMiddlewarePredeclaredRequest(t *testing.T) {
+	tests := []struct {
+		name            string
+		script          string
+		wantPath        string
+		wantHeaders     map[string]string
+		wantQueryParams map[string]string
+	}{
+		{
+			name:        "read the request",
+			script:      `headers = {"X-Request": method + " " + path + " " + pathParams["id"] + " " + body}`,
+			wantPath:    "/users/{id}",
+			wantHeaders: map[string]string{"X-User": "alice", "X-Request": "POST /users/{id} 1 {}"},
+		},
+		{
+			name: "modify in place",
+			script: `headers["X-User"] = headers["X-User"].upper()
+queryParams["page"] = "2"
+`,
+			wantPath:        "/users/{id}",
+			wantHeaders:     map[string]string{"X-User": "ALICE"},
+			wantQueryParams: map[string]string{"page": "2"},
+		},
+		{
+			name:        "override path",
+			script:      `path = "/v2" + request["path"]`,
+			wantPath:    "/v2/users/{id}",
+			wantHeaders: map[string]string{"X-User": "alice"},
+		},
+		{
+			name: "function-call convention",
+			script: `prefix = str(path)
+
+def handle_request(req):
+    return {"headers": {"X-Prefix": prefix}}
+`,
+			wantPath:    "/users/{id}",
+			wantHeaders: map[string]string{"X-User": "alice", "X-Prefix": "None"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestScriptMiddleware(t, tt.script)
+			path, method, headers, _, queryParams, body, err := m.HandleRequest("/users/{id}", "POST", map[string]string{"X-User": "alice"}, map[string]string{"id": "1"}, nil, []byte("{}"))
+			if err != nil {
+				t.Fatalf("HandleRequest() error = %v", err)
+			}
+			if path != tt.wantPath || method != "POST" || string(body) != "{}" {
+				t.Errorf("HandleRequest() = %s %s %q, want %s POST %q", method, path, body, tt.wantPath, "{}")
+			}
+			if !maps.Equal(headers, tt.wantHeaders) || !maps.Equal(queryParams, tt.wantQueryParams) {
+				t.Errorf("HandleRequest() headers = %v, query params = %v, want %v, %v", headers, queryParams, tt.wantHeaders, tt.wantQueryParams)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package http
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/network"
+	"github.com/cloudwego/hertz/pkg/protocol"
+)
+
+// Schemes of supported proxies.
+// HTTP and HTTPS proxies are used by Hertz itself, while SOCKS5 proxies are used by proxyDialer.
+const (
+	proxySchemeHTTP   = "http"
+	proxySchemeHTTPS  = "https"
+	proxySchemeSOCKS5 = "socks5"
+
+	// proxySchemeSOCKS5H is the same as socks5, for host names are always resolved by the proxy
+	proxySchemeSOCKS5H = "socks5h"
+)
+
+// proxySelector decides the proxy of requests to a URL.
+type proxySelector struct {
+	// proxyURL is the proxy of all requests, and the proxy is decided by the environment variables if it is nil
+	proxyURL *url.URL
+}
+
+// newProxySelector creates a proxySelector with the explicit proxy URL,
+// which may be empty to use the environment variables HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+func newProxySelector(proxyURL string) (*proxySelector, error) {
+	if proxyURL == "" {
+		return &proxySelector{}, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if err := checkProxyURL(u); err != nil {
+		return nil, err
+	}
+	return &proxySelector{proxyURL: u}, nil
+}
+
+// proxyFor returns the proxy of requests to the URL, or nil if they are sent directly.
+func (s *proxySelector) proxyFor(target *url.URL) (*url.URL, error) {
+	if s.proxyURL != nil {
+		return s.proxyURL, nil
+	}
+	// ProxyFromEnvironment honors NO_PROXY, and never proxies requests to localhost
+	proxyURL, err := nethttp.ProxyFromEnvironment(&nethttp.Request{URL: target})
+	if err != nil || proxyURL == nil {
+		return nil, err
+	}
+	if err := checkProxyURL(proxyURL); err != nil {
+		return nil, err
+	}
+	return proxyURL, nil
+}
+
+// hertzProxy returns the proxy function used by the Hertz client, which returns HTTP and HTTPS proxies only.
+func (s *proxySelector) hertzProxy() protocol.Proxy {
+	return func(req *protocol.Request) (*protocol.URI, error) {
+		target, err := url.Parse(req.URI().String())
+		if err != nil {
+			return nil, err
+		}
+		proxyURL, err := s.proxyFor(target)
+		if err != nil || proxyURL == nil {
+			return nil, err
+		}
+		if proxyURL.Scheme != proxySchemeHTTP && proxyURL.Scheme != proxySchemeHTTPS {
+			// SOCKS5 proxies are handled by proxyDialer
+			return nil, nil
+		}
+		return protocol.ParseURI(proxyURL.String()), nil
+	}
+}
+
+// checkProxyURL checks whether the scheme of the proxy is supported.
+func checkProxyURL(proxyURL *url.URL) error {
+	switch proxyURL.Scheme {
+	case proxySchemeHTTP, proxySchemeHTTPS, proxySchemeSOCKS5, proxySchemeSOCKS5H:
+		if proxyURL.Host == "" {
+			return fmt.Errorf("proxy host is empty: %s", proxyURL.Redacted())
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+	}
+}
+
+// proxyDialer is a Hertz dialer which connects through SOCKS5 proxies, and connects directly otherwise.
+// Requests through HTTP and HTTPS proxies are handled by Hertz, which asks the dialer to connect to the proxy directly.
+type proxyDialer struct {
+	network.Dialer
+
+	// selector decides the proxy of each connection
+	selector *proxySelector
+}
+
+// DialConnection connects to the address, through a SOCKS5 proxy if the selector decides so.
+func (d *proxyDialer) DialConnection(n, address string, timeout time.Duration, tlsConfig *tls.Config) (network.Conn, error) {
+	// the dialer only knows the address, so the URL is rebuilt to select the proxy
+	target := &url.URL{Scheme: proxySchemeHTTP, Host: address}
+	if tlsConfig != nil {
+		target.Scheme = proxySchemeHTTPS
+	}
+	proxyURL, err := d.selector.proxyFor(target)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil || (proxyURL.Scheme != proxySchemeSOCKS5 && proxyURL.Scheme != proxySchemeSOCKS5H) {
+		return d.Dialer.DialConnection(n, address, timeout, tlsConfig)
+	}
+
+	conn, err := d.Dialer.DialConnection(n, proxyURL.Host, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := socks5Connect(conn, address, proxyURL.User); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect through SOCKS5 proxy %s: %w", proxyURL.Host, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if tlsConfig == nil {
+		return conn, nil
+	}
+	return d.Dialer.AddTLS(conn, tlsConfig)
+}
+
+// socks5Connect asks the SOCKS5 proxy connected by conn to connect to the address,
+// authenticating by username and password if user is not nil, see RFC 1928 and RFC 1929.
+func socks5Connect(conn io.ReadWriter, address string, user *url.Userinfo) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", portStr)
+	}
+
+	// negotiate the authentication method
+	methods := []byte{0x00}
+	if user != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("unexpected SOCKS version: %d", reply[0])
+	}
+	switch reply[1] {
+	case 0x00:
+		// no authentication required
+	case 0x02:
+		if user == nil {
+			return errors.New("proxy requires authentication")
+		}
+		username := user.Username()
+		password, _ := user.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("username or password is too long")
+		}
+		auth := []byte{0x01, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("authentication failed")
+		}
+	default:
+		return errors.New("no acceptable authentication method")
+	}
+
+	// send the CONNECT request, host names are resolved by the proxy
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, 0x01), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 0x04), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return errors.New("host name is too long")
+		}
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// read the reply, whose bound address is skipped
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("proxy refused to connect, reply code: %d", header[1])
+	}
+	var addrLen int
+	switch header[3] {
+	case 0x01:
+		addrLen = net.IPv4len
+	case 0x04:
+		addrLen = net.IPv6len
+	case 0x03:
+		if _, err := io.ReadFull(conn, header[:1]); err != nil {
+			return err
+		}
+		addrLen = int(header[0])
+	default:
+		return fmt.Errorf("unknown address type: %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
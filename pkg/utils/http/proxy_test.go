@@ -0,0 +1,327 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testProxy records the addresses requested through an in-process proxy, which connects all of them to backend.
+type testProxy struct {
+	// backend is the address every request is connected to, whatever the requested address is
+	backend string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+// connectBackend records the requested address, and connects to the backend.
+func (p *testProxy) connectBackend(target string) (net.Conn, error) {
+	p.mu.Lock()
+	p.targets = append(p.targets, target)
+	p.mu.Unlock()
+	return net.Dial("tcp", p.backend)
+}
+
+// requested returns the addresses requested through the proxy, in order.
+func (p *testProxy) requested() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.targets)
+}
+
+// pipe copies data between the connections until either of them is closed.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// socks5Proxy is an in-process SOCKS5 proxy, requiring username and password authentication if username is not empty.
+type socks5Proxy struct {
+	testProxy
+
+	listener           net.Listener
+	username, password string
+}
+
+// startSOCKS5Proxy starts a SOCKS5 proxy connecting every request to backend, which is closed when the test ends.
+func startSOCKS5Proxy(t *testing.T, backend, username, password string) *socks5Proxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	p := &socks5Proxy{testProxy: testProxy{backend: backend}, listener: listener, username: username, password: password}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(conn)
+		}
+	}()
+	return p
+}
+
+// url returns the URL of the proxy with the given credentials, which may be nil.
+func (p *socks5Proxy) url(user *url.Userinfo) string {
+	return (&url.URL{Scheme: proxySchemeSOCKS5, Host: p.listener.Addr().String(), User: user}).String()
+}
+
+// handle serves a connection to the proxy, see RFC 1928 and RFC 1929.
+func (p *socks5Proxy) handle(conn net.Conn) {
+	defer conn.Close()
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil
+		}
+		return b
+	}
+
+	header := readBytes(2)
+	if header == nil || header[0] != 0x05 {
+		return
+	}
+	methods := readBytes(int(header[1]))
+	method := byte(0x00)
+	if p.username != "" {
+		method = 0x02
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		_, _ = conn.Write([]byte{0x05, 0xff})
+		return
+	}
+	_, _ = conn.Write([]byte{0x05, method})
+	if method == 0x02 {
+		version := readBytes(2)
+		if version == nil {
+			return
+		}
+		username := string(readBytes(int(version[1])))
+		password := string(readBytes(int(readBytes(1)[0])))
+		if username != p.username || password != p.password {
+			_, _ = conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		_, _ = conn.Write([]byte{0x01, 0x00})
+	}
+
+	request := readBytes(4)
+	if request == nil || request[1] != 0x01 {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 0x01:
+		host = net.IP(readBytes(net.IPv4len)).String()
+	case 0x04:
+		host = net.IP(readBytes(net.IPv6len)).String()
+	case 0x03:
+		host = string(readBytes(int(readBytes(1)[0])))
+	}
+	port := binary.BigEndian.Uint16(readBytes(2))
+	backend, err := p.connectBackend(net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		_, _ = conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer backend.Close()
+	_, _ = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	pipe(conn, backend)
+}
+
+// startConnectProxy starts an HTTP proxy tunneling every CONNECT request to backend, which is closed when the test ends.
+func startConnectProxy(t *testing.T, backend string) (*httptest.Server, *testProxy) {
+	t.Helper()
+	p := &testProxy{backend: backend}
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Method != nethttp.MethodConnect {
+			nethttp.Error(w, "only CONNECT is supported", nethttp.StatusMethodNotAllowed)
+			return
+		}
+		backend, err := p.connectBackend(r.Host)
+		if err != nil {
+			nethttp.Error(w, err.Error(), nethttp.StatusBadGateway)
+			return
+		}
+		defer backend.Close()
+		conn, _, err := w.(nethttp.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+		pipe(conn, backend)
+	}))
+	t.Cleanup(server.Close)
+	return server, p
+}
+
+// startPlainServer starts an HTTP server responding "ok", which is closed when the test ends.
+func startPlainServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// exampleURL returns the URL of the server with its host replaced by example.com, which its certificate is valid for,
+// so that the request only reaches the server through a proxy.
+func exampleURL(server *httptest.Server) string {
+	u, _ := url.Parse(server.URL)
+	u.Host = net.JoinHostPort("example.com", u.Port())
+	return u.String()
+}
+
+// getThroughProxy sends a GET request to baseURL with the options, and returns the error if any.
+func getThroughProxy(t *testing.T, baseURL string, options ...HTTPClientOption) error {
+	t.Helper()
+	client, err := NewHTTPClient(baseURL, nil, nil, options...)
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	statusCode, _, body, err := client.PerformGet(ctx, "/", nil, nil, nil)
+	if err == nil && (statusCode != nethttp.StatusOK || string(body) != "ok") {
+		t.Fatalf("PerformGet() = %d, %q", statusCode, body)
+	}
+	return err
+}
+
+func TestProxySOCKS5(t *testing.T) {
+	plainServer := startPlainServer(t)
+	tlsServer, caFile := startTLSServer(t, nil)
+	tests := []struct {
+		name    string
+		server  *httptest.Server
+		options []HTTPClientOption
+	}{
+		{"plain", plainServer, nil},
+		{"TLS", tlsServer, []HTTPClientOption{WithTLSOptions(TLSOptions{CAFile: caFile})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := startSOCKS5Proxy(t, tt.server.Listener.Addr().String(), "alice", "secret")
+			baseURL := exampleURL(tt.server)
+			target := strings.TrimPrefix(strings.TrimPrefix(baseURL, "http://"), "https://")
+
+			// the host name is resolved by the proxy
+			options := append(slices.Clone(tt.options), WithProxyURL(proxy.url(url.UserPassword("alice", "secret"))))
+			if err := getThroughProxy(t, baseURL, options...); err != nil {
+				t.Fatalf("request through the proxy error = %v", err)
+			}
+			if got := proxy.requested(); !slices.Equal(got, []string{target}) {
+				t.Errorf("requested through the proxy = %q, want %q", got, target)
+			}
+
+			options = append(slices.Clone(tt.options), WithProxyURL(proxy.url(url.UserPassword("alice", "wrong"))))
+			if err := getThroughProxy(t, baseURL, options...); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+				t.Errorf("request with a wrong password error = %v, want authentication failed", err)
+			}
+			options = append(slices.Clone(tt.options), WithProxyURL(proxy.url(nil)))
+			if err := getThroughProxy(t, baseURL, options...); err == nil || !strings.Contains(err.Error(), "no acceptable authentication method") {
+				t.Errorf("request without credentials error = %v, want no acceptable authentication method", err)
+			}
+			if got := proxy.requested(); len(got) != 1 {
+				t.Errorf("requested through the proxy = %q, want nothing more after failed authentication", got)
+			}
+		})
+	}
+}
+
+func TestProxyHTTPConnect(t *testing.T) {
+	tlsServer, caFile := startTLSServer(t, nil)
+	proxyServer, proxy := startConnectProxy(t, tlsServer.Listener.Addr().String())
+	baseURL := exampleURL(tlsServer)
+
+	err := getThroughProxy(t, baseURL, WithTLSOptions(TLSOptions{CAFile: caFile}), WithProxyURL(proxyServer.URL))
+	if err != nil {
+		t.Fatalf("request through the proxy error = %v", err)
+	}
+	if got, want := proxy.requested(), []string{strings.TrimPrefix(baseURL, "https://")}; !slices.Equal(got, want) {
+		t.Errorf("requested through the proxy = %q, want %q", got, want)
+	}
+}
+
+// proxyFromEnvironmentChild is set in the environment of the child process running TestProxyFromEnvironment.
+const proxyFromEnvironmentChild = "DANTA_TEST_PROXY_FROM_ENVIRONMENT"
+
+func TestProxyFromEnvironment(t *testing.T) {
+	// net/http reads the proxy environment variables only once in a process, so the test runs in a child process
+	if os.Getenv(proxyFromEnvironmentChild) == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestProxyFromEnvironment$", "-test.v")
+		cmd.Env = append(os.Environ(), proxyFromEnvironmentChild+"=1")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("child process error = %v, output:\n%s", err, output)
+		}
+		return
+	}
+
+	tlsServer, caFile := startTLSServer(t, nil)
+	proxy := startSOCKS5Proxy(t, tlsServer.Listener.Addr().String(), "", "")
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		os.Unsetenv(name)
+	}
+	os.Setenv("HTTPS_PROXY", proxy.url(nil))
+	os.Setenv("NO_PROXY", "bypass.example,.internal.example")
+
+	selector, err := newProxySelector("")
+	if err != nil {
+		t.Fatalf("newProxySelector() error = %v", err)
+	}
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"https://example.com", proxy.url(nil)},
+		{"http://example.com", ""},
+		{"https://bypass.example", ""},
+		{"https://api.internal.example", ""},
+		{"https://localhost:8443", ""},
+	}
+	for _, tt := range tests {
+		target, _ := url.Parse(tt.target)
+		got, err := selector.proxyFor(target)
+		if err != nil {
+			t.Errorf("proxyFor(%s) error = %v", tt.target, err)
+			continue
+		}
+		if (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+			t.Errorf("proxyFor(%s) = %v, want %q", tt.target, got, tt.want)
+		}
+	}
+
+	if err := getThroughProxy(t, exampleURL(tlsServer), WithTLSOptions(TLSOptions{CAFile: caFile})); err != nil {
+		t.Fatalf("request through the proxy from the environment error = %v", err)
+	}
+	if got := proxy.requested(); len(got) != 1 {
+		t.Errorf("requested through the proxy = %q, want the request", got)
+	}
+}
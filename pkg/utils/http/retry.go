@@ -0,0 +1,126 @@
+package http
+
+import (
+	"math/rand/v2"
+	nethttp "net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// Headers used to decide when to retry a request.
+// They are always captured by PerformRequestWithRetry, in addition to HTTPClient.HeadersToCapture.
+const (
+	// HeaderRetryAfter is the standard header telling how long to wait before retrying, in seconds or as an HTTP date
+	HeaderRetryAfter = "Retry-After"
+
+	// HeaderRateLimitRemaining and HeaderRateLimitReset are Github headers telling the number of requests left,
+	// and the Unix timestamp in seconds when the rate limit resets
+	HeaderRateLimitRemaining = "X-RateLimit-Remaining"
+	HeaderRateLimitReset     = "X-RateLimit-Reset"
+)
+
+// retryHeadersToCapture are the headers captured by PerformRequestWithRetry to decide retries
+var retryHeadersToCapture = []string{HeaderRetryAfter, HeaderRateLimitRemaining, HeaderRateLimitReset}
+
+// RetryPolicy decides whether and when a failed request is retried.
+//
+// A request is retried if it fails with a transport error (for idempotent methods only, since the server may have
+// processed the request), if the response has a retryable status code, or if the rate limit is exhausted.
+// The delay before the n-th retry is BaseDelay * 2^(n-1), capped at MaxDelay and randomized by Jitter,
+// unless the server tells how long to wait by Retry-After or X-RateLimit-Reset.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry, and MaxDelay is the upper limit of delays.
+	// A request is not retried if the server asks to wait longer than MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Jitter is the fraction of a delay that is randomized, between 0 and 1.
+	// For example, with Jitter 0.2, a delay of 10s becomes a random one between 8s and 10s.
+	Jitter float64
+
+	// RetryableStatusCodes are the status codes of responses that should be retried
+	RetryableStatusCodes []int
+}
+
+// DefaultRetryPolicy returns a retry policy which retries 429 and 5xx responses up to 3 attempts.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.2,
+		RetryableStatusCodes: []int{
+			consts.StatusTooManyRequests,
+			consts.StatusInternalServerError,
+			consts.StatusBadGateway,
+			consts.StatusServiceUnavailable,
+			consts.StatusGatewayTimeout,
+		},
+	}
+}
+
+// shouldRetry returns whether a request should be retried, given the result of the last attempt.
+func (p *RetryPolicy) shouldRetry(method string, statusCode int, headers map[string]string, err error) bool {
+	if err != nil {
+		// an error with a response comes from a response middleware, which is not a transport error
+		return statusCode == 0 && isIdempotentMethod(method)
+	}
+	if slices.Contains(p.RetryableStatusCodes, statusCode) {
+		return true
+	}
+	// Github responds with 403 or 429 when the primary rate limit is exhausted
+	return (statusCode == consts.StatusForbidden || statusCode == consts.StatusTooManyRequests) && headers[HeaderRateLimitRemaining] == "0"
+}
+
+// delay returns the delay before the next attempt, after the given number of failed attempts.
+// It returns false if the server asks to wait longer than MaxDelay, in which case the request should not be retried.
+func (p *RetryPolicy) delay(attempts int, headers map[string]string, now time.Time) (time.Duration, bool) {
+	if serverDelay, ok := serverRetryDelay(headers, now); ok {
+		return serverDelay, serverDelay <= p.MaxDelay
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * min(p.Jitter, 1) * float64(delay))
+	}
+	return delay, true
+}
+
+// serverRetryDelay returns how long the server asks to wait before retrying, by Retry-After or X-RateLimit-Reset.
+// It returns false if the server does not tell.
+func serverRetryDelay(headers map[string]string, now time.Time) (time.Duration, bool) {
+	if retryAfter := headers[HeaderRetryAfter]; retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return max(time.Duration(seconds)*time.Second, 0), true
+		}
+		if date, err := nethttp.ParseTime(retryAfter); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+	if headers[HeaderRateLimitRemaining] == "0" {
+		if reset, err := strconv.ParseInt(headers[HeaderRateLimitReset], 10, 64); err == nil {
+			return max(time.Unix(reset, 0).Sub(now), 0), true
+		}
+	}
+	return 0, false
+}
+
+// isIdempotentMethod returns whether a request with the given method can be sent again safely.
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case consts.MethodGet, consts.MethodHead, consts.MethodOptions, consts.MethodPut, consts.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+// scriptBuiltins are the builtins predeclared for middleware scripts, in addition to the Starlark universe.
+//
+//   - env(name, default="") returns the value of an environment variable, or default if it is not set
+//   - sha256(data) returns the SHA-256 digest of data, in lowercase hex
+//   - hmac_sha256(key, message) returns the HMAC-SHA256 of message with key, in lowercase hex
+//   - time is the time module, e.g. time.now().unix gives the current Unix timestamp in seconds,
+//     see https://pkg.go.dev/go.starlark.net/lib/time
+var scriptBuiltins = starlark.StringDict{
+	"env":         starlark.NewBuiltin("env", scriptEnv),
+	"sha256":      starlark.NewBuiltin("sha256", scriptSHA256),
+	"hmac_sha256": starlark.NewBuiltin("hmac_sha256", scriptHMACSHA256),
+	"time":        starlarktime.Module,
+}
+
+// scriptEnv implements env(name, default="").
+func scriptEnv(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, defaultValue string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "default?", &defaultValue); err != nil {
+		return nil, err
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return starlark.String(value), nil
+	}
+	return starlark.String(defaultValue), nil
+}
+
+// scriptSHA256 implements sha256(data).
+func scriptSHA256(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "data", &data); err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(data))
+	return starlark.String(hex.EncodeToString(digest[:])), nil
+}
+
+// scriptHMACSHA256 implements hmac_sha256(key, message).
+func scriptHMACSHA256(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key, message string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "message", &message); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return starlark.String(hex.EncodeToString(mac.Sum(nil))), nil
+}
@@ -0,0 +1,205 @@
+package http
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// DefaultScriptMaxExecutionSteps is the default limit of execution steps of each run of a middleware script
+	DefaultScriptMaxExecutionSteps = 1_000_000
+
+	// DefaultScriptTimeout is the default timeout of each run of a middleware script
+	DefaultScriptTimeout = time.Second
+
+	// scriptRequestHandlerName and scriptResponseHandlerName are the names of the functions handling requests and responses.
+	// A script defining scriptRequestHandlerName follows the function-call convention, see HTTPClientScriptMiddleware.
+	scriptRequestHandlerName  = "handle_request"
+	scriptResponseHandlerName = "handle_response"
+
+	// scriptLegacyResponseHandlerName is the name of the function handling responses in the global-variable convention
+	scriptLegacyResponseHandlerName = "handleResponse"
+
+	// scriptRequestName is the name of the Dict holding the current request
+	scriptRequestName = "request"
+)
+
+// scriptRequestNames are the names predeclared for each part of the current request, see newScriptRequestValues.
+var scriptRequestNames = []string{"path", "method", "headers", "pathParams", "queryParams", "body"}
+
+// scriptFileOptions returns the dialect options of middleware scripts.
+// They start from the legacy options, so that the flags of the resolve package (e.g. resolve.AllowRecursion)
+// still apply as they did before scripts were compiled with options,
+// and top-level if and for statements are allowed, so that scripts can set the results depending on the request.
+func scriptFileOptions() *syntax.FileOptions {
+	options := syntax.LegacyFileOptions()
+	options.Set = true
+	options.While = true
+	options.TopLevelControl = true
+	options.GlobalReassign = true
+	return options
+}
+
+// compiledScript is a compiled middleware script.
+type compiledScript struct {
+	// program is the compiled script
+	program *starlark.Program
+
+	// globals are the frozen global variables of a script following the function-call convention,
+	// which is run only once after compiling. It is nil for a script following the global-variable convention.
+	globals starlark.StringDict
+
+	// modTime is the modification time of the script file when it is loaded
+	modTime time.Time
+}
+
+// loadScript reads and compiles the script at the given path.
+func (m *HTTPClientScriptMiddleware) loadScript(scriptPath string) (*compiledScript, []byte, error) {
+	info, err := os.Stat(scriptPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	compiled, err := m.compileScript(scriptPath, script)
+	if err != nil {
+		return nil, nil, err
+	}
+	compiled.modTime = info.ModTime()
+	return compiled, script, nil
+}
+
+// compileScript compiles the script.
+// A script following the function-call convention is also run once, to define its functions.
+func (m *HTTPClientScriptMiddleware) compileScript(scriptPath string, script []byte) (*compiledScript, error) {
+	file, err := scriptFileOptions().Parse(scriptPath, script, 0)
+	if err != nil {
+		return nil, err
+	}
+	program, err := starlark.FileProgram(file, func(name string) bool {
+		_, ok := scriptBuiltins[name]
+		return ok || name == scriptRequestName || slices.Contains(scriptRequestNames, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	compiled := &compiledScript{program: program}
+	if !definesFunction(file, scriptRequestHandlerName) {
+		return compiled, nil
+	}
+
+	// there is no current request when the script is run for the first time
+	thread, done := m.newThread()
+	defer done()
+	globals, err := program.Init(thread, scriptPredeclared(starlark.None, nil))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := globals[scriptRequestHandlerName].(starlark.Callable); !ok {
+		return nil, fmt.Errorf("%s is not a function", scriptRequestHandlerName)
+	}
+	globals.Freeze()
+	compiled.globals = globals
+	return compiled, nil
+}
+
+// Reload loads the script from ScriptPath again.
+// The script in use is kept if the new one fails to compile.
+func (m *HTTPClientScriptMiddleware) Reload() error {
+	compiled, _, err := m.loadScript(m.ScriptPath)
+	if err != nil {
+		log.Err(err).Msgf("[HTTPClientScriptMiddleware.Reload] Failed to reload script, keep the current one, path: %s", m.ScriptPath)
+		return err
+	}
+	m.compiled.Store(compiled)
+	log.Info().Msgf("[HTTPClientScriptMiddleware.Reload] Script reloaded, path: %s", m.ScriptPath)
+	return nil
+}
+
+// Watch starts checking the modification time of the script file periodically in background,
+// and reloads the script when it changes.
+// It returns a function to stop watching.
+func (m *HTTPClientScriptMiddleware) Watch(interval time.Duration) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			info, err := os.Stat(m.ScriptPath)
+			if err != nil {
+				log.Err(err).Msgf("[HTTPClientScriptMiddleware.Watch] Failed to stat script, path: %s", m.ScriptPath)
+				continue
+			}
+			if info.ModTime().Equal(m.compiled.Load().modTime) {
+				continue
+			}
+			if err := m.Reload(); err != nil {
+				// do not try the broken file again until it changes
+				broken := *m.compiled.Load()
+				broken.modTime = info.ModTime()
+				m.compiled.Store(&broken)
+			}
+		}
+	}()
+	log.Info().Msgf("[HTTPClientScriptMiddleware.Watch] Watching script, path: %s, interval: %s", m.ScriptPath, interval)
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stop) })
+	}
+}
+
+// newThread creates a thread to run the script, limited by MaxExecutionSteps and Timeout.
+// The returned function must be called when the run is done.
+func (m *HTTPClientScriptMiddleware) newThread() (*starlark.Thread, func()) {
+	thread := &starlark.Thread{Name: "http_middleware_script"}
+	if m.MaxExecutionSteps > 0 {
+		thread.SetMaxExecutionSteps(m.MaxExecutionSteps)
+	}
+	if m.Timeout <= 0 {
+		return thread, func() {}
+	}
+	timer := time.AfterFunc(m.Timeout, func() {
+		thread.Cancel(fmt.Sprintf("script timed out after %s", m.Timeout))
+	})
+	return thread, func() { timer.Stop() }
+}
+
+// scriptPredeclared returns the values predeclared for the script, i.e. scriptBuiltins, the request,
+// and each part of the request in requestValues. The parts missing in requestValues are predeclared as None.
+func scriptPredeclared(request starlark.Value, requestValues starlark.StringDict) starlark.StringDict {
+	predeclared := maps.Clone(scriptBuiltins)
+	predeclared[scriptRequestName] = request
+	for _, name := range scriptRequestNames {
+		value, ok := requestValues[name]
+		if !ok {
+			value = starlark.None
+		}
+		predeclared[name] = value
+	}
+	return predeclared
+}
+
+// definesFunction returns whether the file defines a top-level function with the given name.
+func definesFunction(file *syntax.File, name string) bool {
+	for _, stmt := range file.Stmts {
+		if def, ok := stmt.(*syntax.DefStmt); ok && def.Name.Name == name {
+			return true
+		}
+	}
+	return false
+}
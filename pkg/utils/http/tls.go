@@ -0,0 +1,85 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions are the TLS settings of an HTTPClient.
+// Server certificates are verified against the system CA pool by default.
+type TLSOptions struct {
+	// CAFile is the path to a PEM file of CA certificates, which are trusted in addition to the system ones
+	CAFile string
+
+	// CertFile and KeyFile are the paths to the PEM files of the client certificate and its private key,
+	// which are presented to servers requiring mutual TLS. Both or neither should be set.
+	CertFile string
+	KeyFile  string
+
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS13, and zero means TLS 1.2
+	MinVersion uint16
+
+	// InsecureSkipVerify disables verification of server certificates.
+	// It should only be used for local stand-ins, e.g. a fake server with a self-signed certificate.
+	InsecureSkipVerify bool
+}
+
+// tlsConfig builds the TLS config from the options.
+func (o TLSOptions) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.MinVersion != 0 {
+		tlsConfig.MinVersion = o.MinVersion
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA file: %s", o.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, errors.New("client certificate and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// ParseTLSVersion parses a TLS version like "1.2" into its value in crypto/tls, e.g. tls.VersionTLS12.
+// An empty version is parsed as zero, i.e. the default one.
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version: %s", version)
+	}
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM writes a PEM block of the given type to a file in dir, and returns its path.
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// newClientCertificate creates a self-signed client certificate, and writes it and its private key to PEM files in dir.
+func newClientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "danta-auto-tool"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return cert, writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client-key.pem", "PRIVATE KEY", keyDER)
+}
+
+// startTLSServer starts a TLS server with a self-signed certificate, configured by configure before it starts.
+// It returns the server and the path to the PEM file of its certificate, to be trusted as a CA.
+func startTLSServer(t *testing.T, configure func(tlsConfig *tls.Config)) (*httptest.Server, string) {
+	t.Helper()
+	server := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.TLS = &tls.Config{}
+	if configure != nil {
+		configure(server.TLS)
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server, writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+}
+
+// getWithTLS sends a GET request to the server with the TLS options, and returns the error if any.
+func getWithTLS(t *testing.T, server *httptest.Server, tlsOptions TLSOptions) error {
+	t.Helper()
+	client, err := NewHTTPClient(server.URL, nil, nil, WithTLSOptions(tlsOptions))
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	statusCode, _, body, err := client.PerformGet(ctx, "/", nil, nil, nil)
+	if err == nil && (statusCode != nethttp.StatusOK || string(body) != "ok") {
+		t.Fatalf("PerformGet() = %d, %q", statusCode, body)
+	}
+	return err
+}
+
+func TestTLSOptionsServerVerification(t *testing.T) {
+	server, caFile := startTLSServer(t, nil)
+
+	if err := getWithTLS(t, server, TLSOptions{}); err == nil {
+		t.Error("request to a self-signed server with the default options succeeded, want an error")
+	}
+	if err := getWithTLS(t, server, TLSOptions{CAFile: caFile}); err != nil {
+		t.Errorf("request with the server certificate as CA error = %v", err)
+	}
+	if err := getWithTLS(t, server, TLSOptions{InsecureSkipVerify: true}); err != nil {
+		t.Errorf("request with InsecureSkipVerify error = %v", err)
+	}
+}
+
+func TestTLSOptionsMutualTLS(t *testing.T) {
+	clientCert, certFile, keyFile := newClientCertificate(t, t.TempDir())
+	server, caFile := startTLSServer(t, func(tlsConfig *tls.Config) {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		tlsConfig.ClientCAs.AddCert(clientCert)
+	})
+
+	if err := getWithTLS(t, server, TLSOptions{CAFile: caFile}); err == nil {
+		t.Error("request without a client certificate succeeded, want an error")
+	}
+	if err := getWithTLS(t, server, TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}); err != nil {
+		t.Errorf("request with the client certificate error = %v", err)
+	}
+}
+
+func TestTLSOptionsMinVersion(t *testing.T) {
+	server, caFile := startTLSServer(t, func(tlsConfig *tls.Config) {
+		tlsConfig.MaxVersion = tls.VersionTLS12
+	})
+
+	if err := getWithTLS(t, server, TLSOptions{CAFile: caFile}); err != nil {
+		t.Errorf("request to a TLS 1.2 server with the default min version error = %v", err)
+	}
+	if err := getWithTLS(t, server, TLSOptions{CAFile: caFile, MinVersion: tls.VersionTLS13}); err == nil {
+		t.Error("request to a TLS 1.2 server with min version 1.3 succeeded, want an error")
+	}
+}
+
+func TestTLSOptionsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := newClientCertificate(t, dir)
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	missing := filepath.Join(dir, "missing.pem")
+
+	tests := []struct {
+		name       string
+		tlsOptions TLSOptions
+	}{
+		{"missing CA file", TLSOptions{CAFile: missing}},
+		{"no certificate in CA file", TLSOptions{CAFile: notPEM}},
+		{"missing client certificate", TLSOptions{CertFile: missing, KeyFile: keyFile}},
+		{"missing client key", TLSOptions{CertFile: certFile, KeyFile: missing}},
+		{"certificate without key", TLSOptions{CertFile: certFile}},
+		{"key is not a certificate", TLSOptions{CertFile: keyFile, KeyFile: keyFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewHTTPClient("https://127.0.0.1", nil, nil, WithTLSOptions(tt.tlsOptions))
+			if err == nil || client != nil {
+				t.Errorf("NewHTTPClient() = %v, %v, want an error", client, err)
+			}
+		})
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"TLS1.3", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTLSVersion(tt.version)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseTLSVersion(%q) = %d, %v, want %d, error %v", tt.version, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
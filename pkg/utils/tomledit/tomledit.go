@@ -0,0 +1,570 @@
+package tomledit
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Document is a TOML document that supports targeted edits.
+//
+// Unlike unmarshalling the whole document into a struct and marshalling it back,
+// editing a Document leaves everything that is not edited byte-for-byte untouched,
+// including comments, key order, formatting, and fields that no struct models.
+//
+// Only the parts needed by the edits are located by a lightweight scanner:
+// table headers, array-of-tables headers and top-level key/value statements (which may span multiple lines,
+// e.g. multi-line strings and arrays). The document is validated by a real TOML parser before and after each edit.
+type Document struct {
+	content string
+}
+
+// Parse creates a Document from the content of a TOML file.
+// It returns an error if the content is not valid TOML.
+func Parse(content string) (*Document, error) {
+	if err := validate(content); err != nil {
+		return nil, err
+	}
+	return &Document{content: content}, nil
+}
+
+// String returns the current content of the document.
+func (d *Document) String() string {
+	return d.content
+}
+
+// ArrayTables returns the entries of the array of tables with the given name, e.g. "banners" for [[banners]],
+// each of which is decoded into a map.
+func (d *Document) ArrayTables(name string) ([]map[string]any, error) {
+	sections := d.sections(name)
+	tables := make([]map[string]any, 0, len(sections))
+	for _, sec := range sections {
+		table, err := d.decodeSection(sec)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// AppendArrayTable appends an entry to the array of tables with the given name, e.g. "banners" for [[banners]].
+// value must be a struct or a map, whose fields are written as key/value pairs of the new entry.
+// The new entry is placed right after the last existing entry, or at the end of the document if there is none.
+func (d *Document) AppendArrayTable(name string, value any) error {
+	body, err := encodeTable(value)
+	if err != nil {
+		return err
+	}
+	entry := "[[" + name + "]]\n" + body
+
+	sections := d.sections(name)
+	if len(sections) == 0 {
+		content := d.content
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		if content != "" {
+			content += "\n"
+		}
+		return d.update(content + entry)
+	}
+
+	// insert after the last statement of the last entry, keeping what follows (blank lines, comments) in place
+	insertAt := sections[len(sections)-1].contentEnd
+	prefix := d.content[:insertAt]
+	if !strings.HasSuffix(prefix, "\n") {
+		prefix += "\n"
+	}
+	return d.update(prefix + "\n" + entry + d.content[insertAt:])
+}
+
+// RemoveArrayTables removes the entries of the array of tables with the given name, for which match returns true.
+// match is given the entry decoded into a map.
+// It returns the number of removed entries.
+func (d *Document) RemoveArrayTables(name string, match func(table map[string]any) bool) (int, error) {
+	sections := d.sections(name)
+	content := d.content
+	removed := 0
+	// remove from the end, so that offsets of earlier sections remain valid
+	for i := len(sections) - 1; i >= 0; i-- {
+		sec := sections[i]
+		table, err := d.decodeSection(sec)
+		if err != nil {
+			return 0, err
+		}
+		if !match(table) {
+			continue
+		}
+		start, end := sec.start, sec.contentEnd
+		// the blank line separating the entry from the previous part is removed too, to avoid piling up blank lines
+		if start > 0 && isBlankLineBefore(content, start) {
+			start = lineStartBefore(content, start)
+		}
+		content = content[:start] + content[end:]
+		removed++
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, d.update(content)
+}
+
+// SetKey sets a top-level key to the given value, e.g. SetKey("change_log", "...").
+// If the key exists, only its value is replaced; otherwise the key is added after the last top-level key.
+func (d *Document) SetKey(key string, value any) error {
+	encodedValue, err := encodeValue(value)
+	if err != nil {
+		return err
+	}
+
+	items := scan(d.content)
+	for _, it := range items {
+		if it.kind == itemHeader {
+			break
+		}
+		if it.kind == itemKeyValue && it.key == key {
+			// keep the key as written and the line break ending the statement, replace everything in between
+			lineBreak := ""
+			if strings.HasSuffix(d.content[:it.end], "\r\n") {
+				lineBreak = "\r\n"
+			} else if strings.HasSuffix(d.content[:it.end], "\n") {
+				lineBreak = "\n"
+			}
+			return d.update(d.content[:it.valueStart] + "= " + encodedValue + lineBreak + d.content[it.end:])
+		}
+	}
+	statement := encodeKey(key) + " = " + encodedValue
+
+	// not found, insert after the last top-level key, or before the first table if there is no top-level key
+	insertAt := len(d.content)
+	for _, it := range items {
+		if it.kind == itemHeader {
+			if insertAt == len(d.content) {
+				insertAt = it.start
+			}
+			break
+		}
+		insertAt = it.end
+	}
+	prefix := d.content[:insertAt]
+	if prefix != "" && !strings.HasSuffix(prefix, "\n") {
+		prefix += "\n"
+	}
+	return d.update(prefix + statement + "\n" + d.content[insertAt:])
+}
+
+// update replaces the content of the document, after validating the new content.
+func (d *Document) update(content string) error {
+	if err := validate(content); err != nil {
+		log.Err(err).Msg("[tomledit.Document.update] Edited content is not valid TOML")
+		return fmt.Errorf("edited content is not valid TOML: %w", err)
+	}
+	d.content = content
+	return nil
+}
+
+// validate checks whether content is valid TOML.
+func validate(content string) error {
+	var v map[string]any
+	return toml.Unmarshal([]byte(content), &v)
+}
+
+// section is an array-of-tables entry in the document.
+type section struct {
+	// name is the name of the array of tables
+	name string
+
+	// start is the offset of the header line
+	start int
+
+	// contentEnd is the offset right after the last statement of the entry (or the header, if the entry is empty).
+	// Blank lines and comments after it are not considered part of the entry.
+	contentEnd int
+}
+
+// sections returns all entries of the array of tables with the given name.
+func (d *Document) sections(name string) []section {
+	items := scan(d.content)
+	sections := make([]section, 0)
+	var current *section
+	for _, it := range items {
+		switch it.kind {
+		case itemHeader:
+			if current != nil {
+				sections = append(sections, *current)
+				current = nil
+			}
+			if it.arrayTable && it.key == name {
+				current = &section{name: name, start: it.start, contentEnd: it.end}
+			}
+		case itemKeyValue:
+			if current != nil {
+				current.contentEnd = it.end
+			}
+		}
+	}
+	if current != nil {
+		sections = append(sections, *current)
+	}
+	return sections
+}
+
+// decodeSection decodes an array-of-tables entry into a map.
+func (d *Document) decodeSection(sec section) (map[string]any, error) {
+	var v map[string][]map[string]any
+	err := toml.Unmarshal([]byte(d.content[sec.start:sec.contentEnd]), &v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode [[%s]] entry: %w", sec.name, err)
+	}
+	entries := v[sec.name]
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("failed to decode [[%s]] entry: unexpected structure", sec.name)
+	}
+	return entries[0], nil
+}
+
+// itemKind is the kind of a scanned item.
+type itemKind int
+
+const (
+	// itemHeader is a table header ([table]) or an array-of-tables header ([[table]])
+	itemHeader itemKind = iota
+	// itemKeyValue is a key/value statement, which may span multiple lines
+	itemKeyValue
+)
+
+// item is a header or a key/value statement in the document.
+// Blank lines and comment lines are skipped.
+type item struct {
+	kind itemKind
+
+	// start is the offset of the beginning of the line
+	start int
+
+	// end is the offset right after the line break that ends the item (or the end of the document)
+	end int
+
+	// key is the table name for headers, and the key for key/value statements
+	key string
+
+	// arrayTable indicates whether a header is an array-of-tables header
+	arrayTable bool
+
+	// valueStart is the offset of the '=' of a key/value statement
+	valueStart int
+}
+
+// scan splits the document into headers and key/value statements.
+// It understands just enough TOML to never mistake the inside of a string or multi-line array for a header.
+func scan(content string) []item {
+	items := make([]item, 0)
+	n := len(content)
+	pos := 0
+	for pos < n {
+		lineStart := pos
+		i := skipSpaces(content, pos)
+		if i >= n {
+			break
+		}
+		switch content[i] {
+		case '\n', '\r', '#':
+			pos = lineEnd(content, i)
+			continue
+		case '[':
+			arrayTable := strings.HasPrefix(content[i:], "[[")
+			nameStart := i + 1
+			if arrayTable {
+				nameStart = i + 2
+			}
+			nameEnd := skipKey(content, nameStart, ']')
+			items = append(items, item{
+				kind:       itemHeader,
+				start:      lineStart,
+				end:        lineEnd(content, nameEnd),
+				key:        normalizeKey(content[nameStart:nameEnd]),
+				arrayTable: arrayTable,
+			})
+			pos = lineEnd(content, nameEnd)
+			continue
+		}
+
+		eq := skipKey(content, i, '=')
+		end := scanValue(content, eq+1)
+		items = append(items, item{
+			kind:       itemKeyValue,
+			start:      lineStart,
+			end:        end,
+			key:        normalizeKey(content[i:eq]),
+			valueStart: eq,
+		})
+		pos = end
+	}
+	return items
+}
+
+// scanValue scans a value starting at offset i, and returns the offset right after the line break ending it.
+func scanValue(content string, i int) int {
+	n := len(content)
+	depth := 0
+	for i < n {
+		switch {
+		case strings.HasPrefix(content[i:], `"""`):
+			i = skipMultiLineString(content, i, `"""`, true)
+		case strings.HasPrefix(content[i:], `'''`):
+			i = skipMultiLineString(content, i, `'''`, false)
+		case content[i] == '"':
+			i = skipString(content, i, '"', true)
+		case content[i] == '\'':
+			i = skipString(content, i, '\'', false)
+		case content[i] == '#':
+			for i < n && content[i] != '\n' {
+				i++
+			}
+		case content[i] == '[' || content[i] == '{':
+			depth++
+			i++
+		case content[i] == ']' || content[i] == '}':
+			depth--
+			i++
+		case content[i] == '\n':
+			i++
+			if depth <= 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return n
+}
+
+// skipKey skips a (possibly dotted and quoted) key starting at offset i, until the terminator outside quotes.
+// It returns the offset of the terminator, or the end of the line if not found.
+func skipKey(content string, i int, terminator byte) int {
+	n := len(content)
+	for i < n && content[i] != '\n' {
+		switch content[i] {
+		case '"':
+			i = skipString(content, i, '"', true)
+		case '\'':
+			i = skipString(content, i, '\'', false)
+		case terminator:
+			return i
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// skipString skips a single-line string starting at offset i, and returns the offset right after it.
+func skipString(content string, i int, quote byte, escapable bool) int {
+	n := len(content)
+	i++
+	for i < n && content[i] != '\n' {
+		if escapable && content[i] == '\\' {
+			i += 2
+			continue
+		}
+		if content[i] == quote {
+			return i + 1
+		}
+		i++
+	}
+	return i
+}
+
+// skipMultiLineString skips a multi-line string starting at offset i, and returns the offset right after it.
+func skipMultiLineString(content string, i int, delimiter string, escapable bool) int {
+	n := len(content)
+	i += len(delimiter)
+	for i < n {
+		if escapable && content[i] == '\\' {
+			i += 2
+			continue
+		}
+		if strings.HasPrefix(content[i:], delimiter) {
+			i += len(delimiter)
+			// up to 2 quotes are allowed right before the closing delimiter, e.g. """a""""
+			for extra := 0; extra < 2 && i < n && content[i] == delimiter[0]; extra++ {
+				i++
+			}
+			return i
+		}
+		i++
+	}
+	return n
+}
+
+// skipSpaces skips spaces and tabs starting at offset i.
+func skipSpaces(content string, i int) int {
+	for i < len(content) && (content[i] == ' ' || content[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// lineEnd returns the offset right after the line break of the line containing offset i (or the end of the document).
+func lineEnd(content string, i int) int {
+	idx := strings.IndexByte(content[i:], '\n')
+	if idx < 0 {
+		return len(content)
+	}
+	return i + idx + 1
+}
+
+// isBlankLineBefore checks whether the line right before offset lineStart (which is the beginning of a line) is blank.
+func isBlankLineBefore(content string, lineStart int) bool {
+	prevStart := lineStartBefore(content, lineStart)
+	return strings.TrimSpace(content[prevStart:lineStart]) == ""
+}
+
+// lineStartBefore returns the beginning of the line right before offset lineStart (which is the beginning of a line).
+func lineStartBefore(content string, lineStart int) int {
+	if lineStart == 0 {
+		return 0
+	}
+	idx := strings.LastIndexByte(content[:lineStart-1], '\n')
+	return idx + 1
+}
+
+// normalizeKey removes the whitespace around a key and its dots, e.g. "a . b " becomes "a.b".
+// Quotes are kept as is.
+func normalizeKey(key string) string {
+	parts := strings.Split(strings.TrimSpace(key), ".")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// encodeKey encodes a key, quoting it if it is not a bare key.
+func encodeKey(key string) string {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return quoteString(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+// encodeTable encodes the fields of a struct or a map as key/value lines.
+// Struct fields are named by their `toml` tags, in declaration order; map keys are sorted by go-toml.
+func encodeTable(value any) (string, error) {
+	v := reflect.Indirect(reflect.ValueOf(value))
+	if v.Kind() != reflect.Struct {
+		// fallback to go-toml for maps, whose output is already a list of key/value lines
+		b, err := toml.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	var sb strings.Builder
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := strings.Split(field.Tag.Get("toml"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = field.Name
+		}
+		encodedValue, err := encodeValue(v.Field(i).Interface())
+		if err != nil {
+			return "", fmt.Errorf("failed to encode field %s: %w", field.Name, err)
+		}
+		sb.WriteString(encodeKey(key) + " = " + encodedValue + "\n")
+	}
+	return sb.String(), nil
+}
+
+// encodeValue encodes a value as an inline TOML value.
+// Strings are always written as basic strings ("..."), to be consistent with hand-written files.
+func encodeValue(value any) (string, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return quoteString(v.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return "", fmt.Errorf("unsupported float value: %v", f)
+		}
+		s := strconv.FormatFloat(f, 'f', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+		return s, nil
+	case reflect.Slice, reflect.Array:
+		elems := make([]string, 0, v.Len())
+		for i := range v.Len() {
+			elem, err := encodeValue(v.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, elem)
+		}
+		return "[" + strings.Join(elems, ", ") + "]", nil
+	default:
+		// fallback to go-toml for other values, e.g. dates and inline tables
+		b, err := toml.Marshal(map[string]any{"v": value})
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(strings.TrimPrefix(string(b), "v = ")), nil
+	}
+}
+
+// quoteString quotes a string as a TOML basic string.
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f || r == utf8.RuneError {
+				sb.WriteString(fmt.Sprintf(`\u%04X`, r))
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,203 @@
+package tomledit
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// testBanner is a banner entry of the config file.
+type testBanner struct {
+	Title  string `toml:"title"`
+	Action string `toml:"action"`
+	Button string `toml:"button"`
+}
+
+// loadTestDocument parses the config file in testdata, and returns it with its original content.
+func loadTestDocument(t *testing.T) (*Document, string) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/tmp_wait_for_json_editor.toml")
+	if err != nil {
+		t.Fatalf("failed to read testdata: %v", err)
+	}
+	content := string(data)
+	doc, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return doc, content
+}
+
+// replaceOnce replaces old with new in s, failing the test unless old occurs exactly once.
+func replaceOnce(t *testing.T, s, old, new string) string {
+	t.Helper()
+	if n := strings.Count(s, old); n != 1 {
+		t.Fatalf("%q occurs %d times, want once", old, n)
+	}
+	return strings.Replace(s, old, new, 1)
+}
+
+func TestArrayTables(t *testing.T) {
+	doc, _ := loadTestDocument(t)
+	banners, err := doc.ArrayTables("banners")
+	if err != nil {
+		t.Fatalf("ArrayTables() error = %v", err)
+	}
+	var titles []string
+	for _, banner := range banners {
+		titles = append(titles, banner["title"].(string))
+	}
+	if got, want := strings.Join(titles, ","), "欢迎使用旦夕,新春快乐,期末加油"; got != want {
+		t.Errorf("ArrayTables() titles = %s, want %s", got, want)
+	}
+}
+
+func TestAppendArrayTable(t *testing.T) {
+	doc, original := loadTestDocument(t)
+	err := doc.AppendArrayTable("banners", testBanner{Title: `新 "Banner"`, Action: "https://example.com", Button: "打开"})
+	if err != nil {
+		t.Fatalf("AppendArrayTable() error = %v", err)
+	}
+
+	// the new entry goes right after the last one, before the comment of the next table
+	lastBanner := "action = \"https://danxi.fduhole.com/final\"\nbutton = \"查看\"\n"
+	want := replaceOnce(t, original, lastBanner, lastBanner+"\n[[banners]]\ntitle = \"新 \\\"Banner\\\"\"\naction = \"https://example.com\"\nbutton = \"打开\"\n")
+	if got := doc.String(); got != want {
+		t.Errorf("AppendArrayTable() content =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAppendArrayTableWithoutExistingEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"empty", "", "[[banners]]\ntitle = \"New\"\naction = \"a\"\nbutton = \"b\"\n"},
+		{
+			"trailing comment without line break",
+			"latest_version = \"1.4.5\"\nchange_log = '''\nline'''\n# end",
+			"latest_version = \"1.4.5\"\nchange_log = '''\nline'''\n# end\n\n[[banners]]\ntitle = \"New\"\naction = \"a\"\nbutton = \"b\"\n",
+		},
+		{
+			"other tables",
+			"stop_words = [\n  \"a\",\n]\n\n[[celebrations]]\ndate = \"01-01\"\n",
+			"stop_words = [\n  \"a\",\n]\n\n[[celebrations]]\ndate = \"01-01\"\n\n[[banners]]\ntitle = \"New\"\naction = \"a\"\nbutton = \"b\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse(tt.content)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if err := doc.AppendArrayTable("banners", testBanner{Title: "New", Action: "a", Button: "b"}); err != nil {
+				t.Fatalf("AppendArrayTable() error = %v", err)
+			}
+			if got := doc.String(); got != tt.want {
+				t.Errorf("AppendArrayTable() content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveArrayTables(t *testing.T) {
+	doc, original := loadTestDocument(t)
+	removed, err := doc.RemoveArrayTables("banners", func(table map[string]any) bool {
+		return table["title"] == "新春快乐"
+	})
+	if err != nil || removed != 1 {
+		t.Fatalf("RemoveArrayTables() = %d, %v, want 1, nil", removed, err)
+	}
+
+	// the entry and the blank line before it are removed, while its leading comment is kept
+	springBanner := "\n[[banners]]\ntitle = \"新春快乐\"\naction = \"https://danxi.fduhole.com/spring\"\nbutton = \"查看\"\ndescription = \"\"\"\n多行描述，\n包含 = 号和 [括号]\"\"\"\n"
+	want := replaceOnce(t, original, "# spring festival\n"+springBanner[1:], "# spring festival\n")
+	if got := doc.String(); got != want {
+		t.Errorf("RemoveArrayTables() content =\n%s\nwant\n%s", got, want)
+	}
+
+	removed, err = doc.RemoveArrayTables("banners", func(table map[string]any) bool {
+		return table["title"] == "不存在"
+	})
+	if err != nil || removed != 0 || doc.String() != want {
+		t.Errorf("RemoveArrayTables() of a missing entry = %d, %v, content changed %v", removed, err, doc.String() != want)
+	}
+}
+
+func TestAppendThenRemoveArrayTable(t *testing.T) {
+	doc, original := loadTestDocument(t)
+	if err := doc.AppendArrayTable("banners", testBanner{Title: "New", Action: "a", Button: "b"}); err != nil {
+		t.Fatalf("AppendArrayTable() error = %v", err)
+	}
+	removed, err := doc.RemoveArrayTables("banners", func(table map[string]any) bool {
+		return table["title"] == "New"
+	})
+	if err != nil || removed != 1 {
+		t.Fatalf("RemoveArrayTables() = %d, %v, want 1, nil", removed, err)
+	}
+	if got := doc.String(); got != original {
+		t.Errorf("content after append and remove =\n%s\nwant\n%s", got, original)
+	}
+}
+
+func TestSetKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value any
+		old   string
+		new   string
+	}{
+		{
+			"multi-line string",
+			"change_log", "- 新版本\n- 修复",
+			"change_log = \"\"\"\n- 修复了若干问题\n- 新增 Banner 配置，例如：\n[[banners]]\ntitle = \"这不是真的 Banner\"\n\"\"\"\n",
+			"change_log = \"- 新版本\\n- 修复\"\n",
+		},
+		{
+			"multi-line array",
+			"stop_words", []string{"广告"},
+			"stop_words = [\n  \"广告\", # ads\n  \"代写\",\n  \"[[banners]]\",\n]\n",
+			"stop_words = [\"广告\"]\n",
+		},
+		{
+			"literal string",
+			"user_agent", "DanXi/1.4.6",
+			"user_agent = 'DanXi/1.4.5 (+https://danxi.fduhole.com)'\n",
+			"user_agent = \"DanXi/1.4.6\"\n",
+		},
+		{
+			"new key after the last top-level key",
+			"min_version", "1.0.0",
+			"user_agent = 'DanXi/1.4.5 (+https://danxi.fduhole.com)'\n",
+			"user_agent = 'DanXi/1.4.5 (+https://danxi.fduhole.com)'\nmin_version = \"1.0.0\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, original := loadTestDocument(t)
+			if err := doc.SetKey(tt.key, tt.value); err != nil {
+				t.Fatalf("SetKey() error = %v", err)
+			}
+			want := replaceOnce(t, original, tt.old, tt.new)
+			if got := doc.String(); got != want {
+				t.Errorf("SetKey() content =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestSetKeyWithoutTopLevelKeys(t *testing.T) {
+	content := "# comment\n[[banners]]\ntitle = \"A\"\n"
+	doc, err := Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := doc.SetKey("latest_version", "1.4.5"); err != nil {
+		t.Fatalf("SetKey() error = %v", err)
+	}
+	if got, want := doc.String(), "# comment\nlatest_version = \"1.4.5\"\n[[banners]]\ntitle = \"A\"\n"; got != want {
+		t.Errorf("SetKey() content = %q, want %q", got, want)
+	}
+}
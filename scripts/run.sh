@@ -17,10 +17,15 @@ fi
 
 
 # Arguments
-# The configuration file
+# The configuration file, it is optional, for all configurations can be provided by environment variables
 CONFIG_FILE="./config/my_config.json"
+ARGS=()
+if [ -f "$CONFIG_FILE" ]; then
+    ARGS+=(--config-file "$CONFIG_FILE")
+else
+    echo "Config file $CONFIG_FILE not found, using environment variables only."
+fi
 
 # Run the binary
 echo "Running the binary..."
-$EXECUTABLE_PATH \
-    --config-file $CONFIG_FILE
+$EXECUTABLE_PATH "${ARGS[@]}"